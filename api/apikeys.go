@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// apiKeyRequest is the body of POST /api/keys. An empty tenant is the
+// default book for the bootstrap key and the book of the request otherwise.
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Tenant string   `json:"tenant"`
+	Scopes []string `json:"scopes"`
+}
+
+// issuedAPIKey is an API key along with the key itself, only shown when it is
+// created or rotated
+type issuedAPIKey struct {
+	storage.APIKey
+	Key string `json:"key"`
+}
+
+// withoutHash hides the hash of an API key
+func withoutHash(k storage.APIKey) storage.APIKey {
+	k.Hash = ""
+
+	return k
+}
+
+// managesAllKeys reports whether a request may manage the API keys of every
+// tenant, which only the bootstrap key and requests without --auth do.
+// Others only see the keys of the book they work on.
+func (h *ContactHandler) managesAllKeys(r *http.Request) bool {
+	p, ok := principal(r)
+
+	return !h.RequireAuth || ok && p.bootstrap
+}
+
+// getActiveAPIKey finds an unrevoked API key the request may manage. It must
+// be called with h.mu held.
+func (h *ContactHandler) getActiveAPIKey(r *http.Request, id string) (storage.APIKey, error) {
+	k, err := h.Storage.GetAPIKey(id)
+	if err != nil {
+		return k, err
+	}
+	if k.RevokedAt != nil || !h.managesAllKeys(r) && k.Tenant != tenant(r) {
+		return k, utils.ErrAPIKeyNotFound
+	}
+
+	return k, nil
+}
+
+func (h *ContactHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	keys, err := h.Storage.ListAPIKeys()
+	h.mu.Unlock()
+
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := []storage.APIKey{}
+	for _, v := range keys {
+		if h.managesAllKeys(r) || v.Tenant == tenant(r) {
+			res = append(res, withoutHash(v))
+		}
+	}
+
+	utils.SendSuccessResponse(w, "List of API keys", res)
+}
+
+// CreateAPIKey issues an API key with a name, book and scopes. The response
+// is the only one showing the key. Keys are issued for the book of the request
+// unless it manages the keys of every tenant.
+func (h *ContactHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var request apiKeyRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		sendMalformedJSON(w, err)
+		return
+	}
+	if !h.managesAllKeys(r) {
+		if request.Tenant != "" && request.Tenant != tenant(r) {
+			utils.SendCustomError(w, http.StatusForbidden, utils.ErrForeignTenant.Error())
+			return
+		}
+		request.Tenant = tenant(r)
+	}
+	fields := storage.ValidateAPIKey(request.Name, request.Tenant, request.Scopes)
+	if len(fields) > 0 {
+		sendFieldErrors(w, fields)
+		return
+	}
+
+	k, key, err := storage.NewAPIKey(request.Name, request.Tenant, request.Scopes)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err = h.Storage.SaveAPIKey(k)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(w, "API key created, keep it as it is not shown again", issuedAPIKey{withoutHash(k), key})
+}
+
+// RotateAPIKey replaces the key of an API key, keeping its name and scopes.
+// The previous key stops working at once.
+func (h *ContactHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k, err := h.getActiveAPIKey(r, id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	key, err := k.Rotate()
+	if err == nil {
+		err = h.Storage.SaveAPIKey(k)
+	}
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(w, "API key rotated, keep it as it is not shown again", issuedAPIKey{withoutHash(k), key})
+}
+
+// RevokeAPIKey stops an API key from working. It stays listed with the time
+// it was revoked.
+func (h *ContactHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k, err := h.getActiveAPIKey(r, id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	now := time.Now()
+	k.RevokedAt = &now
+	err = h.Storage.SaveAPIKey(k)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponseNoData(w, fmt.Sprintf("API key \"%v\" revoked", id))
+}
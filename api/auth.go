@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// APIKeyHeader carries the API key of a request. CardDAV clients, which
+// cannot set headers, send it as the password of basic authentication.
+const APIKeyHeader = "X-API-Key"
+
+// bootstrapPrincipal is who holds the bootstrap key from the configuration
+const bootstrapPrincipal = "bootstrap"
+
+// Principal is who a request is authenticated as. Tenant is the ID of the
+// book it works on. Claims holds the claims of a bearer token, nil for API
+// keys.
+type Principal struct {
+	ID     string
+	Name   string
+	Tenant string
+	Scopes []string
+	Claims map[string]interface{}
+	// bootstrap is set for the bootstrap key, the only one managing the API
+	// keys of every tenant
+	bootstrap bool
+}
+
+type principalContextKey struct{}
+
+type bookContextKey struct{}
+
+// principal returns who a request is authenticated as, if anyone
+func principal(r *http.Request) (Principal, bool) {
+	p, ok := r.Context().Value(principalContextKey{}).(Principal)
+
+	return p, ok
+}
+
+// requestBook returns the ID of the address book a request names, if any
+func requestBook(r *http.Request) string {
+	if id := chi.URLParam(r, "book"); id != "" {
+		return id
+	}
+
+	return r.URL.Query().Get("book")
+}
+
+// requestAPIKey returns the API key sent with a request
+func requestAPIKey(r *http.Request) string {
+	if v := r.Header.Get(APIKeyHeader); v != "" {
+		return v
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	return ""
+}
+
+// authenticateKey returns who an API key belongs to, the bootstrap key or an
+// unrevoked stored key
+func (h *ContactHandler) authenticateKey(key string) (Principal, bool) {
+	hash := storage.HashAPIKey(key)
+	if h.BootstrapKeyHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(h.BootstrapKeyHash)) == 1 {
+		return Principal{ID: bootstrapPrincipal, Name: bootstrapPrincipal, Scopes: []string{storage.ScopeAdmin}, bootstrap: true}, true
+	}
+
+	id := storage.APIKeyID(key)
+	if id == "" {
+		return Principal{}, false
+	}
+
+	h.mu.Lock()
+	k, err := h.Storage.GetAPIKey(id)
+	h.mu.Unlock()
+
+	if err != nil {
+		if !errors.Is(err, utils.ErrAPIKeyNotFound) {
+			log.Printf("Error reading API key %v: %v", id, err)
+		}
+		return Principal{}, false
+	}
+	if !k.Matches(key) {
+		return Principal{}, false
+	}
+
+	return Principal{ID: k.ID, Name: k.Name, Tenant: k.Tenant, Scopes: k.Scopes}, true
+}
+
+// bearerToken returns the token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(parts[1]), true
+}
+
+// Authenticate refuses requests without a valid API key, or bearer token when
+// h.JWT is set, with 401 when h.RequireAuth is set. Who the request is made
+// by is made available to handlers.
+func (h *ContactHandler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.RequireAuth {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if token, ok := bearerToken(r); ok && h.JWT != nil {
+			p, err := h.JWT.Verify(token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				utils.SendCustomError(w, http.StatusUnauthorized, fmt.Sprintf("invalid bearer token: %v", err))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+			return
+		}
+
+		key := requestAPIKey(r)
+		p, ok := h.authenticateKey(key)
+		if key == "" || !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="contactService"`)
+			utils.SendCustomError(w, http.StatusUnauthorized, utils.ErrUnauthenticated.Error())
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	})
+}
+
+// scopeRoles is the role in an address book that each scope needs
+var scopeRoles = map[string]string{
+	storage.ScopeRead:  storage.RoleRead,
+	storage.ScopeWrite: storage.RoleEdit,
+	storage.ScopeAdmin: storage.RoleOwner,
+}
+
+// Require refuses requests whose principal lacks a scope with 403, and
+// checks the role the scope needs in the address book of the request, see
+// Permit
+func (h *ContactHandler) Require(scope string) func(http.Handler) http.Handler {
+	return h.Permit(scope, scopeRoles[scope])
+}
+
+// Permit is where every permission is checked. It refuses requests whose
+// principal lacks scope with 403. A request naming an address book, with the
+// book URL parameter or query parameter, is refused with 404 unless the
+// principal is in the book and with 403 unless their role grants role; it
+// then works on the contacts of that book. A principal owns their own book.
+// Without h.RequireAuth there are no principals, only the existence of the
+// book is checked.
+func (h *ContactHandler) Permit(scope string, role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := principal(r)
+			if h.RequireAuth && (!ok || !storage.GrantsScope(p.Scopes, scope)) {
+				utils.SendCustomError(w, http.StatusForbidden, fmt.Sprintf("%v: %v", utils.ErrForbidden, scope))
+				return
+			}
+
+			id := requestBook(r)
+			if id == "" || id == user(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.mu.Lock()
+			book, err := h.Storage.GetAddressBook(id)
+			h.mu.Unlock()
+
+			if err == nil && h.RequireAuth && book.Role(user(r)) == "" {
+				err = utils.ErrBookNotFound
+			}
+			if err != nil {
+				sendStorageError(w, err, id)
+				return
+			}
+			if h.RequireAuth && !storage.GrantsRole(book.Role(user(r)), role) {
+				utils.SendCustomError(w, http.StatusForbidden, fmt.Sprintf("%v: %v", utils.ErrBookForbidden, role))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), bookContextKey{}, book.ID)))
+		})
+	}
+}
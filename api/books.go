@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// addressBookRequest is the body of POST /api/books
+type addressBookRequest struct {
+	Name    string               `json:"name"`
+	Members []storage.BookMember `json:"members"`
+}
+
+// memberRequest is the body of PUT /api/books/{book}/members/{user}
+type memberRequest struct {
+	Role string `json:"role"`
+}
+
+// ListAddressBooks lists the address books the user owns or is a member of
+func (h *ContactHandler) ListAddressBooks(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	books, err := h.Storage.ListAddressBooks()
+	h.mu.Unlock()
+
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := []storage.AddressBook{}
+	for _, v := range books {
+		if v.Role(user(r)) != "" {
+			res = append(res, v)
+		}
+	}
+
+	utils.SendSuccessResponse(w, "List of address books", res)
+}
+
+// CreateAddressBook creates an address book owned by the user, with the
+// members given
+func (h *ContactHandler) CreateAddressBook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var request addressBookRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		sendMalformedJSON(w, err)
+		return
+	}
+	fields := storage.ValidateBookName(request.Name)
+	for _, v := range request.Members {
+		fields = append(fields, storage.ValidateMember(v.User, v.Role)...)
+	}
+	if len(fields) > 0 {
+		sendFieldErrors(w, fields)
+		return
+	}
+
+	book := storage.NewAddressBook(request.Name, user(r))
+	for _, v := range request.Members {
+		if v.User != book.Owner {
+			book.SetMember(v.User, v.Role)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err = h.Storage.SaveAddressBook(book)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(w, "Address book created", book)
+}
+
+// GetAddressBook shows an address book to its owner and members
+func (h *ContactHandler) GetAddressBook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "book")
+
+	h.mu.Lock()
+	book, err := h.Storage.GetAddressBook(id)
+	h.mu.Unlock()
+
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Address book \"%v\"", id), book)
+}
+
+// SetBookMember gives a user a role in an address book, adding them to its
+// members if they were not in yet
+func (h *ContactHandler) SetBookMember(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "book")
+	member := chi.URLParam(r, "user")
+
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var request memberRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		sendMalformedJSON(w, err)
+		return
+	}
+	fields := storage.ValidateMember(member, request.Role)
+	if len(fields) > 0 {
+		sendFieldErrors(w, fields)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	book, err := h.Storage.GetAddressBook(id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+	if member == book.Owner {
+		sendFieldErrors(w, []utils.FieldError{{Field: "user", Code: utils.CodeInvalidValue, Message: "the owner of the book cannot be a member"}})
+		return
+	}
+
+	book.SetMember(member, request.Role)
+	err = h.Storage.SaveAddressBook(book)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("\"%v\" can now %v address book \"%v\"", member, request.Role, id), book)
+}
+
+// RemoveBookMember takes a user out of an address book
+func (h *ContactHandler) RemoveBookMember(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "book")
+	member := chi.URLParam(r, "user")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	book, err := h.Storage.GetAddressBook(id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+	if !book.RemoveMember(member) {
+		utils.SendCustomError(w, http.StatusNotFound, fmt.Sprintf("\"%v\" is not a member of address book \"%v\"", member, id))
+		return
+	}
+
+	err = h.Storage.SaveAddressBook(book)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("\"%v\" removed from address book \"%v\"", member, id), book)
+}
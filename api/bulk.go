@@ -0,0 +1,230 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Bulk modes: atomic stores all operations or none, best_effort stores those
+// that succeed
+const (
+	bulkAtomic     = "atomic"
+	bulkBestEffort = "best_effort"
+)
+
+type bulkRequest struct {
+	Mode       string          `json:"mode"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+// bulkOperation carries the new contact of a create, the changes with the ID
+// of an update, or the ID of a delete
+type bulkOperation struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id"`
+	Contact json.RawMessage `json:"contact"`
+	// decoded is a new contact read from another format than JSON
+	decoded *storage.Contact
+}
+
+type bulkItemError struct {
+	Message    string             `json:"message"`
+	Fields     []utils.FieldError `json:"fields,omitempty"`
+	ExistingID string             `json:"existing_id,omitempty"`
+}
+
+type bulkItemResult struct {
+	Index   int              `json:"index"`
+	Op      string           `json:"op"`
+	ID      string           `json:"id,omitempty"`
+	Status  int              `json:"status"`
+	Error   *bulkItemError   `json:"error,omitempty"`
+	Contact *storage.Contact `json:"contact,omitempty"`
+}
+
+// bulkFailure describes a failed operation
+func bulkFailure(index int, op string, id string, err error, fields []utils.FieldError) bulkItemResult {
+	status, message := storageErrorStatus(err, id)
+	itemErr := bulkItemError{Message: message, Fields: fields}
+
+	var fieldErr utils.FieldError
+	var duplicateErr utils.DuplicateError
+	if errors.As(err, &fieldErr) && len(fields) == 0 {
+		itemErr.Fields = []utils.FieldError{fieldErr}
+	}
+	if errors.As(err, &duplicateErr) {
+		itemErr.ExistingID = duplicateErr.ExistingID
+	}
+
+	return bulkItemResult{Index: index, Op: op, ID: id, Status: status, Error: &itemErr}
+}
+
+// prepareBulk validates and normalizes an operation the way /api/add and
+// /api/edit do. New contacts get their ID, but are not enriched yet.
+func (h *ContactHandler) prepareBulk(r *http.Request, index int, item bulkOperation) (storage.BulkOperation, *bulkItemResult) {
+	op := storage.BulkOperation{Index: index, Op: item.Op, ID: item.ID}
+	fail := func(err error, fields []utils.FieldError) (storage.BulkOperation, *bulkItemResult) {
+		res := bulkFailure(index, item.Op, item.ID, err, fields)
+		return op, &res
+	}
+
+	switch item.Op {
+	case storage.BulkCreate, storage.BulkUpdate:
+		var c storage.Contact
+		var fields []utils.FieldError
+		switch {
+		case item.decoded != nil:
+			c = *item.decoded
+		case len(item.Contact) == 0 || json.Unmarshal(item.Contact, &c) != nil:
+			return fail(utils.ErrBulkWrongFormat, nil)
+		default:
+			fields = storage.ReadOnlyFieldErrors(item.Contact)
+		}
+
+		profile := storage.ProfileCreate
+		if item.Op == storage.BulkUpdate {
+			profile = storage.ProfileEdit
+		}
+		fields = append(fields, h.rules().Validate(profile, c)...)
+		if len(fields) > 0 {
+			return fail(utils.ErrValidationFailed, fields)
+		}
+
+		if item.Op == storage.BulkUpdate {
+			// Normalized by the storage, which knows the stored country
+			err := json.Unmarshal(item.Contact, &op.Edit)
+			if err != nil {
+				return fail(utils.ErrBulkWrongFormat, nil)
+			}
+			op.ID = op.Edit.ID
+			op.Rules = h.rules().Edit
+			return op, nil
+		}
+
+		fields = c.Normalize(h.rules().Create)
+		if len(fields) > 0 {
+			return fail(utils.ErrValidationFailed, fields)
+		}
+		c.ID = uuid.New().String()
+		c.CreatedBy = actor(r)
+		op.Contact = c
+		op.ID = c.ID
+	case storage.BulkDelete:
+	default:
+		return fail(utils.ErrBulkWrongFormat, nil)
+	}
+
+	return op, nil
+}
+
+// Bulk creates, updates and deletes many contacts with one request. Every
+// operation goes through the validation of /api/add or /api/edit and gets its
+// own result. In atomic mode, the default, nothing is stored unless all
+// operations succeed.
+func (h *ContactHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var request bulkRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		sendMalformedJSON(w, err)
+		return
+	}
+	if request.Mode == "" {
+		request.Mode = bulkAtomic
+	}
+	if request.Mode != bulkAtomic && request.Mode != bulkBestEffort {
+		utils.SendCustomError(w, http.StatusBadRequest, utils.ErrBulkModeWrongFormat.Error())
+		return
+	}
+	atomic := request.Mode == bulkAtomic
+
+	results, failed, err := h.runBulk(r, request.Operations, atomic)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if failed && atomic {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		utils.SendSuccessResponse(w, "Bulk request failed, nothing was changed", results)
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("%v operations processed", len(results)), results)
+}
+
+// runBulk validates, enriches and stores operations. It returns a result per
+// operation and whether any of them failed.
+func (h *ContactHandler) runBulk(r *http.Request, items []bulkOperation, atomic bool) ([]bulkItemResult, bool, error) {
+	results := make([]bulkItemResult, len(items))
+	var ops []storage.BulkOperation
+	failed := false
+	for i, item := range items {
+		op, failure := h.prepareBulk(r, i, item)
+		if failure == nil && op.Op == storage.BulkCreate {
+			// Enrichment calls external APIs, so it runs outside the lock
+			err := op.Contact.FillMissingFields()
+			if err != nil {
+				res := bulkFailure(i, op.Op, op.ID, err, nil)
+				failure = &res
+			}
+		}
+		if failure != nil {
+			results[i] = *failure
+			failed = true
+			continue
+		}
+		ops = append(ops, op)
+	}
+
+	if failed && atomic {
+		for _, op := range ops {
+			results[op.Index] = bulkFailure(op.Index, op.Op, op.ID, utils.ErrBulkSkipped, nil)
+		}
+		return results, true, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stored, err := h.store(r).Bulk(ops, atomic)
+	if err != nil {
+		return nil, true, err
+	}
+
+	actions := map[string]string{
+		storage.BulkCreate: storage.ActionCreate,
+		storage.BulkUpdate: storage.ActionUpdate,
+		storage.BulkDelete: storage.ActionDelete,
+	}
+	var changes []recordedChange
+	for _, v := range stored {
+		if v.Err != nil {
+			failed = true
+			results[v.Index] = bulkFailure(v.Index, v.Op, v.ID, v.Err, v.Fields)
+			continue
+		}
+
+		changes = append(changes, recordedChange{action: actions[v.Op], before: v.Before, after: v.After})
+		results[v.Index] = bulkItemResult{Index: v.Index, Op: v.Op, ID: v.ID, Status: http.StatusOK}
+		if v.Op != storage.BulkDelete {
+			results[v.Index].Contact = v.After
+		}
+	}
+	h.recordAll(r, changes)
+
+	return results, failed, nil
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+func TestBulkRecordsEveryOperation(t *testing.T) {
+	s := newTestServer(t)
+	_, key := s.issueKey("acme", storage.ScopeWrite)
+	ann := s.addContact(key, "Ann Lee", "+1 202 555 0100")
+
+	body := map[string]interface{}{"operations": []map[string]interface{}{
+		{"op": storage.BulkUpdate, "contact": map[string]string{"id": ann.ID, "name": "Ann Smith"}},
+		{"op": storage.BulkCreate, "contact": map[string]string{"name": "Bob Ray", "phone": "+1 202 555 0101", "gender": "male", "country": "US"}},
+		{"op": storage.BulkDelete, "id": ann.ID},
+	}}
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/bulk", key, body)
+
+	var page changesPage
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/changes", key, nil).decode(t, &page)
+	want := []string{storage.ActionCreate, storage.ActionUpdate, storage.ActionCreate, storage.ActionDelete}
+	if len(page.Changes) != len(want) {
+		t.Fatalf("%v changes, want %v", len(page.Changes), len(want))
+	}
+	for i, c := range page.Changes {
+		if c.Seq != int64(i+1) || c.Type != want[i] {
+			t.Errorf("change %v is %v %v, want %v %v", i, c.Seq, c.Type, i+1, want[i])
+		}
+	}
+
+	var revisions []storage.Revision
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/contacts/"+ann.ID+"/history", key, nil).decode(t, &revisions)
+	if len(revisions) != 3 || revisions[2].Version != 3 || revisions[2].Action != storage.ActionDelete {
+		t.Fatalf("history %+v, want create, update and delete", revisions)
+	}
+}
@@ -0,0 +1,860 @@
+package api
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Paths of the CardDAV resources. Contacts are the cards of a single address
+// book, named after their ID.
+const (
+	davRootPath      = "/dav/"
+	davPrincipalPath = "/dav/principal/"
+	davHomePath      = "/dav/addressbooks/"
+	davBookPath      = "/dav/addressbooks/contacts/"
+	davCardSuffix    = ".vcf"
+)
+
+// davSyncTokenPrefix starts sync tokens, followed by the time they were issued
+// in Unix milliseconds
+const davSyncTokenPrefix = "urn:x-contactservice:sync:"
+
+// davStarted bounds sync tokens: purges of an earlier run are unknown, so
+// tokens issued before it are not trusted
+var davStarted = time.Now().UTC()
+
+type davKind int
+
+const (
+	davRoot davKind = iota
+	davPrincipal
+	davHome
+	davBook
+	davCard
+)
+
+var (
+	propResourceType      = xml.Name{Space: nsDAV, Local: "resourcetype"}
+	propDisplayName       = xml.Name{Space: nsDAV, Local: "displayname"}
+	propPrincipal         = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+	propPrincipalURL      = xml.Name{Space: nsDAV, Local: "principal-URL"}
+	propPrivileges        = xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}
+	propSupportedReports  = xml.Name{Space: nsDAV, Local: "supported-report-set"}
+	propSyncToken         = xml.Name{Space: nsDAV, Local: "sync-token"}
+	propETag              = xml.Name{Space: nsDAV, Local: "getetag"}
+	propContentType       = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+	propLastModified      = xml.Name{Space: nsDAV, Local: "getlastmodified"}
+	propHomeSet           = xml.Name{Space: nsCardDAV, Local: "addressbook-home-set"}
+	propBookDescription   = xml.Name{Space: nsCardDAV, Local: "addressbook-description"}
+	propSupportedData     = xml.Name{Space: nsCardDAV, Local: "supported-address-data"}
+	propAddressData       = xml.Name{Space: nsCardDAV, Local: "address-data"}
+	propCTag              = xml.Name{Space: nsCS, Local: "getctag"}
+	reportMultiget        = xml.Name{Space: nsCardDAV, Local: "addressbook-multiget"}
+	reportQuery           = xml.Name{Space: nsCardDAV, Local: "addressbook-query"}
+	reportSyncCollection  = xml.Name{Space: nsDAV, Local: "sync-collection"}
+	conditionSupported    = xml.Name{Space: nsDAV, Local: "supported-report"}
+	conditionValidToken   = xml.Name{Space: nsDAV, Local: "valid-sync-token"}
+	conditionValidAddress = xml.Name{Space: nsCardDAV, Local: "valid-address-data"}
+)
+
+// davAllProps are the properties returned for allprop and propname
+var davAllProps = map[davKind][]xml.Name{
+	davRoot:      {propResourceType, propPrincipal, propHomeSet, propPrivileges},
+	davPrincipal: {propResourceType, propDisplayName, propPrincipal, propPrincipalURL, propHomeSet, propPrivileges},
+	davHome:      {propResourceType, propPrincipal, propPrivileges},
+	davBook: {propResourceType, propDisplayName, propPrincipal, propPrivileges, propBookDescription,
+		propSupportedData, propSupportedReports, propSyncToken, propCTag},
+	davCard: {propResourceType, propDisplayName, propPrivileges, propETag, propContentType, propLastModified},
+}
+
+// davResource is a resource listed in a multistatus response
+type davResource struct {
+	kind    davKind
+	href    string
+	contact storage.Contact
+}
+
+// davBookState holds the properties of the address book that depend on its
+// contacts
+type davBookState struct {
+	syncToken string
+	ctag      string
+}
+
+// davResolve maps a request path onto a resource and, for cards, the ID of
+// the contact
+func davResolve(path string) (davKind, string, bool) {
+	switch strings.TrimSuffix(path, "/") + "/" {
+	case davRootPath:
+		return davRoot, "", true
+	case davPrincipalPath:
+		return davPrincipal, "", true
+	case davHomePath:
+		return davHome, "", true
+	case davBookPath:
+		return davBook, "", true
+	}
+
+	name := strings.TrimPrefix(path, davBookPath)
+	id := strings.TrimSuffix(name, davCardSuffix)
+	if name == path || id == name || id == "" || strings.Contains(id, "/") {
+		return davRoot, "", false
+	}
+
+	return davCard, id, true
+}
+
+func davCardHref(id string) string {
+	return davBookPath + url.PathEscape(id) + davCardSuffix
+}
+
+// davETag changes with every change of the contact, as all of them stamp
+// UpdatedAt
+func davETag(c storage.Contact) string {
+	return fmt.Sprintf(`"%x"`, c.UpdatedAt.UnixNano())
+}
+
+func davSyncToken(t time.Time) string {
+	return davSyncTokenPrefix + strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header names etag
+func etagMatches(header string, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// davPreconditions checks If-Match and If-None-Match of a change against the
+// current state of the card
+func davPreconditions(r *http.Request, exists bool, etag string) bool {
+	if v := r.Header.Get("If-Match"); v != "" && (!exists || !etagMatches(v, etag)) {
+		return false
+	}
+	if v := r.Header.Get("If-None-Match"); v != "" && exists && etagMatches(v, etag) {
+		return false
+	}
+
+	return true
+}
+
+// davStorageError answers with the status matching err, see
+// storageErrorStatus
+func davStorageError(w http.ResponseWriter, err error, id string) {
+	status, message := storageErrorStatus(err, id)
+	davError(w, status, message)
+}
+
+// davBook works out the changing properties of the address book. It must be
+// called with h.mu held.
+func (h *ContactHandler) davBook(r *http.Request) (davBookState, error) {
+	contacts, err := h.store(r).List()
+	if err != nil {
+		return davBookState{}, err
+	}
+	trashed, err := h.store(r).ListTrash()
+	if err != nil {
+		return davBookState{}, err
+	}
+
+	var latest time.Time
+	for _, c := range contacts {
+		if c.UpdatedAt.After(latest) {
+			latest = c.UpdatedAt
+		}
+	}
+	for _, c := range trashed {
+		if c.DeletedAt.After(latest) {
+			latest = *c.DeletedAt
+		}
+	}
+
+	return davBookState{
+		syncToken: davSyncToken(time.Now()),
+		ctag:      fmt.Sprintf(`"%x-%x"`, latest.UnixNano(), len(contacts)),
+	}, nil
+}
+
+// davSince returns the time a sync token was issued at. The empty token of an
+// initial sync gives the zero time. Tokens from before the last purge are
+// refused, as the contacts purged since cannot be reported as deleted.
+func (h *ContactHandler) davSince(r *http.Request, token string) (time.Time, bool) {
+	if token == "" {
+		return time.Time{}, true
+	}
+
+	v := strings.TrimPrefix(token, davSyncTokenPrefix)
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if v == token || err != nil {
+		return time.Time{}, false
+	}
+	since := time.UnixMilli(ms)
+
+	if since.Before(davStarted.Truncate(time.Millisecond)) || since.Before(h.purgedAt[tenant(r)].Truncate(time.Millisecond)) {
+		return time.Time{}, false
+	}
+
+	return since, true
+}
+
+// davProperty renders the value of a property, reporting false for
+// properties the resource does not have
+func davProperty(res davResource, name xml.Name, book davBookState) (string, bool) {
+	el := func(space string, local string) string {
+		return davElement(xml.Name{Space: space, Local: local}, "")
+	}
+
+	switch name {
+	case propResourceType:
+		switch res.kind {
+		case davCard:
+			return "", true
+		case davPrincipal:
+			return el(nsDAV, "principal"), true
+		case davBook:
+			return el(nsDAV, "collection") + el(nsCardDAV, "addressbook"), true
+		default:
+			return el(nsDAV, "collection"), true
+		}
+	case propPrincipal:
+		return davHref(davPrincipalPath), true
+	case propPrivileges:
+		privilege := xml.Name{Space: nsDAV, Local: "privilege"}
+		return davElement(privilege, el(nsDAV, "read")) + davElement(privilege, el(nsDAV, "write")), true
+	}
+
+	switch res.kind {
+	case davRoot, davPrincipal:
+		switch {
+		case name == propHomeSet:
+			return davHref(davHomePath), true
+		case name == propPrincipalURL && res.kind == davPrincipal:
+			return davHref(davPrincipalPath), true
+		case name == propDisplayName && res.kind == davPrincipal:
+			return "Contacts owner", true
+		}
+	case davBook:
+		switch name {
+		case propDisplayName:
+			return "Contacts", true
+		case propBookDescription:
+			return "All contacts of the service", true
+		case propSupportedData:
+			return fmt.Sprintf(`<C:address-data-type content-type="%v" version="4.0"/>`, storage.VCardContentType), true
+		case propSupportedReports:
+			var b strings.Builder
+			for _, report := range []xml.Name{reportMultiget, reportQuery, reportSyncCollection} {
+				b.WriteString(davElement(xml.Name{Space: nsDAV, Local: "supported-report"},
+					davElement(xml.Name{Space: nsDAV, Local: "report"}, davElement(report, ""))))
+			}
+			return b.String(), true
+		case propSyncToken:
+			return davEscape(book.syncToken), true
+		case propCTag:
+			return davEscape(book.ctag), true
+		}
+	case davCard:
+		switch name {
+		case propDisplayName:
+			return davEscape(res.contact.Name), true
+		case propETag:
+			return davEscape(davETag(res.contact)), true
+		case propContentType:
+			return storage.VCardContentType + "; charset=utf-8", true
+		case propLastModified:
+			return res.contact.UpdatedAt.UTC().Format(http.TimeFormat), true
+		case propAddressData:
+			var b strings.Builder
+			_ = storage.EncodeVCard(&b, res.contact)
+			return davEscape(b.String()), true
+		}
+	}
+
+	return "", false
+}
+
+// davPropResponse lists the properties of a resource, those it does not have
+// with 404 Not Found. With namesOnly set values are left out, as for propname.
+func davPropResponse(res davResource, names []xml.Name, book davBookState, namesOnly bool) davResponse {
+	var found, missing strings.Builder
+	for _, name := range names {
+		value, ok := davProperty(res, name, book)
+		switch {
+		case !ok:
+			missing.WriteString(davElement(name, ""))
+		case namesOnly:
+			found.WriteString(davElement(name, ""))
+		default:
+			found.WriteString(davElement(name, value))
+		}
+	}
+
+	response := davResponse{Href: res.href}
+	if found.Len() > 0 {
+		response.Propstats = append(response.Propstats, davPropstat{Prop: davRawXML{found.String()}, Status: davStatus(http.StatusOK)})
+	}
+	if missing.Len() > 0 {
+		response.Propstats = append(response.Propstats, davPropstat{Prop: davRawXML{missing.String()}, Status: davStatus(http.StatusNotFound)})
+	}
+
+	return response
+}
+
+// requestedProps returns the names of a DAV:prop element, or defaults when
+// the element is missing or empty
+func requestedProps(prop davNames, defaults ...xml.Name) []xml.Name {
+	if len(prop.Names) == 0 {
+		return defaults
+	}
+
+	names := make([]xml.Name, 0, len(prop.Names))
+	for _, v := range prop.Names {
+		names = append(names, v.XMLName)
+	}
+
+	return names
+}
+
+// readDAVBody reads the XML body of a request into v, an empty body leaving v
+// untouched
+func readDAVBody(r *http.Request, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return err
+	}
+
+	return xml.Unmarshal(body, v)
+}
+
+// DAVOptions announces the WebDAV classes and CardDAV support
+func (h *ContactHandler) DAVOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("DAV", "1, 3, addressbook")
+	w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusOK)
+}
+
+// DAVPropfind lists the properties of a resource and, unless the Depth header
+// is 0, those of its members
+func (h *ContactHandler) DAVPropfind(w http.ResponseWriter, r *http.Request) {
+	kind, id, ok := davResolve(r.URL.Path)
+	if !ok {
+		davError(w, http.StatusNotFound, "no such resource")
+		return
+	}
+
+	var request davPropfind
+	err := readDAVBody(r, &request)
+	if err != nil {
+		davError(w, http.StatusBadRequest, fmt.Sprintf("malformed XML: %v", err))
+		return
+	}
+	namesOnly := request.PropName != nil
+	allProps := namesOnly || request.AllProp != nil || len(request.Prop.Names) == 0
+	members := r.Header.Get("Depth") != "0"
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	resources := []davResource{{kind: kind}}
+	switch kind {
+	case davRoot:
+		resources[0].href = davRootPath
+		if members {
+			resources = append(resources, davResource{kind: davPrincipal, href: davPrincipalPath}, davResource{kind: davHome, href: davHomePath})
+		}
+	case davPrincipal:
+		resources[0].href = davPrincipalPath
+	case davHome:
+		resources[0].href = davHomePath
+		if members {
+			resources = append(resources, davResource{kind: davBook, href: davBookPath})
+		}
+	case davBook:
+		resources[0].href = davBookPath
+		if members {
+			contacts, err := h.store(r).List()
+			if err != nil {
+				davStorageError(w, err, "")
+				return
+			}
+			sort.Slice(contacts, func(i, j int) bool { return contacts[i].ID < contacts[j].ID })
+			for _, c := range contacts {
+				resources = append(resources, davResource{kind: davCard, href: davCardHref(c.ID), contact: c})
+			}
+		}
+	case davCard:
+		c, err := h.store(r).Get(id)
+		if err != nil {
+			davStorageError(w, err, id)
+			return
+		}
+		resources[0].href, resources[0].contact = davCardHref(id), c
+	}
+
+	var book davBookState
+	if kind == davBook || (kind == davHome && members) {
+		book, err = h.davBook(r)
+		if err != nil {
+			davStorageError(w, err, "")
+			return
+		}
+	}
+
+	var ms davMultistatus
+	for _, res := range resources {
+		names := requestedProps(request.Prop)
+		if allProps {
+			names = davAllProps[res.kind]
+		}
+		ms.Responses = append(ms.Responses, davPropResponse(res, names, book, namesOnly))
+	}
+
+	writeMultistatus(w, ms)
+}
+
+// DAVReport answers the addressbook-multiget, addressbook-query and
+// sync-collection reports of the address book
+func (h *ContactHandler) DAVReport(w http.ResponseWriter, r *http.Request) {
+	kind, _, ok := davResolve(r.URL.Path)
+	if !ok {
+		davError(w, http.StatusNotFound, "no such resource")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		davError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The root element names the report
+	var report xml.Name
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for report.Local == "" {
+		token, err := decoder.Token()
+		if err != nil {
+			davError(w, http.StatusBadRequest, fmt.Sprintf("malformed XML: %v", err))
+			return
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			report = start.Name
+		}
+	}
+	if kind != davBook || (report != reportMultiget && report != reportQuery && report != reportSyncCollection) {
+		writePrecondition(w, http.StatusForbidden, conditionSupported)
+		return
+	}
+
+	switch report {
+	case reportMultiget:
+		var request davMultiget
+		err = xml.Unmarshal(body, &request)
+		if err == nil {
+			h.davMultiget(w, r, request)
+		}
+	case reportQuery:
+		var request davQuery
+		err = xml.Unmarshal(body, &request)
+		if err == nil {
+			h.davQuery(w, r, request)
+		}
+	default:
+		var request davSyncCollection
+		err = xml.Unmarshal(body, &request)
+		if err == nil {
+			h.davSync(w, r, request)
+		}
+	}
+	if err != nil {
+		davError(w, http.StatusBadRequest, fmt.Sprintf("malformed XML: %v", err))
+	}
+}
+
+func (h *ContactHandler) davMultiget(w http.ResponseWriter, r *http.Request, request davMultiget) {
+	names := requestedProps(request.Prop, propETag, propAddressData)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var ms davMultistatus
+	for _, href := range request.Hrefs {
+		href = strings.TrimSpace(href)
+		var kind davKind
+		var id string
+		u, err := url.Parse(href)
+		ok := err == nil
+		if ok {
+			kind, id, ok = davResolve(u.Path)
+		}
+		if !ok || kind != davCard {
+			ms.Responses = append(ms.Responses, davResponse{Href: href, Status: davStatus(http.StatusNotFound)})
+			continue
+		}
+
+		c, err := h.store(r).Get(id)
+		switch {
+		case errors.Is(err, utils.ErrContactNotFound):
+			ms.Responses = append(ms.Responses, davResponse{Href: href, Status: davStatus(http.StatusNotFound)})
+			continue
+		case err != nil:
+			davStorageError(w, err, id)
+			return
+		}
+		ms.Responses = append(ms.Responses, davPropResponse(davResource{kind: davCard, href: href, contact: c}, names, davBookState{}, false))
+	}
+
+	writeMultistatus(w, ms)
+}
+
+func (h *ContactHandler) davQuery(w http.ResponseWriter, r *http.Request, request davQuery) {
+	names := requestedProps(request.Prop, propETag, propAddressData)
+
+	h.mu.Lock()
+	contacts, err := h.store(r).List()
+	h.mu.Unlock()
+
+	if err != nil {
+		davStorageError(w, err, "")
+		return
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].ID < contacts[j].ID })
+
+	var ms davMultistatus
+	for _, c := range contacts {
+		if !request.Filter.matches(storage.VCardProperties(c)) {
+			continue
+		}
+		if request.Limit != nil && request.Limit.NResults > 0 && len(ms.Responses) == request.Limit.NResults {
+			// The client learns that the result was truncated
+			ms.Responses = append(ms.Responses, davResponse{Href: davBookPath, Status: davStatus(http.StatusInsufficientStorage)})
+			break
+		}
+		ms.Responses = append(ms.Responses, davPropResponse(davResource{kind: davCard, href: davCardHref(c.ID), contact: c}, names, davBookState{}, false))
+	}
+
+	writeMultistatus(w, ms)
+}
+
+// davSync reports the cards changed and deleted since a sync token, all cards
+// for an initial sync, along with a new token
+func (h *ContactHandler) davSync(w http.ResponseWriter, r *http.Request, request davSyncCollection) {
+	names := requestedProps(request.Prop, propETag)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	since, ok := h.davSince(r, strings.TrimSpace(request.SyncToken))
+	if !ok {
+		writePrecondition(w, http.StatusForbidden, conditionValidToken)
+		return
+	}
+	ms := davMultistatus{SyncToken: davSyncToken(time.Now())}
+
+	contacts, err := h.store(r).List()
+	if err != nil {
+		davStorageError(w, err, "")
+		return
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[i].ID < contacts[j].ID })
+	for _, c := range contacts {
+		if !c.UpdatedAt.Before(since) {
+			ms.Responses = append(ms.Responses, davPropResponse(davResource{kind: davCard, href: davCardHref(c.ID), contact: c}, names, davBookState{}, false))
+		}
+	}
+
+	if !since.IsZero() {
+		trashed, err := h.store(r).ListTrash()
+		if err != nil {
+			davStorageError(w, err, "")
+			return
+		}
+		for _, c := range trashed {
+			if !c.DeletedAt.Before(since) {
+				ms.Responses = append(ms.Responses, davResponse{Href: davCardHref(c.ID), Status: davStatus(http.StatusNotFound)})
+			}
+		}
+	}
+
+	writeMultistatus(w, ms)
+}
+
+// matches applies an addressbook-query filter to the properties of a card.
+// A filter without prop-filters matches every card.
+func (f davFilter) matches(properties map[string][]string) bool {
+	if len(f.PropFilters) == 0 {
+		return true
+	}
+
+	for _, v := range f.PropFilters {
+		ok := v.matches(properties)
+		if ok && f.Test != "allof" {
+			return true
+		}
+		if !ok && f.Test == "allof" {
+			return false
+		}
+	}
+
+	return f.Test == "allof"
+}
+
+func (f davPropFilter) matches(properties map[string][]string) bool {
+	values, defined := properties[strings.ToUpper(f.Name)]
+	if f.IsNotDefined != nil {
+		return !defined
+	}
+	if !defined {
+		return false
+	}
+	if len(f.TextMatches) == 0 {
+		return true
+	}
+
+	for _, v := range f.TextMatches {
+		ok := v.matchesAny(values)
+		if ok && f.Test != "allof" {
+			return true
+		}
+		if !ok && f.Test == "allof" {
+			return false
+		}
+	}
+
+	return f.Test == "allof"
+}
+
+// matchesAny reports whether one of the values of a property matches. Only
+// i;octet compares case-sensitively.
+func (m davTextMatch) matchesAny(values []string) bool {
+	text := m.Value
+	fold := func(s string) string { return s }
+	if m.Collation != "i;octet" {
+		fold = strings.ToLower
+	}
+	text = fold(text)
+
+	matched := false
+	for _, v := range values {
+		v = fold(v)
+		switch m.MatchType {
+		case "equals":
+			matched = v == text
+		case "starts-with":
+			matched = strings.HasPrefix(v, text)
+		case "ends-with":
+			matched = strings.HasSuffix(v, text)
+		default:
+			matched = strings.Contains(v, text)
+		}
+		if matched {
+			break
+		}
+	}
+
+	return matched != (m.NegateCondition == "yes")
+}
+
+// DAVGetCard returns a card with its ETag, or 304 Not Modified when the ETag
+// is given in If-None-Match
+func (h *ContactHandler) DAVGetCard(w http.ResponseWriter, r *http.Request) {
+	kind, id, ok := davResolve(r.URL.Path)
+	switch {
+	case !ok:
+		davError(w, http.StatusNotFound, "no such resource")
+		return
+	case kind != davCard:
+		davError(w, http.StatusMethodNotAllowed, "collections have no content, use PROPFIND")
+		return
+	}
+
+	h.mu.Lock()
+	c, err := h.store(r).Get(id)
+	h.mu.Unlock()
+
+	if err != nil {
+		davStorageError(w, err, id)
+		return
+	}
+
+	etag := davETag(c)
+	w.Header().Set("ETag", etag)
+	if v := r.Header.Get("If-None-Match"); v != "" && etagMatches(v, etag) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", storage.VCardContentType+"; charset=utf-8")
+	w.Header().Set("Last-Modified", c.UpdatedAt.UTC().Format(http.TimeFormat))
+	_ = storage.EncodeVCard(w, c)
+}
+
+// DAVPutCard stores a card under its name: a new contact, with the validation
+// and enrichment of /api/add, or the replacement of an existing one. If-Match
+// and If-None-Match guard against lost updates. No ETag is returned, as the
+// stored card differs from the one sent once normalized and enriched.
+func (h *ContactHandler) DAVPutCard(w http.ResponseWriter, r *http.Request) {
+	kind, id, ok := davResolve(r.URL.Path)
+	if !ok || kind != davCard {
+		davError(w, http.StatusMethodNotAllowed, "cards are stored inside "+davBookPath)
+		return
+	}
+
+	cards, err := storage.DecodeVCards(r.Body)
+	defer r.Body.Close()
+	if err == nil && len(cards) != 1 {
+		err = errors.New("the body must hold exactly one card")
+	}
+	if err == nil {
+		err = cards[0].Err
+	}
+	if err != nil {
+		writePrecondition(w, http.StatusBadRequest, conditionValidAddress)
+		return
+	}
+	card := cards[0]
+
+	h.mu.Lock()
+	stored, err := h.store(r).GetWithTrash(id)
+	h.mu.Unlock()
+
+	exists := err == nil
+	if err != nil && !errors.Is(err, utils.ErrContactNotFound) {
+		davStorageError(w, err, id)
+		return
+	}
+
+	c := card.Contact
+	profile := storage.ProfileCreate
+	if exists {
+		c = card.Replace(stored)
+		profile = storage.ProfileEdit
+	}
+	fields := h.rules().Validate(profile, c)
+	if len(fields) == 0 {
+		fields = c.Normalize(h.rules().Profile(profile))
+	}
+	if len(fields) > 0 {
+		messages := make([]string, 0, len(fields))
+		for _, v := range fields {
+			messages = append(messages, fmt.Sprintf("%v: %v", v.Field, v.Message))
+		}
+		davError(w, http.StatusUnprocessableEntity, strings.Join(messages, "\n"))
+		return
+	}
+	if !exists {
+		// Enrichment calls external APIs, so it runs outside the lock
+		err = c.FillMissingFields()
+		if err != nil {
+			davError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// The card may have changed while unlocked
+	current, err := h.store(r).Get(id)
+	active := err == nil
+	if err != nil && !errors.Is(err, utils.ErrContactNotFound) {
+		davStorageError(w, err, id)
+		return
+	}
+	if !davPreconditions(r, active, davETag(current)) {
+		davError(w, http.StatusPreconditionFailed, "the card has changed")
+		return
+	}
+	if exists != active && !(exists && stored.IsDeleted()) {
+		davError(w, http.StatusConflict, "the card was changed concurrently, please retry")
+		return
+	}
+
+	switch {
+	case active:
+		_, err = h.update(r, current, c)
+	case exists:
+		// Cards put back after a deletion are restored from the trash
+		var restored storage.Contact
+		restored, err = h.store(r).Restore(id)
+		if err == nil {
+			h.record(r, storage.ActionRestore, &stored, &restored)
+			_, err = h.update(r, restored, c)
+		}
+	default:
+		c.ID = id
+		c.CreatedBy = actor(r)
+		err = h.store(r).Add(c)
+		if err == nil {
+			c, err = h.store(r).Get(id)
+		}
+		if err == nil {
+			h.record(r, storage.ActionCreate, nil, &c)
+		}
+	}
+	if err != nil {
+		davStorageError(w, err, id)
+		return
+	}
+
+	w.Header().Del("Content-Type")
+	if active {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// DAVDeleteCard moves a card to the trash, where sync reports it as deleted
+func (h *ContactHandler) DAVDeleteCard(w http.ResponseWriter, r *http.Request) {
+	kind, id, ok := davResolve(r.URL.Path)
+	if !ok || kind != davCard {
+		davError(w, http.StatusMethodNotAllowed, "only cards can be deleted")
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	before, err := h.store(r).Get(id)
+	if err != nil {
+		davStorageError(w, err, id)
+		return
+	}
+	if !davPreconditions(r, true, davETag(before)) {
+		davError(w, http.StatusPreconditionFailed, "the card has changed")
+		return
+	}
+
+	err = h.store(r).Delete(id)
+	if err != nil {
+		davStorageError(w, err, id)
+		return
+	}
+	trashed, err := h.store(r).GetWithTrash(id)
+	if err == nil {
+		h.record(r, storage.ActionDelete, &before, &trashed)
+	}
+
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+}
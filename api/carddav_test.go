@@ -0,0 +1,267 @@
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// testMultistatus is what the tests read of a multistatus response
+type testMultistatus struct {
+	Responses []struct {
+		Href        string `xml:"DAV: href"`
+		Status      string `xml:"DAV: status"`
+		ETag        string `xml:"DAV: propstat>prop>getetag"`
+		AddressData string `xml:"urn:ietf:params:xml:ns:carddav propstat>prop>address-data"`
+	} `xml:"DAV: response"`
+	SyncToken string `xml:"DAV: sync-token"`
+}
+
+// dav sends a WebDAV request and returns the status and body of the answer
+func (s *testServer) dav(method string, path string, key string, body string, header http.Header) (*http.Response, string) {
+	s.t.Helper()
+
+	if header == nil {
+		header = http.Header{}
+	}
+	if body != "" && header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/xml")
+	}
+	response, data := s.request(method, path, key, body, header)
+
+	return response, string(data)
+}
+
+// multistatus sends a WebDAV request that must answer 207
+func (s *testServer) multistatus(method string, path string, key string, body string, header http.Header) testMultistatus {
+	s.t.Helper()
+
+	response, data := s.dav(method, path, key, body, header)
+	if response.StatusCode != http.StatusMultiStatus {
+		s.t.Fatalf("%v %v answered %v: %v", method, path, response.StatusCode, data)
+	}
+	var ms testMultistatus
+	err := xml.Unmarshal([]byte(data), &ms)
+	if err != nil {
+		s.t.Fatalf("reading %v: %v", data, err)
+	}
+
+	return ms
+}
+
+// davFixture returns a test server with two contacts and a write key
+func davFixture(t *testing.T) (*testServer, string, storage.Contact, storage.Contact) {
+	s := newTestServer(t)
+	_, key := s.issueKey("acme", storage.ScopeWrite)
+	ann := s.addContact(key, "Ann Lee", "+1 202 555 0100")
+	bob := s.addContact(key, "Bob Ray", "+1 202 555 0101")
+
+	return s, key, ann, bob
+}
+
+func TestDAVPropfindDepth(t *testing.T) {
+	s, key, ann, bob := davFixture(t)
+
+	ms := s.multistatus("PROPFIND", davBookPath, key, "", http.Header{"Depth": {"0"}})
+	if len(ms.Responses) != 1 || ms.Responses[0].Href != davBookPath {
+		t.Fatalf("depth 0 lists %+v", ms.Responses)
+	}
+
+	body := `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getetag/></D:prop></D:propfind>`
+	ms = s.multistatus("PROPFIND", davBookPath, key, body, http.Header{"Depth": {"1"}})
+	hrefs := map[string]string{}
+	for _, v := range ms.Responses {
+		hrefs[v.Href] = v.ETag
+	}
+	if len(ms.Responses) != 3 || hrefs[davCardHref(ann.ID)] == "" || hrefs[davCardHref(bob.ID)] == "" {
+		t.Fatalf("depth 1 lists %+v", ms.Responses)
+	}
+
+	ms = s.multistatus("PROPFIND", davHomePath, key, "", http.Header{"Depth": {"1"}})
+	if len(ms.Responses) != 2 {
+		t.Fatalf("depth 1 of the home lists %+v", ms.Responses)
+	}
+}
+
+func TestDAVReports(t *testing.T) {
+	s, key, ann, bob := davFixture(t)
+
+	multiget := `<?xml version="1.0"?>
+<C:addressbook-multiget xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav">
+  <D:prop><D:getetag/><C:address-data/></D:prop>
+  <D:href>` + davCardHref(ann.ID) + `</D:href>
+  <D:href>` + davCardHref("missing") + `</D:href>
+</C:addressbook-multiget>`
+	ms := s.multistatus("REPORT", davBookPath, key, multiget, nil)
+	if len(ms.Responses) != 2 {
+		t.Fatalf("multiget answered %+v", ms.Responses)
+	}
+	if !strings.Contains(ms.Responses[0].AddressData, "FN:Ann Lee") || ms.Responses[0].ETag == "" {
+		t.Fatalf("multiget of Ann answered %+v", ms.Responses[0])
+	}
+	if !strings.Contains(ms.Responses[1].Status, "404") {
+		t.Fatalf("multiget of a missing card answered %+v", ms.Responses[1])
+	}
+
+	query := `<?xml version="1.0"?>
+<C:addressbook-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:prop-filter name="FN"><C:text-match match-type="starts-with">bob</C:text-match></C:prop-filter></C:filter>
+</C:addressbook-query>`
+	ms = s.multistatus("REPORT", davBookPath, key, query, nil)
+	if len(ms.Responses) != 1 || ms.Responses[0].Href != davCardHref(bob.ID) {
+		t.Fatalf("query answered %+v", ms.Responses)
+	}
+}
+
+// syncCollection is the body of a sync-collection report from a token
+func syncCollection(token string) string {
+	return `<?xml version="1.0"?>
+<D:sync-collection xmlns:D="DAV:">
+  <D:sync-token>` + token + `</D:sync-token>
+  <D:sync-level>1</D:sync-level>
+  <D:prop><D:getetag/></D:prop>
+</D:sync-collection>`
+}
+
+func TestDAVSyncCollection(t *testing.T) {
+	s, key, _, bob := davFixture(t)
+
+	// Tokens count in milliseconds, changes in the same one are reported again
+	time.Sleep(2 * time.Millisecond)
+	ms := s.multistatus("REPORT", davBookPath, key, syncCollection(""), nil)
+	if len(ms.Responses) != 2 || !strings.HasPrefix(ms.SyncToken, davSyncTokenPrefix) {
+		t.Fatalf("initial sync answered %+v", ms)
+	}
+	token := ms.SyncToken
+
+	time.Sleep(2 * time.Millisecond)
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/delete?id="+bob.ID, key, nil)
+	ms = s.multistatus("REPORT", davBookPath, key, syncCollection(token), nil)
+	if len(ms.Responses) != 1 || ms.Responses[0].Href != davCardHref(bob.ID) || !strings.Contains(ms.Responses[0].Status, "404") {
+		t.Fatalf("sync after a deletion answered %+v", ms.Responses)
+	}
+
+	// Tokens from before the service started or the last purge are stale
+	stale := []string{davSyncToken(davStarted.Add(-time.Hour)), "urn:x-contactservice:sync:abc", "bogus"}
+	s.expectStatus(http.StatusOK, http.MethodDelete, "/api/trash/"+bob.ID, key, nil)
+	stale = append(stale, token)
+	for _, v := range stale {
+		response, data := s.dav("REPORT", davBookPath, key, syncCollection(v), nil)
+		if response.StatusCode != http.StatusForbidden || !strings.Contains(data, "valid-sync-token") {
+			t.Fatalf("sync from %q answered %v: %v", v, response.StatusCode, data)
+		}
+	}
+}
+
+// vCard returns a card with a name and phone, and its gender and country so
+// that nothing is looked up on the network
+func vCard(name string, phone string) string {
+	return "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:" + name + "\r\nTEL:" + phone + "\r\nGENDER:F\r\nX-COUNTRY:US\r\nEND:VCARD\r\n"
+}
+
+func TestDAVPreconditions(t *testing.T) {
+	s, key, ann, _ := davFixture(t)
+	vcard := http.Header{"Content-Type": {storage.VCardContentType}}
+	with := func(name string, value string) http.Header {
+		header := vcard.Clone()
+		header.Set(name, value)
+		return header
+	}
+
+	response, _ := s.dav(http.MethodGet, davCardHref(ann.ID), key, "", nil)
+	etag := response.Header.Get("ETag")
+	if response.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("GET answered %v with ETag %q", response.StatusCode, etag)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"create over an existing card", http.MethodPut, davCardHref(ann.ID), with("If-None-Match", "*"), http.StatusPreconditionFailed},
+		{"update with a stale ETag", http.MethodPut, davCardHref(ann.ID), with("If-Match", `"stale"`), http.StatusPreconditionFailed},
+		{"update of a missing card", http.MethodPut, davCardHref("new-card"), with("If-Match", "*"), http.StatusPreconditionFailed},
+		{"delete with a stale ETag", http.MethodDelete, davCardHref(ann.ID), http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed},
+		{"update with the ETag", http.MethodPut, davCardHref(ann.ID), with("If-Match", etag), http.StatusNoContent},
+		{"create", http.MethodPut, davCardHref("new-card"), with("If-None-Match", "*"), http.StatusCreated},
+	}
+	for _, test := range tests {
+		body := ""
+		if test.method == http.MethodPut {
+			body = vCard("Ann Lee", "+1 202 555 0100")
+			if strings.Contains(test.path, "new-card") {
+				body = vCard("Cid Roe", "+1 202 555 0102")
+			}
+		}
+		response, data := s.dav(test.method, test.path, key, body, test.header)
+		if response.StatusCode != test.want {
+			t.Fatalf("%v answered %v, want %v: %v", test.name, response.StatusCode, test.want, data)
+		}
+	}
+
+	// The update changed the ETag, the old one no longer deletes
+	response, _ = s.dav(http.MethodDelete, davCardHref(ann.ID), key, "", http.Header{"If-Match": {etag}})
+	if response.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("delete with the old ETag answered %v", response.StatusCode)
+	}
+	response, _ = s.dav(http.MethodGet, davCardHref(ann.ID), key, "", nil)
+	response, _ = s.dav(http.MethodDelete, davCardHref(ann.ID), key, "", http.Header{"If-Match": {response.Header.Get("ETag")}})
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete with the current ETag answered %v", response.StatusCode)
+	}
+}
+
+func TestDAVPutKeepsCustomFields(t *testing.T) {
+	s := newTestServer(t)
+	_, key := s.issueKey("acme", storage.ScopeWrite)
+	body := map[string]interface{}{
+		"name": "Ann Lee", "phone": "+1 202 555 0100", "gender": "female", "country": "US",
+		"custom": map[string]string{"department": "Sales", "cost center": "42", "X-ROOM": "7"},
+	}
+	var added []storage.Contact
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/add", key, body).decode(t, &added)
+	ann := added[0]
+
+	response, card := s.dav(http.MethodGet, davCardHref(ann.ID), key, "", nil)
+	if !strings.Contains(card, "X-DEPARTMENT:Sales\r\n") || strings.Contains(card, "cost center") {
+		t.Fatalf("card of the contact:\n%v", card)
+	}
+
+	// An unchanged card is put back, then one without the room
+	header := http.Header{"Content-Type": {storage.VCardContentType}, "If-Match": {response.Header.Get("ETag")}}
+	response, data := s.dav(http.MethodPut, davCardHref(ann.ID), key, card, header)
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT answered %v: %v", response.StatusCode, data)
+	}
+	var got []storage.Contact
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/contacts/"+ann.ID, key, nil).decode(t, &got)
+	want := map[string]string{"department": "Sales", "cost center": "42", "X-ROOM": "7"}
+	if len(got[0].Custom) != len(want) {
+		t.Fatalf("custom fields %v after PUT, want %v", got[0].Custom, want)
+	}
+	for k, v := range want {
+		if got[0].Custom[k] != v {
+			t.Fatalf("custom fields %v after PUT, want %v", got[0].Custom, want)
+		}
+	}
+
+	response, card = s.dav(http.MethodGet, davCardHref(ann.ID), key, "", nil)
+	header.Set("If-Match", response.Header.Get("ETag"))
+	response, data = s.dav(http.MethodPut, davCardHref(ann.ID), key, strings.Replace(card, "X-ROOM:7\r\n", "", 1), header)
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT without the room answered %v: %v", response.StatusCode, data)
+	}
+	got = nil
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/contacts/"+ann.ID, key, nil).decode(t, &got)
+	if _, ok := got[0].Custom["X-ROOM"]; ok || got[0].Custom["department"] != "Sales" {
+		t.Fatalf("custom fields %v after dropping the room", got[0].Custom)
+	}
+}
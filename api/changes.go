@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Limits of /api/changes
+const (
+	changesDefaultLimit = 100
+	changesMaxLimit     = 1000
+	changesMaxWait      = time.Minute
+)
+
+// changesPage is a page of the change log. NextCursor is the since of the
+// following request, HasMore tells whether it has changes already.
+type changesPage struct {
+	Changes    []storage.Change `json:"changes"`
+	NextCursor string           `json:"next_cursor"`
+	HasMore    bool             `json:"has_more"`
+}
+
+// notifyChange wakes up the long polls waiting for a change of the request's
+// book. It must be called with h.mu held.
+func (h *ContactHandler) notifyChange(r *http.Request) {
+	if changed, ok := h.changed[tenant(r)]; ok {
+		close(changed)
+		delete(h.changed, tenant(r))
+	}
+}
+
+// changeSignal returns a channel closed with the next change of the
+// request's book. It must be called with h.mu held.
+func (h *ContactHandler) changeSignal(r *http.Request) <-chan struct{} {
+	if h.changed == nil {
+		h.changed = make(map[string]chan struct{})
+	}
+	changed, ok := h.changed[tenant(r)]
+	if !ok {
+		changed = make(chan struct{})
+		h.changed[tenant(r)] = changed
+	}
+
+	return changed
+}
+
+// readChanges returns the changes after the cursor since, or ErrResyncRequired
+// when the change log does not reach back to it. A negative since starts at
+// the oldest change kept. It must be called with h.mu held.
+func (h *ContactHandler) readChanges(r *http.Request, since int64, limit int) ([]storage.Change, int64, error) {
+	oldest, latest, err := h.store(r).ChangeBounds()
+	if err != nil {
+		return nil, 0, err
+	}
+	if since < 0 {
+		since = oldest - 1
+		if oldest == 0 {
+			since = 0
+		}
+	}
+	// Sequence numbers have no gaps, so changes before oldest were dropped
+	if since > latest || (oldest > 0 && since < oldest-1) {
+		return nil, since, utils.ErrResyncRequired
+	}
+
+	// One more than asked tells whether there are more
+	changes, err := h.store(r).Changes(since, limit+1)
+
+	return changes, since, err
+}
+
+// ListChanges returns the changes of all contacts after a cursor in order,
+// with the cursor to continue from. since=now skips to the latest change.
+// With wait=... an empty page is only returned once the time is up without
+// a change.
+func (h *ContactHandler) ListChanges(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()
+
+	since := int64(-1)
+	limit := changesDefaultLimit
+	var wait time.Duration
+	var err error
+	if v := keys.Get("since"); v != "" && v != "now" {
+		since, err = strconv.ParseInt(v, 10, 64)
+		if since < 0 {
+			err = utils.ErrChangesWrongFormat
+		}
+	}
+	if v := keys.Get("limit"); v != "" && err == nil {
+		limit, err = strconv.Atoi(v)
+		if limit < 1 || limit > changesMaxLimit {
+			err = utils.ErrChangesWrongFormat
+		}
+	}
+	if v := keys.Get("wait"); v != "" && err == nil {
+		wait, err = time.ParseDuration(v)
+		if wait < 0 || wait > changesMaxWait {
+			err = utils.ErrChangesWrongFormat
+		}
+	}
+	if err != nil {
+		utils.SendCustomError(w, http.StatusBadRequest, utils.ErrChangesWrongFormat.Error())
+		return
+	}
+
+	h.mu.Lock()
+	if keys.Get("since") == "now" {
+		_, since, err = h.store(r).ChangeBounds()
+	}
+	var changes []storage.Change
+	if err == nil {
+		changes, since, err = h.readChanges(r, since, limit)
+	}
+	signal := h.changeSignal(r)
+	h.mu.Unlock()
+
+	if err == nil && len(changes) == 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-signal:
+			h.mu.Lock()
+			changes, since, err = h.readChanges(r, since, limit)
+			h.mu.Unlock()
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+		timer.Stop()
+	}
+
+	switch {
+	case errors.Is(err, utils.ErrResyncRequired):
+		utils.SendCustomError(w, http.StatusGone, err.Error())
+		return
+	case err != nil:
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	page := changesPage{Changes: changes, NextCursor: strconv.FormatInt(since, 10)}
+	if len(changes) > limit {
+		page.Changes, page.HasMore = changes[:limit], true
+	}
+	if len(page.Changes) > 0 {
+		page.NextCursor = strconv.FormatInt(page.Changes[len(page.Changes)-1].Seq, 10)
+	}
+	if page.Changes == nil {
+		page.Changes = []storage.Change{}
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("%v changes", len(page.Changes)), page)
+}
+
+// StartChangeExpirer drops changes older than retention from the change log
+// every interval until stop is closed
+func (h *ContactHandler) StartChangeExpirer(retention time.Duration, interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				h.mu.Lock()
+				tenants, listErr := h.tenants()
+				for _, t := range tenants {
+					_, err := h.Storage.Tenant(t).ExpireChanges(time.Now().Add(-retention))
+					if err != nil {
+						log.Printf("Error expiring changes of tenant %q: %v", t, err)
+					}
+				}
+				h.mu.Unlock()
+				if listErr != nil {
+					log.Printf("Error listing tenants: %v", listErr)
+				}
+			}
+		}
+	}()
+}
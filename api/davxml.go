@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// XML namespaces of WebDAV, CardDAV and the calendarserver.org extensions
+const (
+	nsDAV     = "DAV:"
+	nsCardDAV = "urn:ietf:params:xml:ns:carddav"
+	nsCS      = "http://calendarserver.org/ns/"
+)
+
+// davPrefixes are the prefixes declared on every multistatus response
+var davPrefixes = map[string]string{
+	nsDAV:     "D",
+	nsCardDAV: "C",
+	nsCS:      "CS",
+}
+
+// davNames is a list of property names such as the content of DAV:prop
+type davNames struct {
+	Names []davName `xml:",any"`
+}
+
+type davName struct {
+	XMLName xml.Name
+}
+
+type davPropfind struct {
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     davNames  `xml:"DAV: prop"`
+}
+
+type davMultiget struct {
+	Prop  davNames `xml:"DAV: prop"`
+	Hrefs []string `xml:"DAV: href"`
+}
+
+type davQuery struct {
+	Prop   davNames  `xml:"DAV: prop"`
+	Filter davFilter `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Limit  *struct {
+		NResults int `xml:"urn:ietf:params:xml:ns:carddav nresults"`
+	} `xml:"urn:ietf:params:xml:ns:carddav limit"`
+}
+
+// davFilter matches cards whose prop-filters are all ("allof") or any of them
+// ("anyof", the default) true
+type davFilter struct {
+	Test        string          `xml:"test,attr"`
+	PropFilters []davPropFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+}
+
+type davPropFilter struct {
+	Name         string         `xml:"name,attr"`
+	Test         string         `xml:"test,attr"`
+	IsNotDefined *struct{}      `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []davTextMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+}
+
+type davTextMatch struct {
+	Collation       string `xml:"collation,attr"`
+	MatchType       string `xml:"match-type,attr"`
+	NegateCondition string `xml:"negate-condition,attr"`
+	Value           string `xml:",chardata"`
+}
+
+type davSyncCollection struct {
+	SyncToken string   `xml:"DAV: sync-token"`
+	SyncLevel string   `xml:"DAV: sync-level"`
+	Prop      davNames `xml:"DAV: prop"`
+}
+
+// davMultistatus is written with fixed prefixes, as some clients cannot cope
+// with a default namespace redeclared on every element
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XMLNSD    string        `xml:"xmlns:D,attr"`
+	XMLNSC    string        `xml:"xmlns:C,attr"`
+	XMLNSCS   string        `xml:"xmlns:CS,attr"`
+	Responses []davResponse `xml:"D:response"`
+	SyncToken string        `xml:"D:sync-token,omitempty"`
+}
+
+// davResponse carries either the properties of a resource or, for a missing
+// one, its status alone
+type davResponse struct {
+	Href      string        `xml:"D:href"`
+	Propstats []davPropstat `xml:"D:propstat,omitempty"`
+	Status    string        `xml:"D:status,omitempty"`
+}
+
+type davPropstat struct {
+	Prop   davRawXML `xml:"D:prop"`
+	Status string    `xml:"D:status"`
+}
+
+type davRawXML struct {
+	Inner string `xml:",innerxml"`
+}
+
+// davStatus is the status line of a response element
+func davStatus(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %v %v", code, http.StatusText(code))
+}
+
+// davEscape escapes text content
+func davEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
+
+// davElement writes an element with already escaped content. Names outside
+// the declared namespaces get a prefix of their own.
+func davElement(name xml.Name, inner string) string {
+	prefix, ok := davPrefixes[name.Space]
+	declaration := ""
+	if !ok {
+		prefix = "X"
+		declaration = fmt.Sprintf(` xmlns:X="%v"`, davEscape(name.Space))
+	}
+	if inner == "" {
+		return fmt.Sprintf("<%v:%v%v/>", prefix, name.Local, declaration)
+	}
+
+	return fmt.Sprintf("<%v:%v%v>%v</%v:%v>", prefix, name.Local, declaration, inner, prefix, name.Local)
+}
+
+// davHref writes a DAV:href element
+func davHref(href string) string {
+	return davElement(xml.Name{Space: nsDAV, Local: "href"}, davEscape(href))
+}
+
+// writeMultistatus answers with a 207 Multi-Status body
+func writeMultistatus(w http.ResponseWriter, ms davMultistatus) {
+	ms.XMLNSD, ms.XMLNSC, ms.XMLNSCS = nsDAV, nsCardDAV, nsCS
+
+	body, err := xml.Marshal(ms)
+	if err != nil {
+		davError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(body)
+}
+
+// writePrecondition answers with a DAV:error naming the failed precondition
+func writePrecondition(w http.ResponseWriter, status int, name xml.Name) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, `%v<D:error xmlns:D="%v" xmlns:C="%v">%v</D:error>`, xml.Header, nsDAV, nsCardDAV, davElement(name, ""))
+}
+
+// davError answers DAV clients with a plain text message
+func davError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintln(w, message)
+}
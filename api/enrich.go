@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// enrich guesses missing or previously guessed fields of c without holding
+// the lock, then stores the result unless the contact was renamed meanwhile
+// or nothing changed. onlyEnriched is passed on to storage.Contact.Enrich.
+func (h *ContactHandler) enrich(r *http.Request, c storage.Contact, onlyEnriched bool) (storage.Contact, error) {
+	err := c.Enrich(onlyEnriched)
+	if err != nil {
+		return c, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	current, err := h.store(r).Get(c.ID)
+	if err != nil {
+		return c, err
+	}
+	if current.Name != c.Name || current.Gender == c.Gender && current.Country == c.Country {
+		return current, nil
+	}
+
+	before := current
+	current.Gender = c.Gender
+	current.Country = c.Country
+	current.Provenance = c.Provenance
+
+	return h.update(r, before, current)
+}
+
+func (h *ContactHandler) EnrichContact(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	c, err := h.store(r).Get(id)
+	h.mu.Unlock()
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	c, err = h.enrich(r, c, false)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Contact \"%v\" successfully enriched", id), presentContacts(r, []storage.Contact{c}))
+}
+
+func (h *ContactHandler) EnrichContacts(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// An empty body enriches the whole book
+	var filterRequest storage.FilterRequest
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &filterRequest)
+		if err != nil {
+			sendMalformedJSON(w, err)
+			return
+		}
+	}
+
+	var contacts []storage.Contact
+	h.mu.Lock()
+	if filterRequest.Field == "" && filterRequest.Value == "" {
+		contacts, err = h.store(r).List()
+	} else {
+		contacts, err = h.store(r).Filter(filterRequest.Field, filterRequest.Value)
+	}
+	h.mu.Unlock()
+	if err != nil {
+		sendStorageError(w, err, "")
+		return
+	}
+
+	enriched := make([]storage.Contact, 0, len(contacts))
+	for _, c := range contacts {
+		c, err = h.enrich(r, c, false)
+		if err != nil && !errors.Is(err, utils.ErrContactNotFound) {
+			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err == nil {
+			enriched = append(enriched, c)
+		}
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("%v contacts enriched", len(enriched)), presentContacts(r, enriched))
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// storageErrorStatus returns the status and message matching err: 4xx for
+// anything the client can fix, 500 for the rest. id names the contact the
+// request was about.
+func storageErrorStatus(err error, id string) (int, string) {
+	var fieldErr utils.FieldError
+	var duplicateErr utils.DuplicateError
+
+	switch {
+	case errors.Is(err, utils.ErrContactNotFound):
+		return http.StatusNotFound, fmt.Sprintf("No contact with ID: \"%v\"", id)
+	case errors.Is(err, utils.ErrWebhookNotFound):
+		return http.StatusNotFound, fmt.Sprintf("No webhook with ID: \"%v\"", id)
+	case errors.Is(err, utils.ErrDeadLetterNotFound):
+		return http.StatusNotFound, fmt.Sprintf("No dead letter with ID: \"%v\"", id)
+	case errors.Is(err, utils.ErrAPIKeyNotFound):
+		return http.StatusNotFound, fmt.Sprintf("No active API key with ID: \"%v\"", id)
+	case errors.Is(err, utils.ErrBookNotFound):
+		return http.StatusNotFound, fmt.Sprintf("No address book with ID: \"%v\"", id)
+	case errors.Is(err, utils.ErrNotInTrash):
+		return http.StatusNotFound, fmt.Sprintf("No deleted contact with ID: \"%v\"", id)
+	case errors.Is(err, utils.ErrAlreadyFav):
+		return http.StatusConflict, fmt.Sprintf("contact \"%v\" is already in favorites", id)
+	case errors.Is(err, utils.ErrAlreadyNotFav):
+		return http.StatusConflict, fmt.Sprintf("contact \"%v\" is not in favorites already", id)
+	case errors.As(err, &duplicateErr):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, utils.ErrFavWrongFormat), errors.Is(err, utils.ErrFilterWrongFormat), errors.Is(err, utils.ErrListOptionsWrongFormat), errors.Is(err, utils.ErrBulkWrongFormat), errors.Is(err, utils.ErrCardWrongFormat), errors.Is(err, utils.ErrRowWrongFormat):
+		return http.StatusBadRequest, err.Error()
+	case errors.As(err, &fieldErr), errors.Is(err, utils.ErrValidationFailed):
+		return http.StatusUnprocessableEntity, utils.ErrValidationFailed.Error()
+	case errors.Is(err, utils.ErrBulkSkipped):
+		return http.StatusFailedDependency, err.Error()
+	default:
+		return http.StatusInternalServerError, err.Error()
+	}
+}
+
+// sendStorageError answers with the status matching err, see
+// storageErrorStatus
+func sendStorageError(w http.ResponseWriter, err error, id string) {
+	var fieldErr utils.FieldError
+	var duplicateErr utils.DuplicateError
+
+	status, message := storageErrorStatus(err, id)
+	switch {
+	case errors.As(err, &duplicateErr):
+		utils.SendDuplicateError(w, duplicateErr)
+	case errors.As(err, &fieldErr):
+		utils.SendValidationError(w, status, message, []utils.FieldError{fieldErr})
+	default:
+		utils.SendCustomError(w, status, message)
+	}
+}
+
+// sendMalformedJSON answers a request whose body could not be decoded
+func sendMalformedJSON(w http.ResponseWriter, err error) {
+	utils.SendCustomError(w, http.StatusBadRequest, fmt.Sprintf("malformed JSON: %v", err))
+}
+
+// sendFieldErrors answers a request with fields that failed normalization
+func sendFieldErrors(w http.ResponseWriter, fields []utils.FieldError) {
+	utils.SendValidationError(w, http.StatusUnprocessableEntity, utils.ErrValidationFailed.Error(), fields)
+}
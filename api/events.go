@@ -0,0 +1,337 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// eventBufferSize is how many events a subscriber may fall behind before it
+// is dropped
+const eventBufferSize = 256
+
+// eventHeartbeat is how often idle streams are pinged so that proxies keep
+// them open and dead clients are noticed
+const eventHeartbeat = 15 * time.Second
+
+// LastEventIDHeader carries the ID of the last event a reconnecting SSE
+// client received
+const LastEventIDHeader = "Last-Event-ID"
+
+var errSubscriberDropped = errors.New("client too slow, reconnect with the ID of the last event received")
+
+// eventFilter narrows down a stream to some change types and to contacts
+// carrying a tag after the change. Empty parts match everything.
+type eventFilter struct {
+	types map[string]bool
+	tag   string
+}
+
+func (f eventFilter) matches(c storage.Change) bool {
+	if len(f.types) > 0 && !f.types[c.Type] {
+		return false
+	}
+	if f.tag != "" && (c.Contact == nil || !c.Contact.HasTag(f.tag)) {
+		return false
+	}
+
+	return true
+}
+
+type eventSubscriber struct {
+	// tenant is the book whose changes the subscriber gets
+	tenant string
+	filter eventFilter
+	events chan storage.Change
+	// dropped is closed when the subscriber fell too far behind
+	dropped chan struct{}
+}
+
+// eventBus hands every logged change to the subscribed streams. Publishing
+// never blocks: subscribers whose buffer is full are dropped.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscriber]bool
+}
+
+func (b *eventBus) subscribe(tenant string, f eventFilter) *eventSubscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &eventSubscriber{
+		tenant:  tenant,
+		filter:  f,
+		events:  make(chan storage.Change, eventBufferSize),
+		dropped: make(chan struct{}),
+	}
+	if b.subscribers == nil {
+		b.subscribers = make(map[*eventSubscriber]bool)
+	}
+	b.subscribers[s] = true
+
+	return s
+}
+
+func (b *eventBus) unsubscribe(s *eventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers, s)
+}
+
+func (b *eventBus) publish(tenant string, c storage.Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscribers {
+		if s.tenant != tenant || !s.filter.matches(c) {
+			continue
+		}
+		select {
+		case s.events <- c:
+		default:
+			delete(b.subscribers, s)
+			close(s.dropped)
+		}
+	}
+}
+
+// eventStream feeds a client the changes after the last event it received,
+// read from the change log, and then the live ones
+type eventStream struct {
+	sub *eventSubscriber
+	// last is the sequence number of the last event sent
+	last   int64
+	resume bool
+}
+
+// openEventStream subscribes to the changes a request asks for, with the
+// types=... and tag=... filters and the ID of the last event received in
+// Last-Event-ID or last_event_id=...
+func (h *ContactHandler) openEventStream(r *http.Request) (*eventStream, error) {
+	keys := r.URL.Query()
+
+	var f eventFilter
+	if v := keys.Get("types"); v != "" {
+		f.types = make(map[string]bool)
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if !storage.IsChangeType(t) {
+				return nil, utils.ErrEventsWrongFormat
+			}
+			f.types[t] = true
+		}
+	}
+	if v := keys.Get("tag"); v != "" {
+		tags, err := storage.NormalizeTags([]string{v})
+		if err != nil || len(tags) == 0 {
+			return nil, utils.ErrEventsWrongFormat
+		}
+		f.tag = tags[0]
+	}
+
+	stream := &eventStream{}
+	lastID := r.Header.Get(LastEventIDHeader)
+	if lastID == "" {
+		lastID = keys.Get("last_event_id")
+	}
+	if lastID != "" {
+		last, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || last < 0 {
+			return nil, utils.ErrEventsWrongFormat
+		}
+		stream.last, stream.resume = last, true
+
+		// Checked now so that the client gets an error status
+		h.mu.Lock()
+		_, _, err = h.readChanges(r, last, 1)
+		h.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Subscribed before the change log is read, live events already sent
+	// from it are skipped
+	stream.sub = h.events.subscribe(tenant(r), f)
+
+	return stream, nil
+}
+
+// sendEventStreamError answers a request whose stream could not be opened
+func sendEventStreamError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, utils.ErrEventsWrongFormat), errors.Is(err, errWSHandshake):
+		utils.SendCustomError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, utils.ErrResyncRequired):
+		utils.SendCustomError(w, http.StatusGone, err.Error())
+	default:
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// pump sends the changes missed since the last event, then live ones and a
+// heartbeat when idle, until done is closed, sending fails or the subscriber
+// is dropped
+func (h *ContactHandler) pump(r *http.Request, stream *eventStream, done <-chan struct{}, send func(storage.Change) error, heartbeat func() error) error {
+	for stream.resume {
+		h.mu.Lock()
+		changes, _, err := h.readChanges(r, stream.last, changesMaxLimit)
+		h.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			break
+		}
+
+		for _, c := range changes {
+			if stream.sub.filter.matches(c) {
+				err = send(c)
+				if err != nil {
+					return err
+				}
+			}
+			stream.last = c.Seq
+		}
+	}
+
+	ticker := time.NewTicker(eventHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case c := <-stream.sub.events:
+			if c.Seq <= stream.last {
+				continue
+			}
+			err := send(c)
+			if err != nil {
+				return err
+			}
+			stream.last = c.Seq
+		case <-ticker.C:
+			err := heartbeat()
+			if err != nil {
+				return err
+			}
+		case <-stream.sub.dropped:
+			return errSubscriberDropped
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// StreamEvents sends contact changes as Server-Sent Events: the event ID is
+// the sequence number of the change, the event name its type and the data
+// the change as in /api/changes. Reconnecting clients get the changes they
+// missed from the change log.
+func (h *ContactHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.SendCustomError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	stream, err := h.openEventStream(r)
+	if err != nil {
+		sendEventStreamError(w, err)
+		return
+	}
+	defer h.events.unsubscribe(stream.sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(c storage.Change) error {
+		data, err := json.Marshal(c)
+		if err == nil {
+			_, err = fmt.Fprintf(w, "id: %v\nevent: %v\ndata: %s\n\n", c.Seq, c.Type, data)
+		}
+		flusher.Flush()
+		return err
+	}
+	heartbeat := func() error {
+		_, err := fmt.Fprint(w, ": heartbeat\n\n")
+		flusher.Flush()
+		return err
+	}
+
+	err = h.pump(r, stream, r.Context().Done(), send, heartbeat)
+	if err != nil && r.Context().Err() == nil {
+		// The client learns why the stream ends before it reconnects
+		data, _ := json.Marshal(map[string]string{"message": err.Error()})
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+}
+
+// StreamEventsWS sends the changes of StreamEvents over a WebSocket, one JSON
+// text message per change, with pings as heartbeats. Browsers cannot set
+// headers on WebSockets, so the last event ID comes in last_event_id=...
+// Clients too slow to keep up are closed with 1008.
+func (h *ContactHandler) StreamEventsWS(w http.ResponseWriter, r *http.Request) {
+	err := checkWSHandshake(r)
+	if err != nil {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		sendEventStreamError(w, err)
+		return
+	}
+
+	stream, err := h.openEventStream(r)
+	if err != nil {
+		sendEventStreamError(w, err)
+		return
+	}
+	defer h.events.unsubscribe(stream.sub)
+
+	conn, err := upgradeWS(w, r)
+	if err != nil {
+		log.Printf("Error upgrading to WebSocket: %v", err)
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		conn.readLoop()
+		close(done)
+	}()
+
+	send := func(c storage.Change) error {
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return conn.writeFrame(wsText, data)
+	}
+	heartbeat := func() error {
+		return conn.writeFrame(wsPing, nil)
+	}
+
+	err = h.pump(r, stream, done, send, heartbeat)
+	switch {
+	case err == nil:
+		// The client closed the connection
+		conn.conn.Close()
+	case errors.Is(err, errSubscriberDropped):
+		conn.close(wsClosePolicy, err.Error())
+	case errors.Is(err, utils.ErrResyncRequired):
+		conn.close(wsClosePolicy, "resync required")
+	default:
+		conn.close(wsCloseError, "")
+	}
+	<-done
+}
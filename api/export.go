@@ -0,0 +1,318 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Formats of /api/export and /api/import
+const (
+	formatVCard = "vcard"
+	formatCSV   = "csv"
+)
+
+// encoder writes contacts in an export format
+type encoder interface {
+	Encode(storage.Contact) error
+	Flush() error
+}
+
+type vCardEncoder struct {
+	w io.Writer
+}
+
+func (e vCardEncoder) Encode(c storage.Contact) error {
+	return storage.EncodeVCard(e.w, c)
+}
+
+func (e vCardEncoder) Flush() error {
+	return nil
+}
+
+// errWalkClosed stops walks that are not run
+var errWalkClosed = errors.New("walk closed")
+
+// closeWalk releases what a walk holds without running it
+func closeWalk(walk storage.WalkFunc) {
+	_ = walk(func(storage.Contact) error { return errWalkClosed })
+}
+
+// ExportContacts writes a single contact (id=...), those matching a filter
+// (field=...&value=...) or the whole book in the format given by "format".
+// The whole book without list options is streamed from the storage.
+func (h *ContactHandler) ExportContacts(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()
+	format := keys.Get("format")
+	if format != formatVCard && format != formatCSV {
+		utils.SendCustomError(w, http.StatusBadRequest, utils.ErrExportWrongFormat.Error())
+		return
+	}
+	options, err := listOptions(r)
+	if err != nil {
+		sendStorageError(w, err, "")
+		return
+	}
+
+	// custom holds the keys of the custom fields in use, CSV has a column
+	// for each
+	custom := make(map[string]bool)
+	start := func() (encoder, error) {
+		if format == formatCSV {
+			keys := make([]string, 0, len(custom))
+			for k := range custom {
+				keys = append(keys, k)
+			}
+			w.Header().Set("Content-Type", storage.CSVContentType+"; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="contacts.csv"`)
+			return storage.NewCSVEncoder(w, keys)
+		}
+		w.Header().Set("Content-Type", storage.VCardContentType+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="contacts.vcf"`)
+		return vCardEncoder{w: w}, nil
+	}
+
+	id := keys.Get("id")
+	filtered := keys.Get("field") != "" || keys.Get("value") != ""
+	if id == "" && !filtered && options.IsEmpty() {
+		// The walk runs without the lock, a slow download holds up nobody.
+		// CSV takes a first walk over the same contacts for its header.
+		h.mu.Lock()
+		walk, err := h.store(r).Walk()
+		var keysWalk storage.WalkFunc
+		if err == nil && format == formatCSV {
+			keysWalk, err = h.store(r).Walk()
+			if err != nil {
+				closeWalk(walk)
+			}
+		}
+		h.mu.Unlock()
+		if err == nil && keysWalk != nil {
+			err = keysWalk(func(c storage.Contact) error {
+				storage.CustomKeys(custom, c)
+				return nil
+			})
+			if err != nil {
+				closeWalk(walk)
+			}
+		}
+		if err != nil {
+			sendStorageError(w, err, "")
+			return
+		}
+
+		enc, err := start()
+		if err == nil {
+			err = walk(enc.Encode)
+		}
+		if err == nil {
+			err = enc.Flush()
+		}
+		if err != nil {
+			// The response has started, the client sees a truncated file
+			log.Printf("Error exporting contacts: %v", err)
+		}
+		return
+	}
+
+	h.mu.Lock()
+	var contacts []storage.Contact
+	switch {
+	case id != "":
+		var c storage.Contact
+		c, err = h.getByIDOrAlias(r, id)
+		contacts = []storage.Contact{c}
+	case filtered:
+		contacts, err = h.store(r).Filter(keys.Get("field"), keys.Get("value"))
+	default:
+		contacts, err = h.store(r).List()
+	}
+	h.mu.Unlock()
+
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+	contacts = options.Apply(contacts)
+	for _, c := range contacts {
+		storage.CustomKeys(custom, c)
+	}
+
+	enc, err := start()
+	for _, c := range contacts {
+		if err != nil {
+			break
+		}
+		err = enc.Encode(c)
+	}
+	if err == nil {
+		err = enc.Flush()
+	}
+	if err != nil {
+		log.Printf("Error exporting contacts: %v", err)
+	}
+}
+
+// decodeImport reads the contacts of an import body in the format given by
+// "format" or, failing that, by the content type. Records that cannot be read
+// come back as errors in place of their contact.
+func decodeImport(r *http.Request) ([]storage.Contact, []error, int, error) {
+	keys := r.URL.Query()
+	format := keys.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case storage.VCardContentType, "text/x-vcard":
+			format = formatVCard
+		case storage.CSVContentType:
+			format = formatCSV
+		}
+	}
+
+	var contacts []storage.Contact
+	var errs []error
+	switch format {
+	case formatVCard:
+		cards, err := storage.DecodeVCards(r.Body)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+		for _, v := range cards {
+			contacts = append(contacts, v.Contact)
+			if v.Err != nil {
+				errs = append(errs, fmt.Errorf("%w: %v", utils.ErrCardWrongFormat, v.Err))
+			} else {
+				errs = append(errs, nil)
+			}
+		}
+	case formatCSV:
+		var mapping storage.CSVMapping
+		var labels map[string]string
+		if v := keys.Get("preset"); v != "" {
+			preset, ok := storage.CSVPresets[v]
+			if !ok {
+				return nil, nil, http.StatusBadRequest, utils.ErrCSVMappingWrongFormat
+			}
+			mapping, labels = storage.CSVMapping{}, preset.Labels
+			for header, target := range preset.Columns {
+				mapping[header] = target
+			}
+		}
+		if v := keys.Get("mapping"); v != "" {
+			custom, err := storage.ParseCSVMapping(v)
+			if err != nil {
+				return nil, nil, http.StatusBadRequest, err
+			}
+			if mapping == nil {
+				mapping = storage.CSVMapping{}
+			}
+			for header, target := range custom {
+				mapping[header] = target
+			}
+		}
+
+		rows, err := storage.DecodeCSV(r.Body, mapping, labels)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+		for _, v := range rows {
+			contacts = append(contacts, v.Contact)
+			if v.Err != nil {
+				errs = append(errs, fmt.Errorf("%w: %v", utils.ErrRowWrongFormat, v.Err))
+			} else {
+				errs = append(errs, nil)
+			}
+		}
+	default:
+		return nil, nil, http.StatusUnsupportedMediaType, utils.ErrImportWrongFormat
+	}
+
+	return contacts, errs, http.StatusOK, nil
+}
+
+// ImportContacts adds every vCard card or CSV row of the body as a new
+// contact, with the validation and enrichment of /api/add, and reports a
+// result per record. Records are imported on their own unless mode=atomic is
+// given. dry_run=true only validates them.
+func (h *ContactHandler) ImportContacts(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()
+	mode := keys.Get("mode")
+	if mode == "" {
+		mode = bulkBestEffort
+	}
+	if mode != bulkAtomic && mode != bulkBestEffort {
+		utils.SendCustomError(w, http.StatusBadRequest, utils.ErrBulkModeWrongFormat.Error())
+		return
+	}
+	atomic := mode == bulkAtomic
+	dryRun, _ := strconv.ParseBool(keys.Get("dry_run"))
+
+	contacts, errs, status, err := decodeImport(r)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, status, err.Error())
+		return
+	}
+
+	results := make([]bulkItemResult, len(contacts))
+	var items []bulkOperation
+	var positions []int
+	failed := false
+	for i := range contacts {
+		if errs[i] != nil {
+			results[i] = bulkFailure(i, storage.BulkCreate, "", errs[i], nil)
+			failed = true
+			continue
+		}
+		items = append(items, bulkOperation{Op: storage.BulkCreate, decoded: &contacts[i]})
+		positions = append(positions, i)
+	}
+
+	switch {
+	case dryRun:
+		for j, item := range items {
+			i := positions[j]
+			op, failure := h.prepareBulk(r, i, item)
+			if failure != nil {
+				results[i] = *failure
+				failed = true
+				continue
+			}
+			c := op.Contact
+			c.ID, c.CreatedBy = "", ""
+			results[i] = bulkItemResult{Index: i, Op: op.Op, Status: http.StatusOK, Contact: &c}
+		}
+	case failed && atomic:
+		for _, i := range positions {
+			results[i] = bulkFailure(i, storage.BulkCreate, "", utils.ErrBulkSkipped, nil)
+		}
+	default:
+		imported, importFailed, err := h.runBulk(r, items, atomic)
+		if err != nil {
+			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for j, v := range imported {
+			v.Index = positions[j]
+			results[v.Index] = v
+		}
+		failed = failed || importFailed
+	}
+
+	switch {
+	case dryRun:
+		utils.SendSuccessResponse(w, fmt.Sprintf("%v records checked, nothing was changed", len(results)), results)
+	case failed && atomic:
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		utils.SendSuccessResponse(w, "Import failed, nothing was changed", results)
+	default:
+		utils.SendSuccessResponse(w, fmt.Sprintf("%v records processed", len(results)), results)
+	}
+}
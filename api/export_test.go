@@ -0,0 +1,92 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// stalledWriter is a response writer whose client stops reading until
+// released
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	select {
+	case w.writing <- struct{}{}:
+	default:
+	}
+	<-w.release
+
+	return w.ResponseRecorder.Write(b)
+}
+
+func (w *stalledWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func TestStalledExportHoldsUpNobody(t *testing.T) {
+	s := newTestServer(t)
+	_, key := s.issueKey("acme", storage.ScopeWrite)
+	s.addContact(key, "Ann Lee", "+1 202 555 0100")
+
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), writing: make(chan struct{}, 1), release: make(chan struct{})}
+	defer close(w.release)
+	go func() {
+		r := httptest.NewRequest(http.MethodGet, "/api/export?format=vcard", nil)
+		r = r.WithContext(context.WithValue(r.Context(), principalContextKey{}, Principal{ID: "acme", Tenant: "acme"}))
+		s.h.ExportContacts(w, r)
+	}()
+	select {
+	case <-w.writing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the export wrote nothing")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.addContact(key, "Bob Ray", "+1 202 555 0101")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("adding a contact waited for the stalled export")
+	}
+}
+
+func TestCSVExportKeepsCustomFields(t *testing.T) {
+	s, acme, globex := twoTenants(t, storage.ScopeWrite)
+	body := map[string]interface{}{
+		"name": "Ann Lee", "phone": "+1 202 555 0100", "gender": "female", "country": "US",
+		"custom": map[string]string{"department": "Sales"},
+	}
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/add", acme, body)
+	s.addContact(acme, "Bob Ray", "+1 202 555 0101")
+
+	for _, path := range []string{"/api/export?format=csv", "/api/export?format=csv&sort=name"} {
+		response, data := s.request(http.MethodGet, path, acme, nil, nil)
+		if response.StatusCode != http.StatusOK || !strings.Contains(strings.SplitN(string(data), "\n", 2)[0], ",custom.department") {
+			t.Fatalf("%v answered %v:\n%s", path, response.StatusCode, data)
+		}
+
+		response, _ = s.request(http.MethodPost, "/api/import", globex, string(data), http.Header{"Content-Type": {storage.CSVContentType}})
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("importing the export of %v answered %v", path, response.StatusCode)
+		}
+	}
+
+	var contacts []storage.Contact
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/filter", globex, map[string]string{"field": "name", "value": "Ann"}).decode(t, &contacts)
+	if len(contacts) != 2 || contacts[0].Custom["department"] != "Sales" || contacts[1].Custom["department"] != "Sales" {
+		t.Fatalf("imported %+v", contacts)
+	}
+}
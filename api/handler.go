@@ -2,12 +2,13 @@ package api
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"io"
+	"log"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/sgnl-05/contactService/storage"
 	"github.com/sgnl-05/contactService/utils"
@@ -16,11 +17,66 @@ import (
 type ContactHandler struct {
 	mu      sync.Mutex
 	Storage storage.StorageInterface
+	// ReEnrichOnEdit re-runs gender and country guessing for enriched fields
+	// when an edit changes the contact's name
+	ReEnrichOnEdit bool
+	// IdempotencyWindow is how long responses to requests with an
+	// Idempotency-Key are replayed, 0 ignores the header
+	IdempotencyWindow time.Duration
+	// inFlight holds the idempotency keys of requests being handled
+	inFlight map[string]bool
+	// purgedAt is when contacts were last purged from each book, CardDAV sync
+	// tokens issued before it are refused
+	purgedAt map[string]time.Time
+	// changed holds a channel per book closed when a change is logged, waking
+	// up long polls
+	changed map[string]chan struct{}
+	// events hands logged changes to /api/events streams
+	events eventBus
+	// WebhookAttempts is how many times a delivery is tried before it becomes
+	// a dead letter, WebhookBackoff the wait before the first retry, doubled
+	// after every attempt
+	WebhookAttempts int
+	WebhookBackoff  time.Duration
+	// sleep waits between delivery attempts, time.Sleep when nil
+	sleep func(time.Duration)
+	// webhooks caches the webhook subscriptions of the books read from
+	// storage
+	webhooks map[string][]storage.Webhook
+	// RequireAuth refuses requests without an API key, BootstrapKeyHash is
+	// the hash of an admin key from the configuration
+	RequireAuth      bool
+	BootstrapKeyHash string
+	// JWT accepts bearer tokens along with API keys when set
+	JWT *JWTVerifier
+	// RateLimits limits the requests of each client per class of routes,
+	// MaxBodySize the size of request bodies, 0 for any size
+	RateLimits  map[string]*RateLimiter
+	MaxBodySize int64
+	// Rules validate and normalize contacts, storage.DefaultRules when nil
+	Rules *storage.RuleSet
+}
+
+// defaultRules are the rules of handlers without their own
+var defaultRules = storage.DefaultRules()
+
+func (h *ContactHandler) rules() *storage.RuleSet {
+	if h.Rules == nil {
+		return defaultRules
+	}
+
+	return h.Rules
 }
 
 func (h *ContactHandler) ListContacts(w http.ResponseWriter, r *http.Request) {
+	options, err := listOptions(r)
+	if err != nil {
+		sendStorageError(w, err, "")
+		return
+	}
+
 	h.mu.Lock()
-	allContacts, err := h.Storage.List()
+	allContacts, err := h.store(r).List()
 
 	h.mu.Unlock()
 
@@ -29,7 +85,7 @@ func (h *ContactHandler) ListContacts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.SendSuccessResponse(w, "Full list of contacts", allContacts)
+	utils.SendSuccessResponse(w, "Full list of contacts", presentContacts(r, options.Apply(allContacts)))
 }
 
 func (h *ContactHandler) AddContact(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +100,13 @@ func (h *ContactHandler) AddContact(w http.ResponseWriter, r *http.Request) {
 	var newContactBody storage.Contact
 	err = json.Unmarshal(body, &newContactBody)
 	if err != nil {
-		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		sendMalformedJSON(w, err)
+		return
+	}
+
+	fields := newContactBody.Normalize(h.rules().Create)
+	if len(fields) > 0 {
+		sendFieldErrors(w, fields)
 		return
 	}
 
@@ -60,14 +122,22 @@ func (h *ContactHandler) AddContact(w http.ResponseWriter, r *http.Request) {
 
 	// Adding
 	newContactBody.ID = uuid.New().String()
-	err = h.Storage.Add(newContactBody)
+	newContactBody.CreatedBy = actor(r)
+	err = h.store(r).Add(newContactBody)
+	if err != nil {
+		sendStorageError(w, err, newContactBody.ID)
+		return
+	}
+
+	newContactBody, err = h.store(r).Get(newContactBody.ID)
 	if err != nil {
 		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	h.record(r, storage.ActionCreate, nil, &newContactBody)
 
 	responseBody := []storage.Contact{newContactBody}
-	utils.SendSuccessResponse(w, "New contact successfully added", responseBody)
+	utils.SendSuccessResponse(w, "New contact successfully added", presentContacts(r, responseBody))
 }
 
 func (h *ContactHandler) DeleteContact(w http.ResponseWriter, r *http.Request) {
@@ -78,18 +148,25 @@ func (h *ContactHandler) DeleteContact(w http.ResponseWriter, r *http.Request) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	before, err := h.store(r).Get(idDelete)
+	if err != nil {
+		sendStorageError(w, err, idDelete)
+		return
+	}
+
 	// Deleting
-	err := h.Storage.Delete(idDelete)
+	err = h.store(r).Delete(idDelete)
 	if err != nil {
-		if errors.Is(err, utils.ErrContactNotFound) {
-			utils.SendCustomError(w, http.StatusBadRequest, fmt.Sprintf("No contact with ID: \"%v\"", idDelete))
-			return
-		}
-		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		sendStorageError(w, err, idDelete)
 		return
 	}
 
-	utils.SendSuccessResponseNoData(w, fmt.Sprintf("Contact \"%v\" successfully deleted", idDelete))
+	trashed, err := h.store(r).GetWithTrash(idDelete)
+	if err == nil {
+		h.record(r, storage.ActionDelete, &before, &trashed)
+	}
+
+	utils.SendSuccessResponseNoData(w, fmt.Sprintf("Contact \"%v\" moved to trash", idDelete))
 }
 
 func (h *ContactHandler) EditContact(w http.ResponseWriter, r *http.Request) {
@@ -104,26 +181,60 @@ func (h *ContactHandler) EditContact(w http.ResponseWriter, r *http.Request) {
 	var editContactBody storage.EditContact
 	err = json.Unmarshal(body, &editContactBody)
 	if err != nil {
-		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		sendMalformedJSON(w, err)
 		return
 	}
 
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	previous, err := h.store(r).Get(editContactBody.ID)
+	if err != nil {
+		h.mu.Unlock()
+		sendStorageError(w, err, editContactBody.ID)
+		return
+	}
+
+	fields := editContactBody.Normalize(previous.Country, h.rules().Edit)
+	if len(fields) > 0 {
+		h.mu.Unlock()
+		sendFieldErrors(w, fields)
+		return
+	}
 
 	// Editing
-	resultBody, err := h.Storage.Edit(editContactBody)
+	_, err = h.store(r).Edit(editContactBody)
 	if err != nil {
-		if errors.Is(err, utils.ErrContactNotFound) {
-			utils.SendCustomError(w, http.StatusBadRequest, fmt.Sprintf("No contact with ID: \"%v\"", editContactBody.ID))
-			return
-		}
+		h.mu.Unlock()
+		sendStorageError(w, err, editContactBody.ID)
+		return
+	}
+
+	resultBody, err := h.store(r).Get(editContactBody.ID)
+	if err != nil {
+		h.mu.Unlock()
 		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	h.record(r, storage.ActionUpdate, &previous, &resultBody)
+	h.mu.Unlock()
+
+	// Guessed fields are stale once the name changes. Enrichment calls
+	// external APIs, so it runs outside the lock and is recorded as a change
+	// of its own. The edit is stored by then, so a failure does not fail the
+	// request.
+	message := fmt.Sprintf("Contact \"%v\" successfully updated", editContactBody.ID)
+	if h.ReEnrichOnEdit && resultBody.Name != previous.Name {
+		enriched, err := h.enrich(r, resultBody, true)
+		if err != nil {
+			log.Printf("Error re-enriching contact %v: %v", editContactBody.ID, err)
+			message += fmt.Sprintf(", but gender and country could not be guessed again: %v", err)
+		} else {
+			resultBody = enriched
+		}
+	}
+
 	responseBody := []storage.Contact{resultBody}
 
-	utils.SendSuccessResponse(w, fmt.Sprintf("Contact \"%v\" successfully updated", editContactBody.ID), responseBody)
+	utils.SendSuccessResponse(w, message, presentContacts(r, responseBody))
 }
 
 func (h *ContactHandler) Filter(w http.ResponseWriter, r *http.Request) {
@@ -137,29 +248,49 @@ func (h *ContactHandler) Filter(w http.ResponseWriter, r *http.Request) {
 	var filterRequest storage.FilterRequest
 	err = json.Unmarshal(body, &filterRequest)
 	if err != nil {
-		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		sendMalformedJSON(w, err)
 		return
 	}
-	if filterRequest.Field == "" || filterRequest.Value == "" {
+	// Time bounds alone are a valid filter
+	noField := filterRequest.Field == "" && filterRequest.Value == ""
+	incomplete := filterRequest.Field == "" || filterRequest.Value == ""
+	if noField && filterRequest.ListOptions.IsEmpty() || !noField && incomplete {
 		utils.SendCustomError(w, http.StatusBadRequest, utils.ErrFilterWrongFormat.Error())
 		return
 	}
+	err = filterRequest.ListOptions.Validate()
+	if err != nil {
+		sendStorageError(w, err, "")
+		return
+	}
 
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	filterResult, err := h.Storage.Filter(filterRequest.Field, filterRequest.Value)
+	var filterResult []storage.Contact
+	if noField {
+		filterResult, err = h.store(r).List()
+	} else {
+		filterResult, err = h.store(r).Filter(filterRequest.Field, filterRequest.Value)
+	}
 	if err != nil {
-		utils.SendCustomError(w, http.StatusBadRequest, err.Error())
+		sendStorageError(w, err, "")
 		return
 	}
+	filterResult = filterRequest.ListOptions.Apply(filterResult)
 
-	utils.SendSuccessResponse(w, fmt.Sprintf("All contacts containing the filter substring in %v", filterRequest.Field), filterResult)
+	utils.SendSuccessResponse(w, fmt.Sprintf("All contacts containing the filter substring in %v", filterRequest.Field), presentContacts(r, filterResult))
 }
 
 func (h *ContactHandler) ListFavorites(w http.ResponseWriter, r *http.Request) {
+	options, err := listOptions(r)
+	if err != nil {
+		sendStorageError(w, err, "")
+		return
+	}
+
 	h.mu.Lock()
-	favContacts, err := h.Storage.ListFavs()
+	favContacts, err := h.store(r).ListFavs()
 	h.mu.Unlock()
 
 	if err != nil {
@@ -167,7 +298,7 @@ func (h *ContactHandler) ListFavorites(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.SendSuccessResponse(w, "Full list of favorites", favContacts)
+	utils.SendSuccessResponse(w, "Full list of favorites", presentContacts(r, options.Apply(favContacts)))
 }
 
 func (h *ContactHandler) ChangeFavorite(w http.ResponseWriter, r *http.Request) {
@@ -186,25 +317,21 @@ func (h *ContactHandler) ChangeFavorite(w http.ResponseWriter, r *http.Request)
 
 	//Changing
 	h.mu.Lock()
-	err := h.Storage.ChangeFavs(id, action)
+	before, err := h.store(r).Get(id)
+	if err == nil {
+		err = h.store(r).ChangeFavs(id, action)
+	}
+	var after storage.Contact
+	if err == nil {
+		after, err = h.store(r).Get(id)
+	}
+	if err == nil {
+		h.record(r, storage.ActionFavorite, &before, &after)
+	}
 	h.mu.Unlock()
 	if err != nil {
-		if errors.Is(err, utils.ErrAlreadyFav) {
-			utils.SendCustomError(w, http.StatusBadRequest, fmt.Sprintf("contact \"%v\" is already in favorites", id))
-			return
-		} else if errors.Is(err, utils.ErrAlreadyNotFav) {
-			utils.SendCustomError(w, http.StatusBadRequest, fmt.Sprintf("contact \"%v\" is not in favorites already", id))
-			return
-		} else if errors.Is(err, utils.ErrFavWrongFormat) {
-			utils.SendCustomError(w, http.StatusBadRequest, err.Error())
-			return
-		} else if errors.Is(err, utils.ErrContactNotFound) {
-			utils.SendCustomError(w, http.StatusBadRequest, fmt.Sprintf("contact with ID \"%v\" not found", id))
-			return
-		} else {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
+		sendStorageError(w, err, id)
+		return
 	}
 
 	if action == "add" {
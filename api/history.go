@@ -0,0 +1,247 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+type revertRequest struct {
+	Version int       `json:"version"`
+	At      time.Time `json:"at"`
+}
+
+// recordedChange is a change of a contact that already happened
+type recordedChange struct {
+	action string
+	before *storage.Contact
+	after  *storage.Contact
+}
+
+// record stores a revision for a change that already happened and adds it to
+// the change log. It must be called with h.mu held. A failure is logged, the
+// change itself stands.
+func (h *ContactHandler) record(r *http.Request, action string, before *storage.Contact, after *storage.Contact) {
+	h.recordAll(r, []recordedChange{{action: action, before: before, after: after}})
+}
+
+// recordAll records many changes at once, so that a bulk request writes the
+// history and the change log once rather than once per contact. It must be
+// called with h.mu held.
+func (h *ContactHandler) recordAll(r *http.Request, changes []recordedChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	revisions := make([]storage.Revision, len(changes))
+	entries := make([]storage.Change, len(changes))
+	for i, v := range changes {
+		revisions[i] = storage.NewRevision(v.action, actor(r), v.before, v.after)
+		entries[i] = storage.NewChange(revisions[i])
+	}
+
+	err := h.store(r).AddRevisions(revisions)
+	if err != nil {
+		log.Printf("Error recording %v revision(s): %v", len(revisions), err)
+	}
+
+	appended, err := h.store(r).AppendChanges(entries)
+	if err != nil {
+		log.Printf("Error logging %v change(s): %v", len(entries), err)
+	}
+	if len(appended) == 0 {
+		return
+	}
+	h.notifyChange(r)
+	for _, change := range appended {
+		h.events.publish(tenant(r), change)
+		h.dispatchWebhooks(r, change)
+	}
+}
+
+// update stores a changed contact and records the change. It must be called
+// with h.mu held and returns the contact as stored.
+func (h *ContactHandler) update(r *http.Request, before storage.Contact, c storage.Contact) (storage.Contact, error) {
+	err := h.store(r).Update(c)
+	if err != nil {
+		return c, err
+	}
+
+	c, err = h.store(r).Get(c.ID)
+	if err != nil {
+		return c, err
+	}
+	h.record(r, storage.ActionUpdate, &before, &c)
+
+	return c, nil
+}
+
+// getByIDOrAlias finds a contact by its ID or, failing that, by the ID of a
+// contact merged into it. It must be called with h.mu held.
+func (h *ContactHandler) getByIDOrAlias(r *http.Request, id string) (storage.Contact, error) {
+	c, err := h.store(r).Get(id)
+	if !errors.Is(err, utils.ErrContactNotFound) {
+		return c, err
+	}
+
+	contacts, aliasErr := h.store(r).Filter("alias", id)
+	if aliasErr != nil || len(contacts) == 0 {
+		return c, err
+	}
+
+	return contacts[0], nil
+}
+
+func (h *ContactHandler) ContactHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	revisions, err := h.store(r).History(id)
+	h.mu.Unlock()
+
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(revisions) == 0 {
+		sendStorageError(w, utils.ErrContactNotFound, id)
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("History of contact \"%v\"", id), revisions)
+}
+
+// GetContact returns a contact, or the version it had at the RFC 3339 time
+// given in the "at" query parameter. IDs of merged contacts lead to the
+// contact they were merged into.
+func (h *ContactHandler) GetContact(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	at := r.URL.Query().Get("at")
+	if at == "" {
+		h.mu.Lock()
+		c, err := h.getByIDOrAlias(r, id)
+		h.mu.Unlock()
+
+		if err != nil {
+			sendStorageError(w, err, id)
+			return
+		}
+
+		utils.SendSuccessResponse(w, fmt.Sprintf("Contact \"%v\"", id), presentContacts(r, []storage.Contact{c}))
+		return
+	}
+
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusBadRequest, utils.ErrAtWrongFormat.Error())
+		return
+	}
+
+	h.mu.Lock()
+	revisions, err := h.store(r).History(id)
+	h.mu.Unlock()
+
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	state := storage.StateAt(revisions, t)
+	if state == nil {
+		utils.SendCustomError(w, http.StatusNotFound, fmt.Sprintf("No contact with ID \"%v\" at %v", id, at))
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Contact \"%v\" at %v", id, at), presentContacts(r, []storage.Contact{*state}))
+}
+
+// RevertContact brings a contact back to the state after a given revision
+// version, or to its state at a given time. Trashed and purged contacts are
+// brought back as well.
+func (h *ContactHandler) RevertContact(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var request revertRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		sendMalformedJSON(w, err)
+		return
+	}
+	if (request.Version == 0) == request.At.IsZero() {
+		utils.SendCustomError(w, http.StatusBadRequest, utils.ErrRevertWrongFormat.Error())
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	revisions, err := h.store(r).History(id)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var target *storage.Contact
+	if request.Version != 0 {
+		if request.Version < 0 || request.Version > len(revisions) {
+			utils.SendCustomError(w, http.StatusNotFound, fmt.Sprintf("contact \"%v\" has no version %v", id, request.Version))
+			return
+		}
+		target = revisions[request.Version-1].After
+		if target != nil && target.IsDeleted() {
+			target = nil
+		}
+	} else {
+		target = storage.StateAt(revisions, request.At)
+	}
+	if target == nil {
+		utils.SendCustomError(w, http.StatusUnprocessableEntity, fmt.Sprintf("contact \"%v\" did not exist in the requested version", id))
+		return
+	}
+
+	// The latest revision holds the trashed state of deleted contacts
+	before := revisions[len(revisions)-1].After
+	current, err := h.store(r).Get(id)
+	switch {
+	case err == nil:
+		before = &current
+		err = h.store(r).Update(*target)
+	case errors.Is(err, utils.ErrContactNotFound):
+		// Trashed contacts are restored first, purged ones added again
+		_, err = h.store(r).Restore(id)
+		if err == nil {
+			err = h.store(r).Update(*target)
+		} else if errors.Is(err, utils.ErrNotInTrash) {
+			err = h.store(r).Add(*target)
+		}
+	}
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	after, err := h.store(r).Get(id)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.record(r, storage.ActionRevert, before, &after)
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Contact \"%v\" reverted", id), presentContacts(r, []storage.Contact{after}))
+}
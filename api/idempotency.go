@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// IdempotencyKeyHeader lets clients retry a POST request without repeating
+// its effect
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader marks a response replayed for a retried request
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// recorder keeps a copy of a response to store it for retries
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+
+	return rec.ResponseWriter.Write(b)
+}
+
+// Idempotent replays the stored response of a POST request retried with the
+// same Idempotency-Key within h.IdempotencyWindow. Keys belong to the actor
+// sending them. Server errors are not stored so that the retry runs again.
+func (h *ContactHandler) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get(IdempotencyKeyHeader)
+		if r.Method != http.MethodPost || header == "" || h.IdempotencyWindow <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		key := actor(r) + " " + header
+		// Books share the requests being handled
+		flight := tenant(r) + " " + key
+		fingerprint := storage.RequestFingerprint(r.Method, r.URL.RequestURI(), body)
+
+		h.mu.Lock()
+		record, found, err := h.store(r).GetIdempotent(key)
+		if err == nil && found && record.CreatedAt.Before(time.Now().Add(-h.IdempotencyWindow)) {
+			found = false
+		}
+		inFlight := h.inFlight[flight]
+		if err == nil && !found && !inFlight {
+			if h.inFlight == nil {
+				h.inFlight = make(map[string]bool)
+			}
+			h.inFlight[flight] = true
+		}
+		h.mu.Unlock()
+
+		switch {
+		case err != nil:
+			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+			return
+		case found && record.Fingerprint != fingerprint:
+			utils.SendCustomError(w, http.StatusUnprocessableEntity, utils.ErrIdempotencyKeyReused.Error())
+			return
+		case found:
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(record.Status)
+			w.Write(record.Body)
+			return
+		case inFlight:
+			utils.SendCustomError(w, http.StatusConflict, utils.ErrIdempotencyKeyInFlight.Error())
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.inFlight, flight)
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+		err = h.store(r).SaveIdempotent(storage.IdempotencyRecord{
+			Key:         key,
+			Fingerprint: fingerprint,
+			Status:      rec.status,
+			Body:        rec.body.Bytes(),
+			CreatedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			log.Printf("Error storing response for idempotency key %q: %v", header, err)
+		}
+	})
+}
+
+// StartIdempotencyExpirer drops stored responses older than
+// h.IdempotencyWindow every interval until stop is closed
+func (h *ContactHandler) StartIdempotencyExpirer(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				h.mu.Lock()
+				tenants, listErr := h.tenants()
+				for _, t := range tenants {
+					_, err := h.Storage.Tenant(t).ExpireIdempotent(time.Now().Add(-h.IdempotencyWindow))
+					if err != nil {
+						log.Printf("Error expiring idempotency keys of tenant %q: %v", t, err)
+					}
+				}
+				h.mu.Unlock()
+				if listErr != nil {
+					log.Printf("Error listing tenants: %v", listErr)
+				}
+			}
+		}
+	}()
+}
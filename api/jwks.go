@@ -0,0 +1,173 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksMinRefetch keeps tokens with unknown key IDs from refetching the JWKS
+// on every request
+const jwksMinRefetch = time.Minute
+
+// jwksClient fetches JWKS from URLs
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+// jwk is a key of a JSON Web Key Set (RFC 7517), RSA or EC
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the RSA or P-256 key a JWK describes
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %v", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("key %v is not on P-256", k.Kid)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", k.Kty)
+	}
+}
+
+// JWKS holds the signing keys of a token issuer, read from a file or an
+// http(s) URL and read again every Refresh, or sooner when a token names a
+// key it does not know
+type JWKS struct {
+	Source  string
+	Refresh time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// read returns the contents of the JWKS source
+func (s *JWKS) read() ([]byte, error) {
+	if !strings.HasPrefix(s.Source, "http://") && !strings.HasPrefix(s.Source, "https://") {
+		return ioutil.ReadFile(s.Source)
+	}
+
+	response, err := jwksClient.Get(s.Source)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %v: %v", s.Source, response.Status)
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+// Load reads the keys from the source. Keys that cannot be used to check
+// signatures are skipped; a set without any usable key is an error.
+func (s *JWKS) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.load()
+}
+
+func (s *JWKS) load() error {
+	s.fetchedAt = time.Now()
+
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		return fmt.Errorf("reading JWKS %v: %v", s.Source, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, v := range set.Keys {
+		if v.Use != "" && v.Use != "sig" {
+			continue
+		}
+		key, err := v.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[v.Kid] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("JWKS %v has no RSA or P-256 signing key", s.Source)
+	}
+	s.keys = keys
+
+	return nil
+}
+
+// key returns the key with an ID, or the only key when the token names none.
+// The keys are read again when stale or when the ID is unknown; the cached
+// ones are kept if that fails.
+func (s *JWKS) key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	since := time.Since(s.fetchedAt)
+	_, known := s.keys[kid]
+	if s.keys == nil || (s.Refresh > 0 && since > s.Refresh) || (!known && since > jwksMinRefetch) {
+		err := s.load()
+		if err != nil && s.keys == nil {
+			return nil, err
+		}
+	}
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
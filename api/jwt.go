@@ -0,0 +1,242 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// jwtLeeway tolerates clock skew between the issuer and the service
+const jwtLeeway = time.Minute
+
+// JWTVerifier accepts RS256 and ES256 bearer tokens signed with a key of
+// JWKS, issued by Issuer for Audience. The scopes of a token are the values
+// of ScopeClaim, a space separated string or a list, that are scopes once
+// ScopePrefix is removed. UserClaim names the user. TenantClaim names the
+// book the token works on, by default the subject's own.
+type JWTVerifier struct {
+	JWKS        *JWKS
+	Issuer      string
+	Audience    string
+	ScopeClaim  string
+	ScopePrefix string
+	UserClaim   string
+	TenantClaim string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// decodeSegment decodes a base64url part of a token as JSON
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+// checkSignature verifies the signature of the signed part of a token
+func checkSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 token signed with a key that is not RSA")
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature)
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("ES256 token signed with a key that is not EC")
+		}
+		// JWS signatures are r and s side by side, not ASN.1
+		if len(signature) != 64 {
+			return errors.New("ES256 signature must be 64 bytes")
+		}
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return errors.New("signature does not match")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %q, please use RS256 or ES256", alg)
+	}
+}
+
+// numericDate reads a time claim, in seconds since the epoch
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%v must be a number", name)
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%v must be a number", name)
+	}
+
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+// claimStrings reads a claim holding a string or a list of strings
+func claimStrings(v interface{}, split bool) []string {
+	switch v := v.(type) {
+	case string:
+		if split {
+			return strings.Fields(v)
+		}
+		return []string{v}
+	case []interface{}:
+		var res []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	default:
+		return nil
+	}
+}
+
+// checkClaims checks the expiry, start, issuer and audience of a token
+func (v *JWTVerifier) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+
+	exp, ok, err := numericDate(claims, "exp")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("token has no exp")
+	}
+	if now.After(exp.Add(jwtLeeway)) {
+		return errors.New("token expired")
+	}
+	nbf, ok, err := numericDate(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(jwtLeeway).Before(nbf) {
+		return errors.New("token not valid yet")
+	}
+
+	if v.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.Issuer {
+			return fmt.Errorf("token issued by %q, not %q", iss, v.Issuer)
+		}
+	}
+	if v.Audience != "" {
+		found := false
+		for _, aud := range claimStrings(claims["aud"], false) {
+			if aud == v.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("token is not meant for %q", v.Audience)
+		}
+	}
+
+	return nil
+}
+
+// principal maps the claims of a checked token to who made the request
+func (v *JWTVerifier) principal(claims map[string]interface{}) Principal {
+	p := Principal{Claims: claims}
+	p.ID, _ = claims["sub"].(string)
+	p.Name = p.ID
+	if v.UserClaim != "" {
+		if name, ok := claims[v.UserClaim].(string); ok && name != "" {
+			p.Name = name
+		}
+	}
+
+	book := p.ID
+	if v.TenantClaim != "" {
+		if t, ok := claims[v.TenantClaim].(string); ok && t != "" {
+			book = t
+		}
+	}
+	p.Tenant = storage.TenantID(book)
+
+	scopeClaim := v.ScopeClaim
+	if scopeClaim == "" {
+		scopeClaim = "scope"
+	}
+	for _, s := range claimStrings(claims[scopeClaim], true) {
+		if !strings.HasPrefix(s, v.ScopePrefix) {
+			continue
+		}
+		s = strings.TrimPrefix(s, v.ScopePrefix)
+		if storage.IsScope(s) {
+			p.Scopes = append(p.Scopes, s)
+		}
+	}
+
+	return p
+}
+
+// Verify checks a bearer token and returns who it was issued to
+func (v *JWTVerifier) Verify(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Principal{}, errors.New("token must have three parts")
+	}
+
+	var header jwtHeader
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return Principal{}, fmt.Errorf("reading header: %v", err)
+	}
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return Principal{}, fmt.Errorf("unsupported algorithm %q, please use RS256 or ES256", header.Alg)
+	}
+	key, err := v.JWKS.key(header.Kid)
+	if err != nil {
+		return Principal{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, fmt.Errorf("reading signature: %v", err)
+	}
+	err = checkSignature(header.Alg, key, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	var claims map[string]interface{}
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return Principal{}, fmt.Errorf("reading claims: %v", err)
+	}
+	err = v.checkClaims(claims)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	return v.principal(claims), nil
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// testIssuer signs tokens with a key and serves the public keys it is told to
+// as a JWKS
+type testIssuer struct {
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	served  []jwk
+	fetches int
+	server  *httptest.Server
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	issuer.serve(issuer.rsaJWK("rsa-1"), issuer.ecJWK("ec-1"))
+	issuer.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.mu.Lock()
+		defer issuer.mu.Unlock()
+		issuer.fetches++
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": issuer.served})
+	}))
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func encodeInt(n *big.Int, size int) string {
+	b := n.Bytes()
+	if size > len(b) {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (i *testIssuer) rsaJWK(kid string) jwk {
+	return jwk{Kty: "RSA", Kid: kid, Use: "sig", N: encodeInt(i.rsaKey.N, 0), E: encodeInt(big.NewInt(int64(i.rsaKey.E)), 0)}
+}
+
+func (i *testIssuer) ecJWK(kid string) jwk {
+	return jwk{Kty: "EC", Kid: kid, Crv: "P-256", X: encodeInt(i.ecKey.X, 32), Y: encodeInt(i.ecKey.Y, 32)}
+}
+
+// serve replaces the keys of the JWKS
+func (i *testIssuer) serve(keys ...jwk) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.served = keys
+}
+
+func (i *testIssuer) fetchCount() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.fetches
+}
+
+// sign returns a token with claims, signed as alg says with the key of the
+// issuer for it. Unknown algorithms are signed with HMAC keyed with the RSA
+// modulus, as forgeries using the public key would be.
+func (i *testIssuer) sign(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "RS256":
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, i.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		mac := hmac.New(sha256.New, i.rsaKey.N.Bytes())
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims are the claims of a token accepted by testVerifier
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "user-1",
+		"iss":   "https://issuer.test",
+		"aud":   "contacts",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "openid contacts:write",
+	}
+}
+
+func (i *testIssuer) verifier(t *testing.T) *JWTVerifier {
+	t.Helper()
+
+	jwks := &JWKS{Source: i.server.URL, Refresh: time.Hour}
+	err := jwks.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &JWTVerifier{JWKS: jwks, Issuer: "https://issuer.test", Audience: "contacts", ScopeClaim: "scope", ScopePrefix: "contacts:"}
+}
+
+func TestJWTAcceptsRS256AndES256(t *testing.T) {
+	issuer := newTestIssuer(t)
+	v := issuer.verifier(t)
+
+	for _, test := range []struct{ alg, kid string }{{"RS256", "rsa-1"}, {"ES256", "ec-1"}} {
+		p, err := v.Verify(issuer.sign(t, test.alg, test.kid, validClaims()))
+		if err != nil {
+			t.Fatalf("%v token refused: %v", test.alg, err)
+		}
+		if p.ID != "user-1" || p.Tenant != "user-1" || len(p.Scopes) != 1 || p.Scopes[0] != storage.ScopeWrite {
+			t.Fatalf("%v token is %+v", test.alg, p)
+		}
+	}
+}
+
+func TestJWTRefusesBadTokens(t *testing.T) {
+	issuer := newTestIssuer(t)
+	v := issuer.verifier(t)
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	noExp := validClaims()
+	delete(noExp, "exp")
+	early := validClaims()
+	early["nbf"] = time.Now().Add(time.Hour).Unix()
+	wrongIss := validClaims()
+	wrongIss["iss"] = "https://evil.test"
+	wrongAud := validClaims()
+	wrongAud["aud"] = []string{"billing"}
+
+	tampered := issuer.sign(t, "RS256", "rsa-1", validClaims())
+	parts := strings.Split(tampered, ".")
+	forged := validClaims()
+	forged["sub"] = "admin"
+	payload, _ := json.Marshal(forged)
+	tampered = parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	tests := map[string]string{
+		"HS256 with the public key": issuer.sign(t, "HS256", "rsa-1", validClaims()),
+		"none":                      issuer.sign(t, "none", "rsa-1", validClaims()),
+		"RS256 header on EC key":    issuer.sign(t, "RS256", "ec-1", validClaims()),
+		"expired":                   issuer.sign(t, "RS256", "rsa-1", expired),
+		"without exp":               issuer.sign(t, "RS256", "rsa-1", noExp),
+		"not valid yet":             issuer.sign(t, "ES256", "ec-1", early),
+		"wrong issuer":              issuer.sign(t, "RS256", "rsa-1", wrongIss),
+		"wrong audience":            issuer.sign(t, "ES256", "ec-1", wrongAud),
+		"unknown kid":               issuer.sign(t, "RS256", "rsa-2", validClaims()),
+		"tampered claims":           tampered,
+		"not a JWT":                 "abc.def",
+	}
+	for name, token := range tests {
+		if p, err := v.Verify(token); err == nil {
+			t.Errorf("%v token accepted as %+v", name, p)
+		}
+	}
+}
+
+func TestJWKSRefetchesForUnknownKeys(t *testing.T) {
+	issuer := newTestIssuer(t)
+	v := issuer.verifier(t)
+
+	// The issuer rotates to a new key
+	issuer.serve(issuer.rsaJWK("rsa-2"))
+	token := issuer.sign(t, "RS256", "rsa-2", validClaims())
+
+	// A minute has not passed since the keys were read
+	_, err := v.Verify(token)
+	if err == nil || issuer.fetchCount() != 1 {
+		t.Fatalf("token with a new key: %v after %v fetches, want refused after 1", err, issuer.fetchCount())
+	}
+
+	v.JWKS.mu.Lock()
+	v.JWKS.fetchedAt = time.Now().Add(-jwksMinRefetch - time.Second)
+	v.JWKS.mu.Unlock()
+	_, err = v.Verify(token)
+	if err != nil || issuer.fetchCount() != 2 {
+		t.Fatalf("token with a new key: %v after %v fetches, want accepted after 2", err, issuer.fetchCount())
+	}
+
+	// The old key is gone from the set
+	_, err = v.Verify(issuer.sign(t, "RS256", "rsa-1", validClaims()))
+	if err == nil {
+		t.Fatal("token with a retired key accepted")
+	}
+}
+
+func TestJWKSRefreshesStaleKeys(t *testing.T) {
+	issuer := newTestIssuer(t)
+	v := issuer.verifier(t)
+	v.JWKS.Refresh = time.Millisecond
+	token := issuer.sign(t, "ES256", "ec-1", validClaims())
+
+	time.Sleep(5 * time.Millisecond)
+	_, err := v.Verify(token)
+	if err != nil || issuer.fetchCount() != 2 {
+		t.Fatalf("stale keys: %v after %v fetches, want accepted after 2", err, issuer.fetchCount())
+	}
+
+	// Cached keys are kept when the issuer cannot be reached
+	issuer.server.Close()
+	time.Sleep(5 * time.Millisecond)
+	_, err = v.Verify(token)
+	if err != nil {
+		t.Fatalf("token refused while the JWKS is unreachable: %v", err)
+	}
+}
+
+func TestBearerTokensAuthenticateRequests(t *testing.T) {
+	issuer := newTestIssuer(t)
+	s := newTestServer(t)
+	s.h.JWT = issuer.verifier(t)
+
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": {"Bearer " + token}}
+	}
+
+	response, _ := s.request(http.MethodGet, "/api/list", "", nil, bearer(issuer.sign(t, "ES256", "ec-1", validClaims())))
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("valid token answered %v", response.StatusCode)
+	}
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	response, _ = s.request(http.MethodGet, "/api/list", "", nil, bearer(issuer.sign(t, "RS256", "rsa-1", expired)))
+	if response.StatusCode != http.StatusUnauthorized || !strings.Contains(response.Header.Get("WWW-Authenticate"), "invalid_token") {
+		t.Fatalf("expired token answered %v with %q", response.StatusCode, response.Header.Get("WWW-Authenticate"))
+	}
+
+	readOnly := validClaims()
+	readOnly["scope"] = "contacts:read"
+	response, _ = s.request(http.MethodGet, "/api/delete?id=x", "", nil, bearer(issuer.sign(t, "RS256", "rsa-1", readOnly)))
+	if response.StatusCode != http.StatusForbidden {
+		t.Fatalf("read token deleting answered %v", response.StatusCode)
+	}
+}
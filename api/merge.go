@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// FindDuplicates lists groups of contacts that are likely the same person.
+// "by" picks phone, name or both (the default), "threshold" the name
+// similarity between 0 and 1 needed to group contacts.
+func (h *ContactHandler) FindDuplicates(w http.ResponseWriter, r *http.Request) {
+	keys := r.URL.Query()
+
+	threshold := storage.DefaultNameSimilarity
+	if v := keys.Get("threshold"); v != "" {
+		var err error
+		threshold, err = strconv.ParseFloat(v, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			utils.SendCustomError(w, http.StatusBadRequest, utils.ErrDuplicatesWrongFormat.Error())
+			return
+		}
+	}
+
+	byPhone, byName := true, true
+	if v := keys.Get("by"); v != "" {
+		byPhone, byName = false, false
+		for _, reason := range strings.Split(v, ",") {
+			switch reason {
+			case storage.DuplicateByPhone:
+				byPhone = true
+			case storage.DuplicateByName:
+				byName = true
+			default:
+				utils.SendCustomError(w, http.StatusBadRequest, utils.ErrDuplicatesWrongFormat.Error())
+				return
+			}
+		}
+	}
+
+	h.mu.Lock()
+	contacts, err := h.store(r).List()
+	h.mu.Unlock()
+
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	groups := []storage.DuplicateGroup{}
+	if byPhone {
+		groups = append(groups, storage.DuplicatesByPhone(contacts)...)
+	}
+	if byName {
+		groups = append(groups, storage.DuplicatesByName(contacts, threshold)...)
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("%v groups of possible duplicates", len(groups)), groups)
+}
+
+// MergeContacts combines several contacts into one. The others are moved to
+// the trash and their IDs kept as aliases of the merged contact.
+func (h *ContactHandler) MergeContacts(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var request storage.MergeRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		sendMalformedJSON(w, err)
+		return
+	}
+	if fields := request.Validate(); len(fields) > 0 {
+		sendFieldErrors(w, fields)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var contacts []storage.Contact
+	for _, id := range request.IDs {
+		c, err := h.store(r).Get(id)
+		if err != nil {
+			sendStorageError(w, err, id)
+			return
+		}
+		contacts = append(contacts, c)
+	}
+
+	var before storage.Contact
+	for _, c := range contacts {
+		if c.ID == request.Primary {
+			before = c
+		}
+	}
+
+	merged := request.Merge(contacts)
+	err = h.store(r).Update(merged)
+	if err != nil {
+		sendStorageError(w, err, request.Primary)
+		return
+	}
+	merged, err = h.store(r).Get(request.Primary)
+	if err != nil {
+		sendStorageError(w, err, request.Primary)
+		return
+	}
+	h.record(r, storage.ActionMerge, &before, &merged)
+
+	for _, c := range contacts {
+		if c.ID == request.Primary {
+			continue
+		}
+
+		c := c
+		err = h.store(r).Delete(c.ID)
+		if err != nil && !errors.Is(err, utils.ErrContactNotFound) {
+			sendStorageError(w, err, c.ID)
+			return
+		}
+		trashed, err := h.store(r).GetWithTrash(c.ID)
+		if err == nil {
+			h.record(r, storage.ActionDelete, &c, &trashed)
+		}
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("%v contacts merged into \"%v\"", len(contacts), merged.ID), presentContacts(r, []storage.Contact{merged}))
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Classes of routes, each limited on its own. Enrich routes call the external
+// genderize and nationalize APIs. ClassIP counts every request of an IP
+// address before it is authenticated, so that floods of missing or bad
+// credentials are limited too.
+const (
+	ClassRead   = "read"
+	ClassWrite  = "write"
+	ClassEnrich = "enrich"
+	ClassIP     = "ip"
+)
+
+// bucket holds the tokens of a client, as of when they were last counted
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// RateLimiter lets each client make Limit requests per Window. Clients start
+// with a full bucket of Limit tokens, every request takes one and they come
+// back evenly over Window.
+type RateLimiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+	// now tells the time, time.Now when nil
+	now func() time.Time
+}
+
+// take takes a token of a client. It returns whether there was one, the
+// tokens left, how long until the bucket is full again and, when refused, how
+// long until the next token.
+func (l *RateLimiter) take(client string) (bool, int, time.Duration, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if l.now != nil {
+		now = l.now()
+	}
+	perSecond := float64(l.Limit) / l.Window.Seconds()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	// Buckets idle for a whole window are full, as good as absent
+	if now.Sub(l.sweptAt) > l.Window {
+		for k, v := range l.buckets {
+			if now.Sub(v.at) > l.Window {
+				delete(l.buckets, k)
+			}
+		}
+		l.sweptAt = now
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.Limit), at: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.Limit), b.tokens+now.Sub(b.at).Seconds()*perSecond)
+	b.at = now
+
+	allowed := b.tokens >= 1
+	var retry time.Duration
+	if allowed {
+		b.tokens--
+	} else {
+		retry = time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	reset := time.Duration((float64(l.Limit) - b.tokens) / perSecond * float64(time.Second))
+
+	return allowed, int(b.tokens), reset, retry
+}
+
+// client names who a request counts against: its API key or token subject
+// when authenticated, its IP address otherwise
+func client(r *http.Request) string {
+	if p, ok := principal(r); ok {
+		return "principal " + p.ID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip " + host
+}
+
+// seconds rounds a duration up to whole seconds for headers
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// RateLimit limits the requests of each client to a class of routes with
+// h.RateLimits[class], answering 429 once it is exhausted. Responses tell
+// the limit, the requests left and the seconds until the limit is whole
+// again in RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset, refused
+// ones when to retry in Retry-After. Classes without a limiter are not
+// limited.
+func (h *ContactHandler) RateLimit(class string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			l := h.RateLimits[class]
+			if l == nil || l.Limit <= 0 || l.Window <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed, remaining, reset, retry := l.take(client(r))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(l.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("RateLimit-Reset", seconds(reset))
+			if !allowed {
+				w.Header().Set("Retry-After", seconds(retry))
+				utils.SendCustomError(w, http.StatusTooManyRequests, fmt.Sprintf("%v, please retry in %v seconds", utils.ErrRateLimited, seconds(retry)))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// LimitBody refuses request bodies larger than h.MaxBodySize bytes with 413
+// before anything else reads them. Bodies within the limit are read whole and
+// handed on. A size of 0 allows any body.
+func (h *ContactHandler) LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.MaxBodySize <= 0 || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tooLarge := fmt.Sprintf("%v, please send at most %v bytes", utils.ErrBodyTooLarge, h.MaxBodySize)
+		if r.ContentLength > h.MaxBodySize {
+			utils.SendCustomError(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, h.MaxBodySize+1))
+		r.Body.Close()
+		if err != nil {
+			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if int64(len(body)) > h.MaxBodySize {
+			utils.SendCustomError(w, http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// testClock is a clock tests move by hand
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.t
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	clock := &testClock{t: time.Unix(1000, 0)}
+	l := &RateLimiter{Limit: 3, Window: 3 * time.Second, now: clock.now}
+
+	// A full bucket takes a burst of Limit requests
+	for i := 2; i >= 0; i-- {
+		allowed, remaining, reset, _ := l.take("ann")
+		if !allowed || remaining != i || reset != time.Duration(3-i)*time.Second {
+			t.Fatalf("request %v: allowed %v, %v left, reset in %v", 3-i, allowed, remaining, reset)
+		}
+	}
+	allowed, remaining, reset, retry := l.take("ann")
+	if allowed || remaining != 0 || reset != 3*time.Second || retry != time.Second {
+		t.Fatalf("request 4: allowed %v, %v left, reset in %v, retry in %v", allowed, remaining, reset, retry)
+	}
+
+	// Other clients have buckets of their own
+	if allowed, _, _, _ := l.take("bob"); !allowed {
+		t.Fatal("bob refused because of ann")
+	}
+
+	// Tokens come back evenly, a second each
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	if allowed, _, _, retry := l.take("ann"); allowed || retry != 500*time.Millisecond {
+		t.Fatalf("half a token: allowed %v, retry in %v", allowed, retry)
+	}
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	if allowed, remaining, _, _ := l.take("ann"); !allowed || remaining != 0 {
+		t.Fatalf("one token: allowed %v, %v left", allowed, remaining)
+	}
+
+	// Buckets never hold more than Limit
+	clock.t = clock.t.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if allowed, _, _, _ := l.take("ann"); !allowed {
+			t.Fatalf("request %v after an hour refused", i+1)
+		}
+	}
+	if allowed, _, _, _ := l.take("ann"); allowed {
+		t.Fatal("bucket held more than the limit")
+	}
+}
+
+func TestRateLimiterForgetsIdleClients(t *testing.T) {
+	clock := &testClock{t: time.Unix(1000, 0)}
+	l := &RateLimiter{Limit: 1, Window: time.Second, now: clock.now}
+	l.take("ann")
+	l.take("bob")
+
+	clock.t = clock.t.Add(2 * time.Second)
+	l.take("bob")
+	if _, ok := l.buckets["ann"]; ok || len(l.buckets) != 1 {
+		t.Fatalf("buckets %v after ann was idle", l.buckets)
+	}
+}
+
+func TestRateLimitAnswers429(t *testing.T) {
+	s := newTestServer(t)
+	_, key := s.issueKey("acme", storage.ScopeWrite)
+	s.h.RateLimits = map[string]*RateLimiter{ClassRead: {Limit: 2, Window: time.Minute}, ClassWrite: {Limit: 2, Window: time.Minute}}
+
+	for i := 0; i < 2; i++ {
+		response, _ := s.request(http.MethodGet, "/api/list", key, nil, nil)
+		if response.StatusCode != http.StatusOK || response.Header.Get("RateLimit-Limit") != "2" {
+			t.Fatalf("request %v answered %v with %v", i+1, response.StatusCode, response.Header)
+		}
+	}
+	response, _ := s.request(http.MethodGet, "/api/list", key, nil, nil)
+	if response.StatusCode != http.StatusTooManyRequests || response.Header.Get("Retry-After") != "30" || response.Header.Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("request 3 answered %v with %v", response.StatusCode, response.Header)
+	}
+
+	// Write routes are limited apart
+	response, _ = s.request(http.MethodGet, "/api/delete?id=x", key, nil, nil)
+	if response.StatusCode != http.StatusNotFound || response.Header.Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("delete answered %v with %v", response.StatusCode, response.Header)
+	}
+}
+
+func TestRateLimitByIPBeforeAuthentication(t *testing.T) {
+	s := newTestServer(t)
+	s.h.RateLimits = map[string]*RateLimiter{ClassIP: {Limit: 3, Window: time.Minute}}
+
+	for i := 0; i < 3; i++ {
+		response, _ := s.request(http.MethodGet, "/api/list", "wrong.key", nil, nil)
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("bad key %v answered %v", i+1, response.StatusCode)
+		}
+	}
+	response, _ := s.request(http.MethodGet, "/api/list", "wrong.key", nil, nil)
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("flood of bad keys answered %v", response.StatusCode)
+	}
+	response, _ = s.request("PROPFIND", davBookPath, "wrong.key", nil, nil)
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("flood of bad keys on CardDAV answered %v", response.StatusCode)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	s := newTestServer(t)
+	_, key := s.issueKey("acme", storage.ScopeWrite)
+	s.h.MaxBodySize = 100
+
+	small := map[string]string{"field": "name", "value": "Ann"}
+	if status, res := s.do(http.MethodPost, "/api/filter", key, small); status != http.StatusOK {
+		t.Fatalf("small body answered %v: %v", status, res.Error.Message)
+	}
+
+	large := map[string]string{"name": strings.Repeat("a", 200), "phone": "+1 202 555 0100"}
+	status, res := s.do(http.MethodPost, "/api/add", key, large)
+	if status != http.StatusRequestEntityTooLarge || !strings.Contains(res.Error.Message, "100 bytes") {
+		t.Fatalf("large body answered %v: %v", status, res.Error.Message)
+	}
+
+	// Bodies of unknown length are cut off as they are read
+	request, err := http.NewRequest(http.MethodPost, s.URL+"/api/edit", io.MultiReader(strings.NewReader(`{"name": "`), strings.NewReader(strings.Repeat("a", 200)+`"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.ContentLength = -1
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(APIKeyHeader, key)
+	response, err := s.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("chunked large body answered %v", response.StatusCode)
+	}
+}
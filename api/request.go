@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// ActorHeader names the user making a request, recorded as the author of
+// the changes
+const ActorHeader = "X-Actor"
+
+const anonymousActor = "anonymous"
+
+// actor returns the user a request is made on behalf of: the name of its
+// API key or token user when authenticated, X-Actor otherwise. X-Actor is
+// ignored on authenticated requests so that callers cannot record changes as
+// made by someone else.
+func actor(r *http.Request) string {
+	if p, ok := principal(r); ok {
+		return p.Name
+	}
+	if v := r.Header.Get(ActorHeader); v != "" {
+		return v
+	}
+
+	return anonymousActor
+}
+
+// listOptions reads sort, order, updated_since and created_since from the
+// query string
+func listOptions(r *http.Request) (storage.ListOptions, error) {
+	keys := r.URL.Query()
+	o := storage.ListOptions{
+		Sort:  keys.Get("sort"),
+		Order: keys.Get("order"),
+	}
+
+	var err error
+	if v := keys.Get("updated_since"); v != "" {
+		o.UpdatedSince, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return o, utils.ErrListOptionsWrongFormat
+		}
+	}
+	if v := keys.Get("created_since"); v != "" {
+		o.CreatedSince, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return o, utils.ErrListOptionsWrongFormat
+		}
+	}
+
+	return o, o.Validate()
+}
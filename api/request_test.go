@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+func TestActorIsThePrincipal(t *testing.T) {
+	s := newTestServer(t)
+	k, key := s.issueKey("acme", storage.ScopeWrite)
+
+	body := map[string]string{"name": "Ann Lee", "phone": "+1 202 555 0100", "gender": "female", "country": "US"}
+	response, data := s.request(http.MethodPost, "/api/add", key, body, http.Header{ActorHeader: {"mallory"}})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("adding answered %v: %s", response.StatusCode, data)
+	}
+
+	var page changesPage
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/changes", key, nil).decode(t, &page)
+	if len(page.Changes) != 1 || page.Changes[0].Actor != k.Name {
+		t.Fatalf("changes %+v, want one made by %q", page.Changes, k.Name)
+	}
+}
+
+func TestActorHeaderWithoutAuth(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if actor(r) != anonymousActor {
+		t.Fatalf("actor %q, want %q", actor(r), anonymousActor)
+	}
+	r.Header.Set(ActorHeader, "ann")
+	if actor(r) != "ann" {
+		t.Fatalf("actor %q, want ann", actor(r))
+	}
+}
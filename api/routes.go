@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// Routes returns the router of the service, /api and /dav with the
+// middleware checking who may do what
+func (h *ContactHandler) Routes() http.Handler {
+	// WebDAV methods used by CardDAV clients
+	chi.RegisterMethod("PROPFIND")
+	chi.RegisterMethod("REPORT")
+
+	r := chi.NewRouter()
+	r.Use(h.LimitBody)
+	r.Use(middleware.AllowContentType("application/json", storage.VCardContentType, "text/x-vcard", storage.CSVContentType, "application/xml", "text/xml"))
+	r.Use(middleware.SetHeader("content-type", "application/json"))
+
+	r.Route("/api", func(r chi.Router) {
+		r.Use(h.RateLimit(ClassIP))
+		r.Use(h.Authenticate)
+		r.Use(h.Idempotent)
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.Require(storage.ScopeRead))
+			r.Use(h.RateLimit(ClassRead))
+			r.Get("/list", h.ListContacts)
+			r.Post("/filter", h.Filter)
+			r.Get("/list-favs", h.ListFavorites)
+			r.Get("/tags", h.ListTags)
+			r.Get("/tags/{tag}/contacts", h.ListTagged)
+			r.Get("/trash", h.ListTrash)
+			r.Get("/contacts/{id}", h.GetContact)
+			r.Get("/contacts/{id}/history", h.ContactHistory)
+			r.Get("/duplicates", h.FindDuplicates)
+			r.Get("/export", h.ExportContacts)
+			r.Get("/changes", h.ListChanges)
+			r.Get("/events", h.StreamEvents)
+			r.Get("/events/ws", h.StreamEventsWS)
+			r.Get("/books", h.ListAddressBooks)
+			r.Get("/books/{book}", h.GetAddressBook)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.Require(storage.ScopeWrite))
+			r.Use(h.RateLimit(ClassEnrich))
+			r.With(h.rules().ValidateNewContact).Post("/add", h.AddContact)
+			r.With(h.rules().ValidateExistingContact).Post("/edit", h.EditContact)
+			r.Post("/contacts/{id}/enrich", h.EnrichContact)
+			r.Post("/enrich", h.EnrichContacts)
+			r.Post("/bulk", h.Bulk)
+			r.Post("/import", h.ImportContacts)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.Require(storage.ScopeWrite))
+			r.Use(h.RateLimit(ClassWrite))
+			r.Get("/delete", h.DeleteContact)
+			r.Get("/change-fav", h.ChangeFavorite)
+			r.Post("/contacts/{id}/tags", h.AddTags)
+			r.Delete("/contacts/{id}/tags/{tag}", h.RemoveTag)
+			r.Post("/tags/{tag}/members", h.EditMembers)
+			r.Post("/trash/{id}/restore", h.RestoreContact)
+			r.Delete("/trash/{id}", h.PurgeContact)
+			r.Post("/contacts/{id}/revert", h.RevertContact)
+			r.Post("/contacts/merge", h.MergeContacts)
+			r.Post("/books", h.CreateAddressBook)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.Permit(storage.ScopeWrite, storage.RoleOwner))
+			r.Use(h.RateLimit(ClassWrite))
+			r.Put("/books/{book}/members/{user}", h.SetBookMember)
+			r.Delete("/books/{book}/members/{user}", h.RemoveBookMember)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.Require(storage.ScopeAdmin))
+			r.Use(h.RateLimit(ClassWrite))
+			r.Get("/webhooks", h.ListWebhooks)
+			r.Post("/webhooks", h.CreateWebhook)
+			r.Get("/webhooks/dead-letters", h.ListDeadLetters)
+			r.Post("/webhooks/dead-letters/{id}/redeliver", h.RedeliverDeadLetter)
+			r.Delete("/webhooks/dead-letters/{id}", h.DeleteDeadLetter)
+			r.Get("/webhooks/{id}", h.GetWebhook)
+			r.Put("/webhooks/{id}", h.UpdateWebhook)
+			r.Delete("/webhooks/{id}", h.DeleteWebhook)
+			r.Get("/keys", h.ListAPIKeys)
+			r.Post("/keys", h.CreateAPIKey)
+			r.Post("/keys/{id}/rotate", h.RotateAPIKey)
+			r.Delete("/keys/{id}", h.RevokeAPIKey)
+		})
+	})
+
+	r.HandleFunc("/.well-known/carddav", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/dav/", http.StatusMovedPermanently)
+	})
+	r.Route("/dav", func(r chi.Router) {
+		r.Use(h.RateLimit(ClassIP))
+		r.Options("/*", h.DAVOptions)
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.Authenticate)
+			r.Use(h.Require(storage.ScopeRead))
+			r.Use(h.RateLimit(ClassRead))
+			r.Method("PROPFIND", "/*", http.HandlerFunc(h.DAVPropfind))
+			r.Method("REPORT", "/*", http.HandlerFunc(h.DAVReport))
+			r.Get("/*", h.DAVGetCard)
+			r.Head("/*", h.DAVGetCard)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.Authenticate)
+			r.Use(h.Require(storage.ScopeWrite))
+			r.Use(h.RateLimit(ClassEnrich))
+			r.Put("/*", h.DAVPutCard)
+			r.Delete("/*", h.DAVDeleteCard)
+		})
+	})
+
+	return r
+}
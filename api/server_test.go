@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// testBootstrapKey is the admin key of test servers
+const testBootstrapKey = "test-bootstrap-key"
+
+// testServer runs the routes of a handler with a memory storage and --auth
+type testServer struct {
+	*httptest.Server
+	h *ContactHandler
+	t *testing.T
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	h := &ContactHandler{
+		Storage:          storage.NewMemoryStorage(),
+		RequireAuth:      true,
+		BootstrapKeyHash: storage.HashAPIKey(testBootstrapKey),
+	}
+	s := &testServer{Server: httptest.NewServer(h.Routes()), h: h, t: t}
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// testResponse is the body of a JSON answer of the API
+type testResponse struct {
+	Result string          `json:"result"`
+	Data   json.RawMessage `json:"data"`
+	Error  struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// decode reads the data of a response into v
+func (r testResponse) decode(t *testing.T, v interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(r.Data, v)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", r.Data, err)
+	}
+}
+
+// request sends a request with an API key, and body as it is when a string
+// and as JSON otherwise unless nil, and returns the response with its body
+// read
+func (s *testServer) request(method string, path string, key string, body interface{}, header http.Header) (*http.Response, []byte) {
+	s.t.Helper()
+
+	var reader io.Reader
+	if text, ok := body.(string); ok {
+		reader = strings.NewReader(text)
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	for k, v := range header {
+		request.Header[k] = v
+	}
+	if body != nil && request.Header.Get("Content-Type") == "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		request.Header.Set(APIKeyHeader, key)
+	}
+
+	response, err := s.Client().Do(request)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	return response, data
+}
+
+// do sends a JSON request to the API and returns the status and body of the
+// answer
+func (s *testServer) do(method string, path string, key string, body interface{}) (int, testResponse) {
+	s.t.Helper()
+
+	response, data := s.request(method, path, key, body, nil)
+	var res testResponse
+	err := json.Unmarshal(data, &res)
+	if err != nil {
+		s.t.Fatalf("%v %v answered %v: %s", method, path, response.StatusCode, data)
+	}
+
+	return response.StatusCode, res
+}
+
+// issueKey creates an API key of a tenant with the bootstrap key
+func (s *testServer) issueKey(tenant string, scopes ...string) (storage.APIKey, string) {
+	s.t.Helper()
+
+	status, res := s.do(http.MethodPost, "/api/keys", testBootstrapKey, apiKeyRequest{Name: tenant, Tenant: tenant, Scopes: scopes})
+	if status != http.StatusOK {
+		s.t.Fatalf("issuing key: %v %v", status, res.Error.Message)
+	}
+	var issued issuedAPIKey
+	res.decode(s.t, &issued)
+
+	return issued.APIKey, issued.Key
+}
+
+// addContact adds a contact with its gender and country, so that nothing is
+// looked up on the network
+func (s *testServer) addContact(key string, name string, phone string) storage.Contact {
+	s.t.Helper()
+
+	body := map[string]string{"name": name, "phone": phone, "gender": "female", "country": "US"}
+	status, res := s.do(http.MethodPost, "/api/add", key, body)
+	if status != http.StatusOK {
+		s.t.Fatalf("adding %v: %v %v", name, status, res.Error.Message)
+	}
+	var added []storage.Contact
+	res.decode(s.t, &added)
+
+	return added[0]
+}
+
+// expectStatus fails unless a request answers with status
+func (s *testServer) expectStatus(want int, method string, path string, key string, body interface{}) testResponse {
+	s.t.Helper()
+
+	status, res := s.do(method, path, key, body)
+	if status != want {
+		s.t.Fatalf("%v %v answered %v, want %v: %v", method, path, status, want, res.Error.Message)
+	}
+
+	return res
+}
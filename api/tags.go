@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+type tagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type membersRequest struct {
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+type membersResult struct {
+	Updated  []string `json:"updated"`
+	NotFound []string `json:"not_found"`
+}
+
+// tagParam reads the normalized {tag} URL parameter
+func tagParam(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(chi.URLParam(r, "tag")))
+}
+
+func (h *ContactHandler) AddTags(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var request tagsRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		sendMalformedJSON(w, err)
+		return
+	}
+	tags, err := storage.NormalizeTags(request.Tags)
+	if err != nil {
+		sendFieldErrors(w, []utils.FieldError{{Field: "tags", Code: utils.CodeInvalidValue, Message: err.Error()}})
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, err := h.store(r).Get(id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	before := c
+	c.AddTags(tags)
+	c, err = h.update(r, before, c)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Tags added to contact \"%v\"", id), presentContacts(r, []storage.Contact{c}))
+}
+
+func (h *ContactHandler) RemoveTag(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tag := tagParam(r)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, err := h.store(r).Get(id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	before := c
+	if !c.RemoveTag(tag) {
+		utils.SendCustomError(w, http.StatusNotFound, fmt.Sprintf("contact \"%v\" has no tag \"%v\"", id, tag))
+		return
+	}
+	c, err = h.update(r, before, c)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Tag \"%v\" removed from contact \"%v\"", tag, id), presentContacts(r, []storage.Contact{c}))
+}
+
+func (h *ContactHandler) ListTags(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	tags, err := h.store(r).Tags()
+	h.mu.Unlock()
+
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(w, "All tags with contact counts", tags)
+}
+
+func (h *ContactHandler) ListTagged(w http.ResponseWriter, r *http.Request) {
+	tag := tagParam(r)
+	options, err := listOptions(r)
+	if err != nil {
+		sendStorageError(w, err, "")
+		return
+	}
+
+	h.mu.Lock()
+	contacts, err := h.store(r).Filter("tag", tag)
+	h.mu.Unlock()
+
+	if err != nil {
+		sendStorageError(w, err, "")
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("All contacts tagged \"%v\"", tag), presentContacts(r, options.Apply(contacts)))
+}
+
+// EditMembers adds and removes a tag on many contacts at once. Unknown IDs are
+// reported instead of failing the whole request.
+func (h *ContactHandler) EditMembers(w http.ResponseWriter, r *http.Request) {
+	tag := tagParam(r)
+	if tag == "" {
+		utils.SendCustomError(w, http.StatusBadRequest, utils.ErrTagWrongFormat.Error())
+		return
+	}
+	if _, err := storage.NormalizeTags([]string{tag}); err != nil {
+		sendFieldErrors(w, []utils.FieldError{{Field: "tag", Code: utils.CodeInvalidValue, Message: err.Error()}})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var request membersRequest
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		sendMalformedJSON(w, err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := membersResult{Updated: []string{}, NotFound: []string{}}
+	apply := func(ids []string, change func(c *storage.Contact) bool) error {
+		for _, id := range ids {
+			c, err := h.store(r).Get(id)
+			if errors.Is(err, utils.ErrContactNotFound) {
+				result.NotFound = append(result.NotFound, id)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			before := c
+			if !change(&c) {
+				continue
+			}
+			_, err = h.update(r, before, c)
+			if err != nil {
+				return err
+			}
+			result.Updated = append(result.Updated, id)
+		}
+		return nil
+	}
+
+	err = apply(request.Add, func(c *storage.Contact) bool {
+		if c.HasTag(tag) {
+			return false
+		}
+		c.AddTags([]string{tag})
+		return true
+	})
+	if err == nil {
+		err = apply(request.Remove, func(c *storage.Contact) bool {
+			return c.RemoveTag(tag)
+		})
+	}
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Members of tag \"%v\" updated", tag), result)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// tenant returns the ID of the book a request works on, the address book
+// Permit let it into or else the tenant of its principal
+func tenant(r *http.Request) string {
+	if id, ok := r.Context().Value(bookContextKey{}).(string); ok {
+		return id
+	}
+
+	return user(r)
+}
+
+// user returns who a request is made by, as the tenant of their own book.
+// Address books name their owner and members this way.
+func user(r *http.Request) string {
+	if p, ok := principal(r); ok {
+		return p.Tenant
+	}
+
+	return storage.DefaultTenant
+}
+
+// store returns the book a request works on
+func (h *ContactHandler) store(r *http.Request) storage.StorageInterface {
+	return h.Storage.Tenant(tenant(r))
+}
+
+// tenants lists the tenants having a book, the default one first, for the
+// jobs going through every book. It must be called with h.mu held.
+func (h *ContactHandler) tenants() ([]string, error) {
+	tenants, err := h.Storage.Tenants()
+
+	return append([]string{storage.DefaultTenant}, tenants...), err
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// twoTenants returns a test server and keys of the tenants acme and globex
+func twoTenants(t *testing.T, scopes ...string) (*testServer, string, string) {
+	s := newTestServer(t)
+	_, acme := s.issueKey("acme", scopes...)
+	_, globex := s.issueKey("globex", scopes...)
+
+	return s, acme, globex
+}
+
+func TestTenantsDoNotShareContacts(t *testing.T) {
+	s, acme, globex := twoTenants(t, storage.ScopeWrite)
+	c := s.addContact(acme, "Ann Lee", "+1 202 555 0100")
+
+	var contacts []storage.Contact
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/list", globex, nil).decode(t, &contacts)
+	if len(contacts) != 0 {
+		t.Fatalf("globex lists %v contacts of acme", len(contacts))
+	}
+	s.expectStatus(http.StatusNotFound, http.MethodGet, "/api/contacts/"+c.ID, globex, nil)
+	s.expectStatus(http.StatusNotFound, http.MethodPost, "/api/edit", globex, map[string]string{"id": c.ID, "name": "Mallory"})
+	s.expectStatus(http.StatusNotFound, http.MethodGet, "/api/delete?id="+c.ID, globex, nil)
+
+	var got []storage.Contact
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/contacts/"+c.ID, acme, nil).decode(t, &got)
+	if got[0].Name != "Ann Lee" {
+		t.Fatalf("acme's contact is now named %q", got[0].Name)
+	}
+
+	// Both books may hold the same phone number
+	s.addContact(globex, "Ann Lee", "+1 202 555 0100")
+}
+
+func TestTenantsDoNotShareTrash(t *testing.T) {
+	s, acme, globex := twoTenants(t, storage.ScopeWrite)
+	c := s.addContact(acme, "Ann Lee", "+1 202 555 0100")
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/delete?id="+c.ID, acme, nil)
+
+	var trashed []storage.Contact
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/trash", globex, nil).decode(t, &trashed)
+	if len(trashed) != 0 {
+		t.Fatalf("globex sees %v contacts in the trash of acme", len(trashed))
+	}
+	s.expectStatus(http.StatusNotFound, http.MethodPost, "/api/trash/"+c.ID+"/restore", globex, nil)
+	s.expectStatus(http.StatusNotFound, http.MethodDelete, "/api/trash/"+c.ID, globex, nil)
+
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/trash/"+c.ID+"/restore", acme, nil)
+}
+
+func TestTenantsDoNotShareHistory(t *testing.T) {
+	s, acme, globex := twoTenants(t, storage.ScopeWrite)
+	c := s.addContact(acme, "Ann Lee", "+1 202 555 0100")
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/edit", acme, map[string]string{"id": c.ID, "name": "Ann Smith"})
+
+	s.expectStatus(http.StatusNotFound, http.MethodGet, "/api/contacts/"+c.ID+"/history", globex, nil)
+	s.expectStatus(http.StatusNotFound, http.MethodPost, "/api/contacts/"+c.ID+"/revert", globex, map[string]int{"version": 1})
+
+	var revisions []storage.Revision
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/contacts/"+c.ID+"/history", acme, nil).decode(t, &revisions)
+	if len(revisions) != 2 {
+		t.Fatalf("acme has %v revisions, want 2", len(revisions))
+	}
+}
+
+func TestTenantsDoNotShareChanges(t *testing.T) {
+	s, acme, globex := twoTenants(t, storage.ScopeWrite)
+	s.addContact(acme, "Ann Lee", "+1 202 555 0100")
+
+	var page changesPage
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/changes", globex, nil).decode(t, &page)
+	if len(page.Changes) != 0 {
+		t.Fatalf("globex reads %v changes of acme", len(page.Changes))
+	}
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/changes", acme, nil).decode(t, &page)
+	if len(page.Changes) != 1 {
+		t.Fatalf("acme reads %v changes, want 1", len(page.Changes))
+	}
+}
+
+func TestTenantsDoNotShareEvents(t *testing.T) {
+	s, acme, globex := twoTenants(t, storage.ScopeWrite)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set(APIKeyHeader, globex)
+	response, err := s.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("opening the stream answered %v", response.StatusCode)
+	}
+
+	// The change of acme comes first, globex must only get its own
+	s.addContact(acme, "Ann Lee", "+1 202 555 0100")
+	s.addContact(globex, "Bob Ray", "+1 202 555 0101")
+
+	data := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "data:") {
+				data <- scanner.Text()
+				return
+			}
+		}
+	}()
+
+	select {
+	case line := <-data:
+		if !strings.Contains(line, "Bob Ray") || strings.Contains(line, "Ann Lee") {
+			t.Fatalf("globex got the event %v", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event came")
+	}
+}
+
+func TestTenantsDoNotShareWebhooks(t *testing.T) {
+	var mu sync.Mutex
+	var deliveries []string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		deliveries = append(deliveries, string(body))
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	s, acme, globex := twoTenants(t, storage.ScopeAdmin)
+	var hook storage.Webhook
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/webhooks", acme, webhookRequest{URL: receiver.URL}).decode(t, &hook)
+
+	var hooks []storage.Webhook
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/webhooks", globex, nil).decode(t, &hooks)
+	if len(hooks) != 0 {
+		t.Fatalf("globex lists %v webhooks of acme", len(hooks))
+	}
+	s.expectStatus(http.StatusNotFound, http.MethodGet, "/api/webhooks/"+hook.ID, globex, nil)
+	s.expectStatus(http.StatusNotFound, http.MethodPut, "/api/webhooks/"+hook.ID, globex, webhookRequest{URL: "http://example.com"})
+	s.expectStatus(http.StatusNotFound, http.MethodDelete, "/api/webhooks/"+hook.ID, globex, nil)
+
+	// Only the change of acme is delivered
+	s.addContact(globex, "Bob Ray", "+1 202 555 0101")
+	s.addContact(acme, "Ann Lee", "+1 202 555 0100")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(deliveries)
+		mu.Unlock()
+		if n > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deliveries) != 1 || !strings.Contains(deliveries[0], "Ann Lee") {
+		t.Fatalf("the webhook of acme got %q", deliveries)
+	}
+}
+
+func TestTenantsDoNotShareAPIKeys(t *testing.T) {
+	s, acme, globex := twoTenants(t, storage.ScopeAdmin)
+	globexKey, _ := s.issueKey("globex", storage.ScopeRead)
+
+	var keys []storage.APIKey
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/keys", acme, nil).decode(t, &keys)
+	for _, v := range keys {
+		if v.Tenant != "acme" {
+			t.Fatalf("acme lists a key of %q", v.Tenant)
+		}
+	}
+
+	s.expectStatus(http.StatusForbidden, http.MethodPost, "/api/keys", acme, apiKeyRequest{Name: "x", Tenant: "globex", Scopes: []string{storage.ScopeAdmin}})
+	s.expectStatus(http.StatusNotFound, http.MethodPost, "/api/keys/"+globexKey.ID+"/rotate", acme, nil)
+	s.expectStatus(http.StatusNotFound, http.MethodDelete, "/api/keys/"+globexKey.ID, acme, nil)
+
+	// Keys asked without a tenant are pinned to the issuer's
+	var issued issuedAPIKey
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/keys", acme, apiKeyRequest{Name: "x", Scopes: []string{storage.ScopeRead}}).decode(t, &issued)
+	if issued.Tenant != "acme" {
+		t.Fatalf("acme issued a key of %q", issued.Tenant)
+	}
+
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/keys", globex, nil)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+func (h *ContactHandler) ListTrash(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	trashed, err := h.store(r).ListTrash()
+	h.mu.Unlock()
+
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendSuccessResponse(w, "Full list of deleted contacts", presentContacts(r, trashed))
+}
+
+func (h *ContactHandler) RestoreContact(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	before, err := h.store(r).GetWithTrash(id)
+	if err != nil {
+		sendStorageError(w, utils.ErrNotInTrash, id)
+		return
+	}
+
+	c, err := h.store(r).Restore(id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+	h.record(r, storage.ActionRestore, &before, &c)
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Contact \"%v\" restored", id), presentContacts(r, []storage.Contact{c}))
+}
+
+func (h *ContactHandler) PurgeContact(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	before, err := h.store(r).GetWithTrash(id)
+	if err != nil {
+		sendStorageError(w, utils.ErrNotInTrash, id)
+		return
+	}
+
+	err = h.store(r).Purge(id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+	h.record(r, storage.ActionPurge, &before, nil)
+	h.setPurgedAt(tenant(r))
+
+	utils.SendSuccessResponseNoData(w, fmt.Sprintf("Contact \"%v\" permanently deleted", id))
+}
+
+// PurgeTrash permanently deletes contacts that have been in the trash for
+// longer than retention, in every book
+func (h *ContactHandler) PurgeTrash(retention time.Duration) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tenants, err := h.tenants()
+	total := 0
+	for _, t := range tenants {
+		purged, purgeErr := h.Storage.Tenant(t).PurgeDeleted(time.Now().Add(-retention))
+		if purged > 0 {
+			h.setPurgedAt(t)
+			total += purged
+		}
+		if purgeErr != nil {
+			err = purgeErr
+		}
+	}
+
+	return total, err
+}
+
+// StartTrashPurger runs PurgeTrash every interval until stop is closed
+func (h *ContactHandler) StartTrashPurger(retention time.Duration, interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				purged, err := h.PurgeTrash(retention)
+				if err != nil {
+					log.Printf("Error purging trash: %v", err)
+					continue
+				}
+				if purged > 0 {
+					log.Printf("Purged %v contacts from trash", purged)
+				}
+			}
+		}
+	}()
+}
+
+// setPurgedAt records that contacts were purged from a tenant's book. It must
+// be called with h.mu held.
+func (h *ContactHandler) setPurgedAt(tenant string) {
+	if h.purgedAt == nil {
+		h.purgedAt = make(map[string]time.Time)
+	}
+	h.purgedAt[tenant] = time.Now()
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// contactView is a contact as sent to clients that asked for country names
+type contactView struct {
+	storage.Contact
+	CountryName string `json:"country_name,omitempty"`
+}
+
+// presentContacts prepares contacts for a response. With country_name=true in
+// the query, every contact carries the English name of its country.
+func presentContacts(r *http.Request, contacts []storage.Contact) interface{} {
+	withNames, _ := strconv.ParseBool(r.URL.Query().Get("country_name"))
+	if !withNames {
+		return contacts
+	}
+
+	views := make([]contactView, 0, len(contacts))
+	for _, c := range contacts {
+		views = append(views, contactView{Contact: c, CountryName: storage.CountryName(c.Country)})
+	}
+
+	return views
+}
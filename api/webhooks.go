@@ -0,0 +1,469 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/sgnl-05/contactService/storage"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Headers of webhook deliveries. The signature is "sha256=" and the hex
+// HMAC-SHA256, keyed with the webhook secret, of the timestamp, a dot and the
+// body, so that receivers can refuse replayed deliveries.
+const (
+	WebhookIDHeader        = "X-Webhook-ID"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Defaults of the delivery retries, a failed attempt is retried after the
+// backoff, doubled after every attempt
+const (
+	DefaultWebhookAttempts = 5
+	DefaultWebhookBackoff  = 10 * time.Second
+)
+
+// webhookClient sends the deliveries, receivers must answer within its timeout
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookResponseLimit is how much of a failed delivery's response is kept as
+// its error
+const webhookResponseLimit = 512
+
+// webhookRequest is the body of POST and PUT /api/webhooks. A missing secret
+// is generated on creation and kept on update.
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// newWebhookSecret returns a random secret for a webhook
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+// signWebhook returns the signature of a delivery body sent at a timestamp
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryError is an attempt that did not get a 2xx answer. Status is 0 when
+// no answer came at all.
+type deliveryError struct {
+	Status  int
+	Message string
+}
+
+func (e deliveryError) Error() string {
+	if e.Status == 0 {
+		return e.Message
+	}
+
+	return fmt.Sprintf("receiver answered %v: %v", e.Status, e.Message)
+}
+
+// deliverOnce posts a change to a webhook. The delivery ID is the same for
+// every attempt so that receivers can drop duplicates.
+func deliverOnce(hook storage.Webhook, c storage.Change) error {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return deliveryError{Message: err.Error()}
+	}
+
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return deliveryError{Message: err.Error()}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookIDHeader, fmt.Sprintf("%v-%v", hook.ID, c.Seq))
+	request.Header.Set(WebhookEventHeader, c.Type)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, signWebhook(hook.Secret, timestamp, body))
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return deliveryError{Message: err.Error()}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, webhookResponseLimit))
+		return deliveryError{Status: response.StatusCode, Message: string(message)}
+	}
+
+	return nil
+}
+
+// deliver sends a change to a webhook, retrying with exponential backoff, and
+// files it as a dead letter of the tenant's book once every attempt failed
+func (h *ContactHandler) deliver(tenant string, hook storage.Webhook, c storage.Change) {
+	attempts, backoff := h.WebhookAttempts, h.WebhookBackoff
+	if attempts < 1 {
+		attempts = DefaultWebhookAttempts
+	}
+	if backoff <= 0 {
+		backoff = DefaultWebhookBackoff
+	}
+
+	sleep := h.sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			sleep(backoff)
+			backoff *= 2
+		}
+		err = deliverOnce(hook, c)
+		if err == nil {
+			return
+		}
+	}
+
+	failure, _ := err.(deliveryError)
+	d := storage.DeadLetter{
+		ID:         uuid.New().String(),
+		WebhookID:  hook.ID,
+		Change:     c,
+		Attempts:   attempts,
+		LastStatus: failure.Status,
+		LastError:  failure.Message,
+		FailedAt:   time.Now(),
+	}
+	log.Printf("Webhook %v gave up on change %v: %v", hook.ID, c.Seq, err)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err = h.Storage.Tenant(tenant).SaveDeadLetter(d)
+	if err != nil {
+		log.Printf("Error saving dead letter of webhook %v: %v", hook.ID, err)
+	}
+}
+
+// dispatchWebhooks starts the deliveries of a change to the webhooks of the
+// request's book subscribed to its type. It must be called with h.mu held.
+func (h *ContactHandler) dispatchWebhooks(r *http.Request, c storage.Change) {
+	webhooks, ok := h.webhooks[tenant(r)]
+	if !ok {
+		var err error
+		webhooks, err = h.store(r).ListWebhooks()
+		if err != nil {
+			log.Printf("Error listing webhooks: %v", err)
+			return
+		}
+		if h.webhooks == nil {
+			h.webhooks = make(map[string][]storage.Webhook)
+		}
+		h.webhooks[tenant(r)] = webhooks
+	}
+
+	for _, hook := range webhooks {
+		if hook.Wants(c.Type) {
+			go h.deliver(tenant(r), hook, c)
+		}
+	}
+}
+
+// getWebhook finds a webhook by its ID. It must be called with h.mu held.
+func (h *ContactHandler) getWebhook(r *http.Request, id string) (storage.Webhook, error) {
+	webhooks, err := h.store(r).ListWebhooks()
+	if err != nil {
+		return storage.Webhook{}, err
+	}
+	for _, v := range webhooks {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+
+	return storage.Webhook{}, utils.ErrWebhookNotFound
+}
+
+// getDeadLetter finds a dead letter by its ID. It must be called with h.mu
+// held.
+func (h *ContactHandler) getDeadLetter(r *http.Request, id string) (storage.DeadLetter, error) {
+	deadLetters, err := h.store(r).ListDeadLetters()
+	if err != nil {
+		return storage.DeadLetter{}, err
+	}
+	for _, v := range deadLetters {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+
+	return storage.DeadLetter{}, utils.ErrDeadLetterNotFound
+}
+
+// readWebhookRequest decodes and validates the body of POST and PUT
+// /api/webhooks, answering the request when it fails
+func readWebhookRequest(w http.ResponseWriter, r *http.Request) (webhookRequest, bool) {
+	var request webhookRequest
+
+	body, err := io.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return request, false
+	}
+
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		sendMalformedJSON(w, err)
+		return request, false
+	}
+
+	if request.Events == nil {
+		request.Events = []string{}
+	}
+	fields := storage.Webhook{URL: request.URL, Events: request.Events}.Validate()
+	if len(fields) > 0 {
+		sendFieldErrors(w, fields)
+		return request, false
+	}
+
+	return request, true
+}
+
+// withoutSecret hides the secret of a webhook, which is only shown when the
+// webhook is created
+func withoutSecret(hook storage.Webhook) storage.Webhook {
+	hook.Secret = ""
+
+	return hook
+}
+
+func (h *ContactHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	webhooks, err := h.store(r).ListWebhooks()
+	h.mu.Unlock()
+
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	res := []storage.Webhook{}
+	for _, v := range webhooks {
+		res = append(res, withoutSecret(v))
+	}
+
+	utils.SendSuccessResponse(w, "List of webhooks", res)
+}
+
+// CreateWebhook subscribes a URL to the changes of the events given, or to
+// every change. The response is the only one showing the secret.
+func (h *ContactHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	request, ok := readWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	if request.Secret == "" {
+		request.Secret, err = newWebhookSecret()
+		if err != nil {
+			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	now := time.Now()
+	hook := storage.Webhook{
+		ID:        uuid.New().String(),
+		URL:       request.URL,
+		Events:    request.Events,
+		Secret:    request.Secret,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err = h.store(r).SaveWebhook(hook)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	delete(h.webhooks, tenant(r))
+
+	utils.SendSuccessResponse(w, "Webhook created, keep its secret to check signatures", hook)
+}
+
+func (h *ContactHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	hook, err := h.getWebhook(r, id)
+	h.mu.Unlock()
+
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Webhook \"%v\"", id), withoutSecret(hook))
+}
+
+// UpdateWebhook replaces the URL and events of a webhook, and its secret when
+// one is given
+func (h *ContactHandler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	request, ok := readWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hook, err := h.getWebhook(r, id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	hook.URL, hook.Events = request.URL, request.Events
+	if request.Secret != "" {
+		hook.Secret = request.Secret
+	}
+	hook.UpdatedAt = time.Now()
+
+	err = h.store(r).SaveWebhook(hook)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	delete(h.webhooks, tenant(r))
+
+	utils.SendSuccessResponse(w, fmt.Sprintf("Webhook \"%v\" updated", id), withoutSecret(hook))
+}
+
+// DeleteWebhook unsubscribes a webhook. Deliveries under way still finish,
+// its dead letters are kept until dropped.
+func (h *ContactHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.store(r).DeleteWebhook(id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+	delete(h.webhooks, tenant(r))
+
+	utils.SendSuccessResponseNoData(w, fmt.Sprintf("Webhook \"%v\" deleted", id))
+}
+
+func (h *ContactHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	deadLetters, err := h.store(r).ListDeadLetters()
+	h.mu.Unlock()
+
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deadLetters == nil {
+		deadLetters = []storage.DeadLetter{}
+	}
+
+	utils.SendSuccessResponse(w, "List of failed deliveries", deadLetters)
+}
+
+// RedeliverDeadLetter tries a failed delivery once more, with the current
+// URL and secret of its webhook. It is dropped when the receiver accepts it,
+// or else answered with 502 and kept with the new error.
+func (h *ContactHandler) RedeliverDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	d, err := h.getDeadLetter(r, id)
+	if err != nil {
+		h.mu.Unlock()
+		sendStorageError(w, err, id)
+		return
+	}
+	hook, err := h.getWebhook(r, d.WebhookID)
+	h.mu.Unlock()
+
+	if err != nil {
+		sendStorageError(w, err, d.WebhookID)
+		return
+	}
+
+	deliveryErr := deliverOnce(hook, d.Change)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if deliveryErr == nil {
+		err = h.store(r).DeleteDeadLetter(id)
+		if err != nil {
+			sendStorageError(w, err, id)
+			return
+		}
+		utils.SendSuccessResponseNoData(w, fmt.Sprintf("Change %v redelivered to webhook \"%v\"", d.Change.Seq, hook.ID))
+		return
+	}
+
+	failure, _ := deliveryErr.(deliveryError)
+	d.Attempts++
+	d.LastStatus, d.LastError = failure.Status, failure.Message
+	d.FailedAt = time.Now()
+	err = h.store(r).SaveDeadLetter(d)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SendCustomError(w, http.StatusBadGateway, fmt.Sprintf("redelivery failed: %v", deliveryErr))
+}
+
+func (h *ContactHandler) DeleteDeadLetter(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.store(r).DeleteDeadLetter(id)
+	if err != nil {
+		sendStorageError(w, err, id)
+		return
+	}
+
+	utils.SendSuccessResponseNoData(w, fmt.Sprintf("Dead letter \"%v\" dropped", id))
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sgnl-05/contactService/storage"
+)
+
+// delivery is a request a test receiver got
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// testReceiver records the deliveries it gets and answers them with status
+type testReceiver struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	status     int
+	deliveries []delivery
+	got        chan struct{}
+}
+
+func newTestReceiver(t *testing.T, status int) *testReceiver {
+	rec := &testReceiver{status: status, got: make(chan struct{}, 100)}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.deliveries = append(rec.deliveries, delivery{r.Header.Clone(), body})
+		status := rec.status
+		rec.mu.Unlock()
+		w.WriteHeader(status)
+		rec.got <- struct{}{}
+	}))
+	t.Cleanup(rec.Close)
+
+	return rec
+}
+
+// wait waits for n more deliveries
+func (rec *testReceiver) wait(t *testing.T, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-rec.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v deliveries missing", n-i)
+		}
+	}
+}
+
+// count returns the number of deliveries after giving stray ones some time
+// to arrive
+func (rec *testReceiver) count() int {
+	time.Sleep(100 * time.Millisecond)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return len(rec.deliveries)
+}
+
+func (rec *testReceiver) last() delivery {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.deliveries[len(rec.deliveries)-1]
+}
+
+func TestWebhookDeliveriesAreSigned(t *testing.T) {
+	rec := newTestReceiver(t, http.StatusOK)
+	s := newTestServer(t)
+	_, key := s.issueKey("acme", storage.ScopeAdmin)
+
+	var hook storage.Webhook
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/webhooks", key, webhookRequest{URL: rec.URL, Events: []string{storage.ActionCreate}}).decode(t, &hook)
+	if hook.Secret == "" {
+		t.Fatal("created webhook shows no secret")
+	}
+	s.addContact(key, "Ann Lee", "+1 202 555 0100")
+	rec.wait(t, 1)
+
+	d := rec.last()
+	timestamp := d.header.Get(WebhookTimestampHeader)
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write([]byte(timestamp + "." + string(d.body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := d.header.Get(WebhookSignatureHeader); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Fatalf("signature %q, want %q", got, want)
+	}
+	if d.header.Get(WebhookEventHeader) != storage.ActionCreate || !strings.HasPrefix(d.header.Get(WebhookIDHeader), hook.ID+"-") {
+		t.Fatalf("delivery headers %v", d.header)
+	}
+
+	var c storage.Change
+	err := json.Unmarshal(d.body, &c)
+	if err != nil || c.Contact == nil || c.Contact.Name != "Ann Lee" {
+		t.Fatalf("delivered %s", d.body)
+	}
+
+	// Webhooks are listed without their secret
+	var hooks []storage.Webhook
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/webhooks", key, nil).decode(t, &hooks)
+	if len(hooks) != 1 || hooks[0].Secret != "" {
+		t.Fatalf("listed %+v", hooks)
+	}
+}
+
+func TestWebhookRetriesThenDeadLetters(t *testing.T) {
+	rec := newTestReceiver(t, http.StatusServiceUnavailable)
+	s := newTestServer(t)
+	var mu sync.Mutex
+	var waits []time.Duration
+	s.h.WebhookAttempts = 4
+	s.h.WebhookBackoff = time.Second
+	s.h.sleep = func(d time.Duration) {
+		mu.Lock()
+		waits = append(waits, d)
+		mu.Unlock()
+	}
+	_, key := s.issueKey("acme", storage.ScopeAdmin)
+
+	var hook storage.Webhook
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/webhooks", key, webhookRequest{URL: rec.URL}).decode(t, &hook)
+	s.addContact(key, "Ann Lee", "+1 202 555 0100")
+	rec.wait(t, 4)
+
+	// The dead letter is saved once the last attempt failed
+	var letters []storage.DeadLetter
+	deadline := time.Now().Add(5 * time.Second)
+	for len(letters) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		s.expectStatus(http.StatusOK, http.MethodGet, "/api/webhooks/dead-letters", key, nil).decode(t, &letters)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("%v dead letters, want 1", len(letters))
+	}
+	d := letters[0]
+	if d.WebhookID != hook.ID || d.Attempts != 4 || d.LastStatus != http.StatusServiceUnavailable || d.Change.Type != storage.ActionCreate {
+		t.Fatalf("dead letter %+v", d)
+	}
+	if n := rec.count(); n != 4 {
+		t.Fatalf("%v attempts, want 4", n)
+	}
+
+	mu.Lock()
+	got := waits
+	mu.Unlock()
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	if len(got) != len(want) {
+		t.Fatalf("waited %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("waited %v, want %v", got, want)
+		}
+	}
+
+	// Redelivery succeeds once the receiver is back and drops the letter
+	rec.mu.Lock()
+	rec.status = http.StatusOK
+	rec.mu.Unlock()
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/webhooks/dead-letters/"+d.ID+"/redeliver", key, nil)
+	s.expectStatus(http.StatusOK, http.MethodGet, "/api/webhooks/dead-letters", key, nil).decode(t, &letters)
+	if len(letters) != 0 {
+		t.Fatalf("%v dead letters left after redelivery", len(letters))
+	}
+}
+
+func TestWebhookCacheFollowsChanges(t *testing.T) {
+	first := newTestReceiver(t, http.StatusOK)
+	second := newTestReceiver(t, http.StatusOK)
+	s := newTestServer(t)
+	_, key := s.issueKey("acme", storage.ScopeAdmin)
+
+	// Caches the empty list of webhooks
+	s.addContact(key, "Ann Lee", "+1 202 555 0100")
+
+	var hook storage.Webhook
+	s.expectStatus(http.StatusOK, http.MethodPost, "/api/webhooks", key, webhookRequest{URL: first.URL}).decode(t, &hook)
+	s.addContact(key, "Bob Ray", "+1 202 555 0101")
+	first.wait(t, 1)
+
+	s.expectStatus(http.StatusOK, http.MethodPut, "/api/webhooks/"+hook.ID, key, webhookRequest{URL: second.URL})
+	s.addContact(key, "Cid Roe", "+1 202 555 0102")
+	second.wait(t, 1)
+	if n := first.count(); n != 1 {
+		t.Fatalf("the old URL got %v deliveries, want 1", n)
+	}
+
+	s.expectStatus(http.StatusOK, http.MethodDelete, "/api/webhooks/"+hook.ID, key, nil)
+	s.addContact(key, "Dee Fox", "+1 202 555 0103")
+	if n := second.count(); n != 1 {
+		t.Fatalf("the deleted webhook got %v deliveries, want 1", n)
+	}
+}
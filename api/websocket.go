@@ -0,0 +1,205 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The parts of WebSocket (RFC 6455) the event stream needs: the server side
+// handshake, unfragmented text frames out and control frames both ways.
+
+// wsGUID is appended to Sec-WebSocket-Key to prove the handshake was read
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsWriteTimeout drops clients that stop reading from the connection
+const wsWriteTimeout = 10 * time.Second
+
+// wsMaxPayload bounds the frames read from clients, which only send control
+// frames
+const wsMaxPayload = 4096
+
+// WebSocket opcodes
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// WebSocket close codes
+const (
+	wsClosePolicy = 1008
+	wsCloseError  = 1011
+)
+
+var errWSHandshake = errors.New("expected a WebSocket handshake: GET with Upgrade: websocket and Sec-WebSocket-Version: 13")
+
+type wsConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// mu serializes writes of the pump and of pongs
+	mu sync.Mutex
+}
+
+// headerHas reports whether a comma separated header holds a token
+func headerHas(h http.Header, name string, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkWSHandshake tells whether a request asks for a WebSocket
+func checkWSHandshake(r *http.Request) error {
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		r.Header.Get("Sec-WebSocket-Key") == "" {
+		return errWSHandshake
+	}
+
+	return nil
+}
+
+// upgradeWS answers the handshake checked by checkWSHandshake and takes over
+// the connection
+func upgradeWS(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("the connection cannot be taken over")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + wsGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+// writeFrame sends an unmasked final frame, as servers do
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+
+	err := c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err == nil {
+		_, err = c.conn.Write(append(header, payload...))
+	}
+
+	return err
+}
+
+// close sends a close frame with a status code and reason and shuts the
+// connection
+func (c *wsConn) close(code int, reason string) {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	_ = c.writeFrame(wsClose, append(payload, reason...))
+	c.conn.Close()
+}
+
+// readFrame reads a frame from the client, whose frames must be masked
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(c.reader, header)
+	if err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0F
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(c.reader, extended)
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		_, err = io.ReadFull(c.reader, extended)
+		length = binary.BigEndian.Uint64(extended)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > wsMaxPayload {
+		return 0, nil, errors.New("frame too large")
+	}
+
+	mask := make([]byte, 4)
+	_, err = io.ReadFull(c.reader, mask)
+	if err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return opcode, payload, nil
+}
+
+// readLoop answers pings and returns once the client closes the connection
+// or breaks the protocol. Other frames are ignored.
+func (c *wsConn) readLoop() {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+
+		switch opcode {
+		case wsPing:
+			_ = c.writeFrame(wsPong, payload)
+		case wsClose:
+			// Echo the status code, as the closing handshake asks
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			_ = c.writeFrame(wsClose, payload)
+			return
+		}
+	}
+}
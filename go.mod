@@ -5,12 +5,14 @@ go 1.17
 //github.com/elastic/go-elasticsearch v0.0.0
 require github.com/elastic/go-elasticsearch/v8 v8.0.0-20220214160122-f787d7e7f88e
 
-require github.com/google/uuid v1.3.0
+require (
+	github.com/go-chi/chi/v5 v5.0.7
+	github.com/google/uuid v1.3.0
+	github.com/jessevdk/go-flags v1.5.0
+	github.com/joho/godotenv v1.4.0
+)
 
 require (
 	github.com/elastic/elastic-transport-go/v8 v8.0.0-20211216131617-bbee439d559c // indirect
-	github.com/go-chi/chi/v5 v5.0.7 // indirect
-	github.com/jessevdk/go-flags v1.5.0 // indirect
-	github.com/joho/godotenv v1.4.0 // indirect
 	golang.org/x/sys v0.0.0-20220319134239-a9b59b0215f8 // indirect
 )
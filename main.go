@@ -2,8 +2,6 @@ package main
 
 import (
 	"fmt"
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jessevdk/go-flags"
 	"github.com/joho/godotenv"
 	"github.com/sgnl-05/contactService/api"
@@ -11,25 +9,51 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
 type options struct {
-	StorageType string `short:"d" description:"Data storage type" choice:"memory" choice:"file" choice:"elastic" required:"true"`
+	StorageType       string        `short:"d" description:"Data storage type" choice:"memory" choice:"file" choice:"elastic" required:"true"`
+	ReEnrichOnEdit    bool          `long:"reenrich-on-edit" description:"Guess gender and country again when an edit changes the name"`
+	RulesFile         string        `long:"rules" description:"JSON file with contact validation rules"`
+	TrashRetention    time.Duration `long:"trash-retention" default:"720h" description:"How long deleted contacts stay in the trash, 0 keeps them forever"`
+	IdempotencyWindow time.Duration `long:"idempotency-window" default:"24h" description:"How long responses to requests with an Idempotency-Key are replayed, 0 ignores the header"`
+	UniquePhone       bool          `long:"unique-phone" description:"Reject new contacts with a phone number another contact already has"`
+	ChangesRetention  time.Duration `long:"changes-retention" default:"720h" description:"How long changes stay in the change log, 0 keeps them forever"`
+	WebhookAttempts   int           `long:"webhook-attempts" default:"5" description:"How many times a webhook delivery is tried before it becomes a dead letter"`
+	WebhookBackoff    time.Duration `long:"webhook-backoff" default:"10s" description:"Wait before retrying a webhook delivery, doubled after every attempt"`
+	RequireAuth       bool          `long:"auth" description:"Require an API key on /api and /dav, BOOTSTRAP_API_KEY in config.env is an admin key"`
+	JWKS              string        `long:"jwks" description:"File or URL of the JWKS whose keys sign accepted bearer tokens, with --auth"`
+	JWKSRefresh       time.Duration `long:"jwks-refresh" default:"1h" description:"How often the JWKS is read again"`
+	JWTIssuer         string        `long:"jwt-issuer" description:"Required iss of bearer tokens"`
+	JWTAudience       string        `long:"jwt-audience" description:"Required aud of bearer tokens"`
+	JWTScopeClaim     string        `long:"jwt-scope-claim" default:"scope" description:"Claim listing the scopes of bearer tokens"`
+	JWTScopePrefix    string        `long:"jwt-scope-prefix" description:"Prefix of the scopes in the scope claim, such as contacts:"`
+	JWTUserClaim      string        `long:"jwt-user-claim" default:"sub" description:"Claim naming the user of bearer tokens"`
+	JWTTenantClaim    string        `long:"jwt-tenant-claim" description:"Claim naming the address book of bearer tokens, the subject's own by default"`
+	RateRead          int           `long:"rate-read" default:"300" description:"Requests a client can make to read routes per --rate-window, 0 for no limit"`
+	RateWrite         int           `long:"rate-write" default:"60" description:"Requests a client can make to write routes per --rate-window, 0 for no limit"`
+	RateEnrich        int           `long:"rate-enrich" default:"10" description:"Requests a client can make to routes calling the enrichment APIs per --rate-window, 0 for no limit"`
+	RateIP            int           `long:"rate-ip" default:"600" description:"Requests an IP address can make per --rate-window before authentication, 0 for no limit"`
+	RateWindow        time.Duration `long:"rate-window" default:"1m" description:"Window of the rate limits"`
+	MaxBodySize       int64         `long:"max-body-size" default:"1048576" description:"Largest request body accepted in bytes, 0 for any size"`
 }
 
 func parseFlags(h *api.ContactHandler, o options) {
 	switch o.StorageType {
 	case "memory":
 		fmt.Println("Store in memory")
-		h.Storage = storage.MemoryStorage{
-			ContactBook: make(map[string]*storage.Contact),
-		}
+		s := storage.NewMemoryStorage()
+		s.UniquePhone = o.UniquePhone
+		h.Storage = s
 	case "file":
 		fmt.Println("Store in local file")
-		h.Storage = storage.FileStorage{}
+		h.Storage = storage.FileStorage{UniquePhone: o.UniquePhone}
 	case "elastic":
 		fmt.Println("Store in Elastic")
-		h.Storage = storage.NewElasticStorage()
+		s := storage.NewElasticStorage()
+		s.UniquePhone = o.UniquePhone
+		h.Storage = s
 	default:
 		fmt.Println("Available -d key values: memory|file|elastic")
 		return
@@ -50,21 +74,58 @@ func main() {
 		os.Exit(1)
 	}
 
+	if o.RulesFile != "" {
+		h.Rules, err = storage.LoadRules(o.RulesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	parseFlags(&h, o)
+	h.ReEnrichOnEdit = o.ReEnrichOnEdit
+	h.IdempotencyWindow = o.IdempotencyWindow
+	h.WebhookAttempts = o.WebhookAttempts
+	h.WebhookBackoff = o.WebhookBackoff
+	h.RequireAuth = o.RequireAuth
+	if key := os.Getenv("BOOTSTRAP_API_KEY"); key != "" {
+		h.BootstrapKeyHash = storage.HashAPIKey(key)
+	} else if o.RequireAuth {
+		log.Println("No BOOTSTRAP_API_KEY set, only API keys already stored are accepted")
+	}
+	if o.JWKS != "" {
+		jwks := &api.JWKS{Source: o.JWKS, Refresh: o.JWKSRefresh}
+		err = jwks.Load()
+		if err != nil {
+			log.Fatal(err)
+		}
+		h.JWT = &api.JWTVerifier{
+			JWKS:        jwks,
+			Issuer:      o.JWTIssuer,
+			Audience:    o.JWTAudience,
+			ScopeClaim:  o.JWTScopeClaim,
+			ScopePrefix: o.JWTScopePrefix,
+			UserClaim:   o.JWTUserClaim,
+			TenantClaim: o.JWTTenantClaim,
+		}
+	}
 
-	r := chi.NewRouter()
-	r.Use(middleware.AllowContentType("application/json"))
-	r.Use(middleware.SetHeader("content-type", "application/json"))
+	h.RateLimits = map[string]*api.RateLimiter{
+		api.ClassRead:   {Limit: o.RateRead, Window: o.RateWindow},
+		api.ClassWrite:  {Limit: o.RateWrite, Window: o.RateWindow},
+		api.ClassEnrich: {Limit: o.RateEnrich, Window: o.RateWindow},
+		api.ClassIP:     {Limit: o.RateIP, Window: o.RateWindow},
+	}
+	h.MaxBodySize = o.MaxBodySize
 
-	r.Route("/api", func(r chi.Router) {
-		r.Get("/list", h.ListContacts)
-		r.Get("/delete", h.DeleteContact)
-		r.With(storage.ValidateNewContact).Post("/add", h.AddContact)
-		r.With(storage.ValidateExistingContact).Post("/edit", h.EditContact)
-		r.Post("/filter", h.Filter)
-		r.Get("/list-favs", h.ListFavorites)
-		r.Get("/change-fav", h.ChangeFavorite)
-	})
+	if o.TrashRetention > 0 {
+		h.StartTrashPurger(o.TrashRetention, time.Hour, make(chan struct{}))
+	}
+	if o.IdempotencyWindow > 0 {
+		h.StartIdempotencyExpirer(time.Hour, make(chan struct{}))
+	}
+	if o.ChangesRetention > 0 {
+		h.StartChangeExpirer(o.ChangesRetention, time.Hour, make(chan struct{}))
+	}
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	log.Fatal(http.ListenAndServe(":8080", h.Routes()))
 }
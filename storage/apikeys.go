@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Scopes of API keys. Each scope grants the ones before it: write keys can
+// read and admin keys can do anything.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var scopeRanks = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// IsScope reports whether s is a scope
+func IsScope(s string) bool {
+	return scopeRanks[s] > 0
+}
+
+// GrantsScope reports whether holding the scopes allows what needs scope
+func GrantsScope(scopes []string, scope string) bool {
+	for _, v := range scopes {
+		if scopeRanks[v] >= scopeRanks[scope] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// APIKey is a key clients send to use the API. Only the SHA-256 of the key
+// is stored, the key itself is shown once when created or rotated. Prefix is
+// the start of the key, to tell keys apart. Tenant is the book the key works
+// on.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Tenant    string     `json:"tenant"`
+	Scopes    []string   `json:"scopes"`
+	Hash      string     `json:"hash,omitempty"`
+	Prefix    string     `json:"prefix"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// apiKeyPrefixLength is how much of a key is kept in APIKey.Prefix
+const apiKeyPrefixLength = 12
+
+// HashAPIKey returns the stored form of a key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyID returns the ID a key starts with. Keys are the ID of their APIKey,
+// a dot and a random secret, so that they are looked up without a scan.
+func APIKeyID(key string) string {
+	i := strings.IndexByte(key, '.')
+	if i < 0 {
+		return ""
+	}
+
+	return key[:i]
+}
+
+// NewAPIKey creates a key with a name, book and scopes, returning it along
+// with the key to hand to the client
+func NewAPIKey(name string, tenant string, scopes []string) (APIKey, string, error) {
+	k := APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Tenant:    tenant,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	key, err := k.Rotate()
+	k.RotatedAt = nil
+
+	return k, key, err
+}
+
+// Rotate replaces the secret of a key, the previous key stops working
+func (k *APIKey) Rotate() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+
+	key := k.ID + "." + hex.EncodeToString(secret)
+	now := time.Now()
+	k.Hash = HashAPIKey(key)
+	k.Prefix = key[:len(k.ID)+1+apiKeyPrefixLength]
+	k.RotatedAt = &now
+
+	return key, nil
+}
+
+// Matches reports whether key is this unrevoked API key
+func (k APIKey) Matches(key string) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(k.Hash), []byte(HashAPIKey(key))) == 1
+}
+
+// ValidateAPIKey checks the name, book and scopes of a new key
+func ValidateAPIKey(name string, tenant string, scopes []string) []utils.FieldError {
+	var fields []utils.FieldError
+
+	if strings.TrimSpace(name) == "" {
+		fields = append(fields, utils.FieldError{Field: "name", Code: utils.CodeRequired, Message: "name is required"})
+	}
+	if tenant != DefaultTenant && !tenantPattern.MatchString(tenant) {
+		fields = append(fields, utils.FieldError{Field: "tenant", Code: utils.CodeInvalidFormat, Message: "tenant must be lowercase letters, digits and dashes"})
+	}
+	if len(scopes) == 0 {
+		fields = append(fields, utils.FieldError{Field: "scopes", Code: utils.CodeRequired, Message: "scopes are required"})
+	}
+	for _, v := range scopes {
+		if !IsScope(v) {
+			fields = append(fields, utils.FieldError{Field: "scopes", Code: utils.CodeInvalidValue, Message: "scopes must be read, write or admin"})
+			break
+		}
+	}
+
+	return fields
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Roles of the members of address books. Each role grants the ones before
+// it: editors can read and owners can do anything, including managing the
+// members.
+const (
+	RoleRead  = "read"
+	RoleEdit  = "edit"
+	RoleOwner = "owner"
+)
+
+var roleRanks = map[string]int{RoleRead: 1, RoleEdit: 2, RoleOwner: 3}
+
+// IsMemberRole reports whether r is a role that can be given to members, all
+// of them but owner
+func IsMemberRole(r string) bool {
+	return r == RoleRead || r == RoleEdit
+}
+
+// GrantsRole reports whether holding role allows what needs another
+func GrantsRole(role string, needed string) bool {
+	return role != "" && roleRanks[role] >= roleRanks[needed]
+}
+
+// BookMember gives a user a role in an address book. Users are named by the
+// tenant of their own book.
+type BookMember struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+// AddressBook is a book shared by its owner with members. Its contacts are
+// kept like those of a tenant whose ID is the book's.
+type AddressBook struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Owner     string       `json:"owner"`
+	Members   []BookMember `json:"members"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// NewAddressBook creates a book owned by a user
+func NewAddressBook(name string, owner string) AddressBook {
+	now := time.Now()
+
+	return AddressBook{
+		ID:        uuid.New().String(),
+		Name:      strings.TrimSpace(name),
+		Owner:     owner,
+		Members:   []BookMember{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Role returns the role of a user in the book, empty for strangers
+func (b AddressBook) Role(user string) string {
+	if user == b.Owner {
+		return RoleOwner
+	}
+	for _, v := range b.Members {
+		if v.User == user {
+			return v.Role
+		}
+	}
+
+	return ""
+}
+
+// SetMember gives a user a role, replacing the one they had
+func (b *AddressBook) SetMember(user string, role string) {
+	b.UpdatedAt = time.Now()
+	for i, v := range b.Members {
+		if v.User == user {
+			b.Members[i].Role = role
+			return
+		}
+	}
+	b.Members = append(b.Members, BookMember{User: user, Role: role})
+}
+
+// RemoveMember takes a user out of the book, reporting whether they were in
+func (b *AddressBook) RemoveMember(user string) bool {
+	for i, v := range b.Members {
+		if v.User == user {
+			b.Members = append(b.Members[:i], b.Members[i+1:]...)
+			b.UpdatedAt = time.Now()
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateBookName checks the name of a book
+func ValidateBookName(name string) []utils.FieldError {
+	if strings.TrimSpace(name) == "" {
+		return []utils.FieldError{{Field: "name", Code: utils.CodeRequired, Message: "name is required"}}
+	}
+
+	return nil
+}
+
+// ValidateMember checks a user and role given to a book
+func ValidateMember(user string, role string) []utils.FieldError {
+	var fields []utils.FieldError
+
+	if !tenantPattern.MatchString(user) {
+		fields = append(fields, utils.FieldError{Field: "user", Code: utils.CodeInvalidFormat, Message: "user must be the tenant of the user's own book"})
+	}
+	if !IsMemberRole(role) {
+		fields = append(fields, utils.FieldError{Field: "role", Code: utils.CodeInvalidValue, Message: "role must be read or edit"})
+	}
+
+	return fields
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Bulk operation kinds
+const (
+	BulkCreate = "create"
+	BulkUpdate = "update"
+	BulkDelete = "delete"
+)
+
+// BulkOperation is a single change of a bulk request. Contact is the new
+// contact of a create, Edit the changes of an update and ID the contact to
+// delete. Index is the position of the operation in the request. Rules
+// normalize Edit once the stored contact is known.
+type BulkOperation struct {
+	Index   int
+	Op      string
+	Contact Contact
+	Edit    EditContact
+	Rules   RuleProfile
+	ID      string
+}
+
+// BulkResult is the outcome of a BulkOperation. Err is set when it failed,
+// with the rejected fields in Fields for validation errors. Before and After
+// hold the contact around the change for its revision.
+type BulkResult struct {
+	Index  int
+	Op     string
+	ID     string
+	Err    error
+	Fields []utils.FieldError
+	Before *Contact
+	After  *Contact
+}
+
+// BulkFailed reports whether an operation of a bulk request failed
+func BulkFailed(results []BulkResult) bool {
+	for _, v := range results {
+		if v.Err != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// planBulk works out the contacts resulting from operations without storing
+// anything, so that backends can write them all at once or not at all. get
+// returns a stored contact outside the trash, checkPhones rejects a new
+// contact sharing a number with a stored one.
+func planBulk(ops []BulkOperation, get func(string) (Contact, bool), checkPhones func(Contact) error) []BulkResult {
+	staged := make(map[string]Contact)
+	var created []Contact
+
+	lookup := func(id string) (Contact, bool) {
+		if c, ok := staged[id]; ok {
+			return c, !c.IsDeleted()
+		}
+		return get(id)
+	}
+
+	results := make([]BulkResult, 0, len(ops))
+	for _, op := range ops {
+		res := BulkResult{Index: op.Index, Op: op.Op}
+
+		switch op.Op {
+		case BulkCreate:
+			c := op.Contact
+			res.ID = c.ID
+			c.normalizeLists()
+			if checkPhones != nil {
+				res.Err = sharedPhone(c, created)
+				if res.Err == nil {
+					res.Err = checkPhones(c)
+				}
+			}
+			if res.Err != nil {
+				break
+			}
+			c.touchCreated()
+			staged[c.ID] = c
+			created = append(created, c)
+			res.After = &c
+		case BulkUpdate:
+			res.ID = op.Edit.ID
+			stored, ok := lookup(op.Edit.ID)
+			if !ok {
+				res.Err = utils.ErrContactNotFound
+				break
+			}
+			e := op.Edit
+			res.Fields = e.Normalize(stored.Country, op.Rules)
+			if len(res.Fields) > 0 {
+				res.Err = utils.ErrValidationFailed
+				break
+			}
+			c := *cloneContact(&stored)
+			c.applyEdit(e)
+			c.UpdatedAt = now()
+			staged[c.ID] = c
+			res.Before, res.After = &stored, &c
+		case BulkDelete:
+			res.ID = op.ID
+			stored, ok := lookup(op.ID)
+			if !ok {
+				res.Err = utils.ErrContactNotFound
+				break
+			}
+			c := stored
+			c.trash()
+			staged[c.ID] = c
+			res.Before, res.After = &stored, &c
+		default:
+			res.Err = utils.ErrBulkWrongFormat
+		}
+
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// bulkWrites returns the contacts to store for a plan: none when an atomic
+// plan failed, those of successful operations otherwise. Later operations on
+// the same contact win.
+func bulkWrites(results []BulkResult, atomic bool) []Contact {
+	if atomic && BulkFailed(results) {
+		return nil
+	}
+
+	var res []Contact
+	position := make(map[string]int)
+	for _, v := range results {
+		if v.Err != nil {
+			continue
+		}
+		if i, ok := position[v.After.ID]; ok {
+			res[i] = *v.After
+			continue
+		}
+		position[v.After.ID] = len(res)
+		res = append(res, *v.After)
+	}
+
+	return res
+}
+
+// skipBulk marks the successful operations of a failed atomic plan as not
+// applied
+func skipBulk(results []BulkResult) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = utils.ErrBulkSkipped
+			results[i].Before, results[i].After = nil, nil
+		}
+	}
+}
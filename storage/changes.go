@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"time"
+)
+
+// Change is an entry of the change log, which lists the changes of all
+// contacts in order. Seq is assigned by the storage, one more than the
+// previous entry. Contact holds the contact after the change, or before it
+// for purges.
+type Change struct {
+	Seq       int64     `json:"seq"`
+	Type      string    `json:"type"`
+	ContactID string    `json:"contact_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	Contact   *Contact  `json:"contact,omitempty"`
+}
+
+// changeTypes are the types of changes, the actions of revisions
+var changeTypes = []string{ActionCreate, ActionUpdate, ActionFavorite, ActionDelete, ActionRestore, ActionPurge, ActionRevert, ActionMerge}
+
+// IsChangeType reports whether t is the type of a change
+func IsChangeType(t string) bool {
+	for _, v := range changeTypes {
+		if v == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ChangeLog is the change log of the memory storage. Seq is the sequence
+// number of the latest change.
+type ChangeLog struct {
+	Entries []Change
+	Seq     int64
+}
+
+// NewChange describes the change recorded by a revision. Its type is the
+// action of the revision.
+func NewChange(r Revision) Change {
+	c := Change{
+		Type:      r.Action,
+		ContactID: r.ContactID,
+		Timestamp: r.Timestamp,
+		Actor:     r.Actor,
+		Contact:   cloneContact(r.After),
+	}
+	if c.Contact == nil {
+		c.Contact = cloneContact(r.Before)
+	}
+
+	return c
+}
+
+// changesAfter returns up to limit changes following the sequence number
+// since, from a log sorted by sequence number
+func changesAfter(changes []Change, since int64, limit int) []Change {
+	var res []Change
+	for _, v := range changes {
+		if v.Seq <= since {
+			continue
+		}
+		if len(res) == limit {
+			break
+		}
+		res = append(res, v)
+	}
+
+	return res
+}
+
+// changeBounds returns the sequence numbers of the oldest and latest change
+// of a sorted log, zero for an empty one
+func changeBounds(changes []Change) (int64, int64) {
+	if len(changes) == 0 {
+		return 0, 0
+	}
+
+	return changes[0].Seq, changes[len(changes)-1].Seq
+}
+
+// expireChanges drops changes from before a time. The latest change is kept
+// so that the sequence carries on from it.
+func expireChanges(changes []Change, before time.Time) ([]Change, int) {
+	kept := changes[:0:0]
+	for i, v := range changes {
+		if v.Timestamp.Before(before) && i < len(changes)-1 {
+			continue
+		}
+		kept = append(kept, v)
+	}
+
+	return kept, len(changes) - len(kept)
+}
@@ -0,0 +1,450 @@
+package storage
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Default labels for entries created from single-value fields
+const (
+	LabelMobile = "mobile"
+)
+
+// applyEdit copies all non-empty fields of e into c. Gender and country set
+// this way are marked as entered by the user.
+func (c *Contact) applyEdit(e EditContact) {
+	if e.Name != "" {
+		c.Name = e.Name
+	}
+	if e.Phones != nil {
+		c.Phones = e.Phones
+		c.Phone = ""
+	}
+	if e.Phone != "" {
+		c.setPrimaryPhone(e.Phone)
+	}
+	if e.Emails != nil {
+		c.Emails = e.Emails
+	}
+	if e.Addresses != nil {
+		c.Addresses = e.Addresses
+	}
+	if e.Tags != nil {
+		c.Tags = e.Tags
+	}
+	if e.Gender != "" {
+		c.Gender = e.Gender
+		c.setProvenance("gender", ProvenanceUser)
+	}
+	if e.Country != "" {
+		c.Country = e.Country
+		c.setProvenance("country", ProvenanceUser)
+	}
+
+	c.normalizeLists()
+}
+
+func (c *Contact) setProvenance(field string, source string) {
+	if c.Provenance == nil {
+		c.Provenance = make(map[string]string)
+	}
+	c.Provenance[field] = source
+}
+
+// setPrimaryPhone replaces the number of the primary phone entry
+func (c *Contact) setPrimaryPhone(number string) {
+	c.normalizeLists()
+	for i := range c.Phones {
+		if c.Phones[i].Primary {
+			c.Phones[i].Number = number
+			c.Phone = number
+			return
+		}
+	}
+
+	c.Phones = []PhoneNumber{{Number: number, Label: LabelMobile, Primary: true}}
+	c.Phone = number
+}
+
+// normalizeLists migrates the single-number model into Phones, makes sure
+// every non-empty list has exactly one primary entry and refreshes Phone.
+// Stored contacts pass through it on every read and write.
+func (c *Contact) normalizeLists() {
+	if len(c.Phones) == 0 && c.Phone != "" {
+		c.Phones = []PhoneNumber{{Number: c.Phone, Label: LabelMobile, Primary: true}}
+	}
+
+	primary := -1
+	for i := range c.Phones {
+		if c.Phones[i].Primary && primary == -1 {
+			primary = i
+		}
+		c.Phones[i].Primary = false
+	}
+	if len(c.Phones) > 0 {
+		if primary == -1 {
+			primary = 0
+		}
+		c.Phones[primary].Primary = true
+		c.Phone = c.Phones[primary].Number
+	} else {
+		c.Phone = ""
+	}
+
+	primary = -1
+	for i := range c.Emails {
+		if c.Emails[i].Primary && primary == -1 {
+			primary = i
+		}
+		c.Emails[i].Primary = false
+	}
+	if len(c.Emails) > 0 {
+		if primary == -1 {
+			primary = 0
+		}
+		c.Emails[primary].Primary = true
+	}
+
+	primary = -1
+	for i := range c.Addresses {
+		if c.Addresses[i].Primary && primary == -1 {
+			primary = i
+		}
+		c.Addresses[i].Primary = false
+	}
+	if len(c.Addresses) > 0 {
+		if primary == -1 {
+			primary = 0
+		}
+		c.Addresses[primary].Primary = true
+	}
+}
+
+// normalizeContacts runs normalizeLists over freshly read contacts
+func normalizeContacts(contacts []Contact) []Contact {
+	for i := range contacts {
+		contacts[i].normalizeLists()
+	}
+
+	return contacts
+}
+
+// PhoneNumbers returns every number of the contact, including a legacy Phone
+// not yet migrated into Phones
+func (c Contact) PhoneNumbers() []string {
+	var res []string
+	for _, v := range c.Phones {
+		res = append(res, v.Number)
+	}
+	if len(res) == 0 && c.Phone != "" {
+		res = append(res, c.Phone)
+	}
+
+	return res
+}
+
+// hasPhone reports whether one of the contact's numbers equals number,
+// ignoring formatting
+func (c Contact) hasPhone(number string) bool {
+	digits := phoneDigits(number)
+	for _, v := range c.PhoneNumbers() {
+		if phoneDigits(v) == digits {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sharedPhone finds an active contact other than c with one of its numbers
+func sharedPhone(c Contact, contacts []Contact) error {
+	for _, number := range c.PhoneNumbers() {
+		for _, other := range contacts {
+			if other.ID != c.ID && !other.IsDeleted() && other.hasPhone(number) {
+				return utils.DuplicateError{Field: "phone", Value: number, ExistingID: other.ID}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Normalize brings user input into its stored form as far as the rules of a
+// profile ask for it: country codes to ISO 3166-1 alpha-2, phone numbers to
+// E.164 and e-mail addresses to lower case, each for a field checked with
+// that format. National numbers are read in the numbering plan of the
+// contact's country. Blank phone numbers and e-mail addresses are dropped,
+// Validate has already refused them if the field is required. Every field
+// that cannot be normalized is reported.
+func (c *Contact) Normalize(rules RuleProfile) []utils.FieldError {
+	var fields []utils.FieldError
+
+	if c.Country != "" && rules.formats("country", FormatCountry) {
+		alpha2, err := NormalizeCountry(c.Country)
+		if err != nil {
+			fields = append(fields, utils.FieldError{Field: "country", Code: utils.CodeInvalidValue, Message: err.Error()})
+		} else {
+			c.Country = alpha2
+		}
+	}
+
+	c.normalizeLists()
+	c.Phones, c.Emails = dropBlankEntries(c.Phones, c.Emails)
+	fields = append(fields, normalizeEntries(rules, c.Country, c.Phones, c.Emails, c.Addresses)...)
+	c.normalizeLists()
+
+	tags, err := NormalizeTags(c.Tags)
+	if err != nil {
+		fields = append(fields, utils.FieldError{Field: "tags", Code: utils.CodeInvalidValue, Message: err.Error()})
+	}
+	c.Tags = tags
+
+	return fields
+}
+
+// Normalize works as Contact.Normalize for the fields present in an edit.
+// storedCountry is used for national numbers when the edit keeps the country.
+func (e *EditContact) Normalize(storedCountry string, rules RuleProfile) []utils.FieldError {
+	var fields []utils.FieldError
+
+	if e.Country != "" && rules.formats("country", FormatCountry) {
+		alpha2, err := NormalizeCountry(e.Country)
+		if err != nil {
+			fields = append(fields, utils.FieldError{Field: "country", Code: utils.CodeInvalidValue, Message: err.Error()})
+		} else {
+			e.Country = alpha2
+		}
+	}
+
+	country := e.Country
+	if country == "" {
+		country = storedCountry
+	}
+
+	if e.Phone != "" && rules.formats("phone", FormatPhone) {
+		number, err := NormalizePhone(e.Phone, country)
+		if err != nil {
+			fields = append(fields, PhoneFieldError("phone", err))
+		} else {
+			e.Phone = number
+		}
+	}
+	e.Phones, e.Emails = dropBlankEntries(e.Phones, e.Emails)
+	fields = append(fields, normalizeEntries(rules, country, e.Phones, e.Emails, e.Addresses)...)
+
+	if e.Tags != nil {
+		tags, err := NormalizeTags(e.Tags)
+		if err != nil {
+			fields = append(fields, utils.FieldError{Field: "tags", Code: utils.CodeInvalidValue, Message: err.Error()})
+		}
+		e.Tags = tags
+		if e.Tags == nil {
+			e.Tags = []string{}
+		}
+	}
+
+	return fields
+}
+
+// dropBlankEntries removes the phone numbers and e-mail addresses left empty,
+// keeping nil lists nil
+func dropBlankEntries(phones []PhoneNumber, emails []Email) ([]PhoneNumber, []Email) {
+	keptPhones := phones[:0:0]
+	for _, v := range phones {
+		if strings.TrimSpace(v.Number) != "" {
+			keptPhones = append(keptPhones, v)
+		}
+	}
+
+	keptEmails := emails[:0:0]
+	for _, v := range emails {
+		if strings.TrimSpace(v.Address) != "" {
+			keptEmails = append(keptEmails, v)
+		}
+	}
+
+	return keptPhones, keptEmails
+}
+
+func normalizeEntries(rules RuleProfile, country string, phones []PhoneNumber, emails []Email, addresses []Address) []utils.FieldError {
+	var fields []utils.FieldError
+
+	for i := range phones {
+		if !rules.formats("phone", FormatPhone) {
+			break
+		}
+		number, err := NormalizePhone(phones[i].Number, country)
+		if err != nil {
+			fields = append(fields, PhoneFieldError(fmt.Sprintf("phones[%v].number", i), err))
+			continue
+		}
+		phones[i].Number = number
+	}
+
+	for i := range emails {
+		if !rules.formats("email", FormatEmail) {
+			break
+		}
+		address, err := normalizeEmail(emails[i].Address)
+		if err != nil {
+			fields = append(fields, utils.FieldError{Field: fmt.Sprintf("emails[%v].address", i), Code: utils.CodeInvalidFormat, Message: err.Error()})
+			continue
+		}
+		emails[i].Address = address
+	}
+
+	for i := range addresses {
+		if addresses[i].Country == "" {
+			continue
+		}
+		alpha2, err := NormalizeCountry(addresses[i].Country)
+		if err != nil {
+			fields = append(fields, utils.FieldError{Field: fmt.Sprintf("addresses[%v].country", i), Code: utils.CodeInvalidValue, Message: err.Error()})
+			continue
+		}
+		addresses[i].Country = alpha2
+	}
+
+	return fields
+}
+
+// normalizeEmail accepts a bare e-mail address and lower-cases it
+func normalizeEmail(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil || parsed.Address != strings.TrimSpace(address) {
+		return "", utils.ErrEmailWrongFormat
+	}
+
+	return strings.ToLower(parsed.Address), nil
+}
+
+// matchesFilter reports whether c matches a filter request. Names, e-mails
+// and addresses match on a case-insensitive substring, phone numbers on
+// digits, tags and aliases exactly. Every entry of a list is tried.
+func matchesFilter(c Contact, field string, value string) (bool, error) {
+	switch field {
+	case "name":
+		return containsFold(c.Name, value), nil
+	case "phone":
+		digits := phoneFilterDigits(value)
+		if digits == "" {
+			return false, nil
+		}
+		for _, v := range c.PhoneNumbers() {
+			if strings.Contains(phoneDigits(v), digits) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "email":
+		for _, v := range c.Emails {
+			if containsFold(v.Address, value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "tag":
+		return c.HasTag(strings.ToLower(strings.TrimSpace(value))), nil
+	case "alias":
+		for _, v := range c.Aliases {
+			if v == value {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "address":
+		for _, v := range c.Addresses {
+			for _, part := range []string{v.Street, v.City, v.Region, v.PostalCode, v.Country} {
+				if containsFold(part, value) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	default:
+		return false, utils.ErrFilterWrongFormat
+	}
+}
+
+// MaxTagLength is the longest tag accepted, in characters
+const MaxTagLength = 64
+
+// NormalizeTags trims and lower-cases tags, dropping empty ones and
+// duplicates while keeping their order
+func NormalizeTags(tags []string) ([]string, error) {
+	var res []string
+	seen := make(map[string]bool)
+
+	for _, v := range tags {
+		tag := strings.ToLower(strings.TrimSpace(v))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > MaxTagLength {
+			return res, utils.ErrTagTooLong
+		}
+		seen[tag] = true
+		res = append(res, tag)
+	}
+
+	return res, nil
+}
+
+// HasTag reports whether the contact carries tag
+func (c Contact) HasTag(tag string) bool {
+	for _, v := range c.Tags {
+		if v == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AddTags adds normalized tags the contact does not carry yet
+func (c *Contact) AddTags(tags []string) {
+	for _, v := range tags {
+		if !c.HasTag(v) {
+			c.Tags = append(c.Tags, v)
+		}
+	}
+}
+
+// RemoveTag drops a tag, reporting whether the contact carried it
+func (c *Contact) RemoveTag(tag string) bool {
+	for i, v := range c.Tags {
+		if v == tag {
+			c.Tags = append(c.Tags[:i:i], c.Tags[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// countTags builds the tag list with counts over contacts, sorted by tag
+func countTags(contacts []Contact) []TagCount {
+	counts := make(map[string]int)
+	for _, c := range contacts {
+		for _, v := range c.Tags {
+			counts[v]++
+		}
+	}
+
+	res := make([]TagCount, 0, len(counts))
+	for k, v := range counts {
+		res = append(res, TagCount{Tag: k, Count: v})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Tag < res[j].Tag })
+
+	return res
+}
+
+func containsFold(s string, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,533 @@
+package storage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// CSVContentType is the media type of CSV (RFC 4180)
+const CSVContentType = "text/csv"
+
+// CSVMapping maps CSV headers onto contact fields. Targets are:
+//
+//   - name, or name.given, name.middle and name.family joined when name is
+//     empty
+//   - gender, country, favorite, tags (split at ";", "," and " ::: ")
+//   - phones.number, phones.label, emails.address, emails.label and
+//     addresses.street|city|region|postal_code|country|label, one entry per
+//     line of the cell; phone and email stand for phones.number and
+//     emails.address
+//   - phones[1].number and so on for numbered columns as in the Google and
+//     Outlook layouts; several values in one cell are separated by " ::: "
+//   - custom.KEY for custom properties
+//   - id, created_at, updated_at and created_by, which are set by the server
+//     and skipped
+type CSVMapping map[string]string
+
+// CSVPreset is the mapping of a known spreadsheet layout. Labels gives the
+// fixed label of numbered entries, such as "phones[0]" for Outlook's
+// "Mobile Phone".
+type CSVPreset struct {
+	Columns CSVMapping
+	Labels  map[string]string
+}
+
+// csvListSeparator separates several values in one cell, as Google does
+const csvListSeparator = " ::: "
+
+var csvTargetRegexp = regexp.MustCompile(`^(phones|emails|addresses)(\[(\d+)\])?\.([a-z_]+)$`)
+
+var csvEntryParts = map[string][]string{
+	"phones":    {"number", "label"},
+	"emails":    {"address", "label"},
+	"addresses": {"street", "city", "region", "postal_code", "country", "label"},
+}
+
+var csvFieldTargets = map[string]bool{
+	"name": true, "name.given": true, "name.middle": true, "name.family": true,
+	"gender": true, "country": true, "favorite": true, "tags": true,
+	"id": true, "created_at": true, "updated_at": true, "created_by": true,
+}
+
+var csvTargetAliases = map[string]string{
+	"phone": "phones.number",
+	"email": "emails.address",
+}
+
+// csvColumns are the columns written by CSVEncoder, followed by a custom.KEY
+// column per custom field. Read with the default mapping, an export is
+// imported back as it was.
+var csvColumns = []string{
+	"id", "name", "phones.number", "phones.label", "emails.address", "emails.label",
+	"addresses.street", "addresses.city", "addresses.region", "addresses.postal_code", "addresses.country", "addresses.label",
+	"gender", "country", "favorite", "tags", "created_at", "updated_at",
+}
+
+// CSVPresets holds the layouts of Google Contacts and Outlook exports
+var CSVPresets = map[string]CSVPreset{
+	"google":  googlePreset(),
+	"outlook": outlookPreset(),
+}
+
+func googlePreset() CSVPreset {
+	columns := CSVMapping{
+		"Name":             "name",
+		"Given Name":       "name.given",
+		"Additional Name":  "name.middle",
+		"Family Name":      "name.family",
+		"First Name":       "name.given",
+		"Middle Name":      "name.middle",
+		"Last Name":        "name.family",
+		"Gender":           "gender",
+		"Group Membership": "tags",
+		"Labels":           "tags",
+	}
+	for i := 1; i <= 5; i++ {
+		// Older exports call the label "Type"
+		for _, kind := range []string{"Type", "Label"} {
+			columns[fmt.Sprintf("Phone %v - %v", i, kind)] = fmt.Sprintf("phones[%v].label", i-1)
+			columns[fmt.Sprintf("E-mail %v - %v", i, kind)] = fmt.Sprintf("emails[%v].label", i-1)
+			columns[fmt.Sprintf("Address %v - %v", i, kind)] = fmt.Sprintf("addresses[%v].label", i-1)
+		}
+		columns[fmt.Sprintf("Phone %v - Value", i)] = fmt.Sprintf("phones[%v].number", i-1)
+		columns[fmt.Sprintf("E-mail %v - Value", i)] = fmt.Sprintf("emails[%v].address", i-1)
+		columns[fmt.Sprintf("Address %v - Street", i)] = fmt.Sprintf("addresses[%v].street", i-1)
+		columns[fmt.Sprintf("Address %v - City", i)] = fmt.Sprintf("addresses[%v].city", i-1)
+		columns[fmt.Sprintf("Address %v - Region", i)] = fmt.Sprintf("addresses[%v].region", i-1)
+		columns[fmt.Sprintf("Address %v - Postal Code", i)] = fmt.Sprintf("addresses[%v].postal_code", i-1)
+		columns[fmt.Sprintf("Address %v - Country", i)] = fmt.Sprintf("addresses[%v].country", i-1)
+	}
+
+	return CSVPreset{Columns: columns}
+}
+
+func outlookPreset() CSVPreset {
+	columns := CSVMapping{
+		"First Name":       "name.given",
+		"Middle Name":      "name.middle",
+		"Last Name":        "name.family",
+		"Gender":           "gender",
+		"Categories":       "tags",
+		"E-mail Address":   "emails[0].address",
+		"E-mail 2 Address": "emails[1].address",
+		"E-mail 3 Address": "emails[2].address",
+	}
+	labels := make(map[string]string)
+
+	phones := []struct{ column, label string }{
+		{"Primary Phone", ""},
+		{"Mobile Phone", LabelMobile},
+		{"Business Phone", "work"},
+		{"Business Phone 2", "work"},
+		{"Home Phone", "home"},
+		{"Home Phone 2", "home"},
+		{"Other Phone", "other"},
+	}
+	for i, v := range phones {
+		columns[v.column] = fmt.Sprintf("phones[%v].number", i)
+		if v.label != "" {
+			labels[fmt.Sprintf("phones[%v]", i)] = v.label
+		}
+	}
+
+	for i, kind := range []string{"Home", "Business", "Other"} {
+		columns[kind+" Street"] = fmt.Sprintf("addresses[%v].street", i)
+		columns[kind+" City"] = fmt.Sprintf("addresses[%v].city", i)
+		columns[kind+" State"] = fmt.Sprintf("addresses[%v].region", i)
+		columns[kind+" Postal Code"] = fmt.Sprintf("addresses[%v].postal_code", i)
+		columns[kind+" Country/Region"] = fmt.Sprintf("addresses[%v].country", i)
+		labels[fmt.Sprintf("addresses[%v]", i)] = map[string]string{"Home": "home", "Business": "work", "Other": "other"}[kind]
+	}
+
+	return CSVPreset{Columns: columns, Labels: labels}
+}
+
+// ParseCSVMapping reads a mapping given as a JSON object of header to target
+// and checks its targets
+func ParseCSVMapping(value string) (CSVMapping, error) {
+	var mapping CSVMapping
+	err := json.Unmarshal([]byte(value), &mapping)
+	if err != nil {
+		return nil, utils.ErrCSVMappingWrongFormat
+	}
+
+	for header, target := range mapping {
+		if !validCSVTarget(target) {
+			return nil, fmt.Errorf("%w: unknown target %q for column %q", utils.ErrCSVMappingWrongFormat, target, header)
+		}
+	}
+
+	return mapping, nil
+}
+
+func validCSVTarget(target string) bool {
+	if alias, ok := csvTargetAliases[target]; ok {
+		target = alias
+	}
+	if csvFieldTargets[target] || strings.HasPrefix(target, "custom.") && len(target) > len("custom.") {
+		return true
+	}
+
+	match := csvTargetRegexp.FindStringSubmatch(target)
+	if match == nil {
+		return false
+	}
+	for _, v := range csvEntryParts[match[1]] {
+		if v == match[4] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CSVRow is a decoded data row: the contact or the reason it could not be
+// read
+type CSVRow struct {
+	Contact Contact
+	Err     error
+}
+
+// DecodeCSV reads the rows of a CSV file with a header row. Columns are
+// mapped through mapping, or taken as targets themselves when it is nil;
+// unmapped columns are skipped. labels are the fixed labels of a preset.
+func DecodeCSV(r io.Reader, mapping CSVMapping, labels map[string]string) ([]CSVRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(header) > 0 {
+		// Spreadsheet programs like to start with a byte order mark
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	targets := make([]string, len(header))
+	for i, v := range header {
+		target := v
+		if mapping != nil {
+			target = mapping[v]
+		}
+		if alias, ok := csvTargetAliases[target]; ok {
+			target = alias
+		}
+		if validCSVTarget(target) {
+			targets[i] = target
+		}
+	}
+
+	var rows []CSVRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, CSVRow{Err: err})
+			continue
+		}
+		if err != nil {
+			return rows, err
+		}
+
+		c, err := contactFromCSV(targets, record, labels)
+		rows = append(rows, CSVRow{Contact: c, Err: err})
+	}
+
+	return rows, nil
+}
+
+// csvEntry holds the parts of one phone, e-mail or address
+type csvEntry map[string]string
+
+// contactFromCSV maps the cells of a row onto a contact. Values are taken as
+// they are, the usual normalization and validation applies afterwards.
+func contactFromCSV(targets []string, record []string, labels map[string]string) (Contact, error) {
+	var c Contact
+	var given, middle, family string
+	// Numbered entries by list and index, then entries of list columns
+	slots := make(map[string]map[int]map[string][]string)
+	lists := make(map[string]map[string][]string)
+
+	for i, target := range targets {
+		if target == "" || i >= len(record) {
+			continue
+		}
+		value := strings.TrimSpace(record[i])
+		if value == "" {
+			continue
+		}
+
+		switch target {
+		case "name":
+			c.Name = value
+		case "name.given":
+			given = value
+		case "name.middle":
+			middle = value
+		case "name.family":
+			family = value
+		case "gender":
+			c.Gender = strings.ToLower(value)
+			if c.Gender == "unspecified" {
+				c.Gender = ""
+			}
+		case "country":
+			c.Country = value
+		case "favorite":
+			favorite, err := strconv.ParseBool(value)
+			if err != nil {
+				return c, fmt.Errorf("favorite must be true or false, not %q", value)
+			}
+			c.Favorite = favorite
+		case "tags":
+			c.Tags = append(c.Tags, splitCSVTags(value)...)
+		case "id", "created_at", "updated_at", "created_by":
+		default:
+			if strings.HasPrefix(target, "custom.") {
+				if c.Custom == nil {
+					c.Custom = make(map[string]string)
+				}
+				c.Custom[strings.TrimPrefix(target, "custom.")] = value
+				continue
+			}
+
+			match := csvTargetRegexp.FindStringSubmatch(target)
+			list, part := match[1], match[4]
+			if match[2] == "" {
+				if lists[list] == nil {
+					lists[list] = make(map[string][]string)
+				}
+				lists[list][part] = strings.Split(strings.ReplaceAll(value, "\r\n", "\n"), "\n")
+				continue
+			}
+			index, _ := strconv.Atoi(match[3])
+			if slots[list] == nil {
+				slots[list] = make(map[int]map[string][]string)
+			}
+			if slots[list][index] == nil {
+				slots[list][index] = make(map[string][]string)
+			}
+			slots[list][index][part] = strings.Split(value, csvListSeparator)
+		}
+	}
+	if c.Name == "" {
+		c.Name = strings.Join(nonEmpty(given, middle, family), " ")
+	}
+
+	for _, list := range []string{"phones", "emails", "addresses"} {
+		var entries []csvEntry
+
+		var indexes []int
+		for i := range slots[list] {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		for _, i := range indexes {
+			slotEntries := csvEntries(slots[list][i])
+			if label, ok := labels[fmt.Sprintf("%v[%v]", list, i)]; ok {
+				for _, e := range slotEntries {
+					if e["label"] == "" {
+						e["label"] = label
+					}
+				}
+			}
+			entries = append(entries, slotEntries...)
+		}
+		entries = append(entries, csvEntries(lists[list])...)
+
+		for _, e := range entries {
+			label, primary := csvLabel(e["label"])
+			switch list {
+			case "phones":
+				if e["number"] != "" {
+					c.Phones = append(c.Phones, PhoneNumber{Number: e["number"], Label: label, Primary: primary})
+				}
+			case "emails":
+				if e["address"] != "" {
+					c.Emails = append(c.Emails, Email{Address: e["address"], Label: label, Primary: primary})
+				}
+			case "addresses":
+				a := Address{Street: e["street"], City: e["city"], Region: e["region"], PostalCode: e["postal_code"], Country: e["country"], Label: label, Primary: primary}
+				if a.Street != "" || a.City != "" || a.Region != "" || a.PostalCode != "" || a.Country != "" {
+					c.Addresses = append(c.Addresses, a)
+				}
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// csvEntries lines up the values of the parts of a list: the n-th value of
+// every part makes the n-th entry. A single label applies to all entries.
+func csvEntries(parts map[string][]string) []csvEntry {
+	count := 0
+	for _, v := range parts {
+		if len(v) > count {
+			count = len(v)
+		}
+	}
+
+	res := make([]csvEntry, count)
+	for i := range res {
+		res[i] = make(csvEntry)
+		for part, values := range parts {
+			switch {
+			case i < len(values):
+				res[i][part] = strings.TrimSpace(values[i])
+			case part == "label" && len(values) == 1:
+				res[i][part] = strings.TrimSpace(values[0])
+			}
+		}
+	}
+
+	return res
+}
+
+// csvLabel lower-cases a label. Google marks the primary entry with "* ".
+func csvLabel(label string) (string, bool) {
+	primary := strings.HasPrefix(label, "* ")
+	label = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(label, "* ")))
+	if label == "mobile phone" || label == "cell" {
+		label = LabelMobile
+	}
+
+	return label, primary
+}
+
+// splitCSVTags splits a cell of tags. Google system groups such as
+// "* myContacts" are dropped.
+func splitCSVTags(value string) []string {
+	var res []string
+	value = strings.ReplaceAll(value, csvListSeparator, ";")
+	for _, v := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ',' }) {
+		v = strings.TrimSpace(v)
+		if v != "" && !strings.HasPrefix(v, "*") {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// CSVEncoder writes contacts as CSV rows, one at a time
+type CSVEncoder struct {
+	writer *csv.Writer
+	custom []string
+}
+
+// NewCSVEncoder writes the header row, with a column for each of the custom
+// fields in custom
+func NewCSVEncoder(w io.Writer, custom []string) (*CSVEncoder, error) {
+	e := &CSVEncoder{writer: csv.NewWriter(w), custom: append([]string(nil), custom...)}
+	sort.Strings(e.custom)
+
+	header := append([]string(nil), csvColumns...)
+	for _, k := range e.custom {
+		header = append(header, "custom."+k)
+	}
+
+	return e, e.writer.Write(header)
+}
+
+// CustomKeys adds the keys of the custom fields of c to keys
+func CustomKeys(keys map[string]bool, c Contact) {
+	for k := range c.Custom {
+		keys[k] = true
+	}
+}
+
+// Encode writes a contact. Phones, e-mails and addresses take a line of their
+// cells per entry, the primary one first.
+func (e *CSVEncoder) Encode(c Contact) error {
+	c.normalizeLists()
+
+	cells := map[string][]string{}
+	add := func(column string, value string) {
+		cells[column] = append(cells[column], value)
+	}
+	for _, v := range primaryFirst(len(c.Phones), func(i int) bool { return c.Phones[i].Primary }) {
+		add("phones.number", c.Phones[v].Number)
+		add("phones.label", c.Phones[v].Label)
+	}
+	for _, v := range primaryFirst(len(c.Emails), func(i int) bool { return c.Emails[i].Primary }) {
+		add("emails.address", c.Emails[v].Address)
+		add("emails.label", c.Emails[v].Label)
+	}
+	for _, v := range primaryFirst(len(c.Addresses), func(i int) bool { return c.Addresses[i].Primary }) {
+		a := c.Addresses[v]
+		add("addresses.street", a.Street)
+		add("addresses.city", a.City)
+		add("addresses.region", a.Region)
+		add("addresses.postal_code", a.PostalCode)
+		add("addresses.country", a.Country)
+		add("addresses.label", a.Label)
+	}
+
+	record := make([]string, len(csvColumns))
+	for i, column := range csvColumns {
+		switch column {
+		case "id":
+			record[i] = c.ID
+		case "name":
+			record[i] = c.Name
+		case "gender":
+			record[i] = c.Gender
+		case "country":
+			record[i] = c.Country
+		case "favorite":
+			record[i] = strconv.FormatBool(c.Favorite)
+		case "tags":
+			record[i] = strings.Join(c.Tags, ";")
+		case "created_at":
+			record[i] = c.CreatedAt.Format(time.RFC3339)
+		case "updated_at":
+			record[i] = c.UpdatedAt.Format(time.RFC3339)
+		default:
+			record[i] = strings.Join(cells[column], "\n")
+		}
+	}
+	for _, k := range e.custom {
+		record = append(record, c.Custom[k])
+	}
+
+	return e.writer.Write(record)
+}
+
+// Flush writes buffered rows out
+func (e *CSVEncoder) Flush() error {
+	e.writer.Flush()
+
+	return e.writer.Error()
+}
+
+// primaryFirst returns the indexes of a list with the primary entry first
+func primaryFirst(n int, primary func(int) bool) []int {
+	res := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if primary(i) {
+			res = append([]int{i}, res...)
+		} else {
+			res = append(res, i)
+		}
+	}
+
+	return res
+}
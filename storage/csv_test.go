@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeCSVContacts(t *testing.T, data string, mapping CSVMapping, labels map[string]string) []Contact {
+	rows, err := DecodeCSV(strings.NewReader(data), mapping, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var contacts []Contact
+	for i, v := range rows {
+		if v.Err != nil {
+			t.Fatalf("row %v: %v", i, v.Err)
+		}
+		contacts = append(contacts, v.Contact)
+	}
+
+	return contacts
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	want := []Contact{exchangeFixture(), {ID: "bob", Name: "Bob Ray", Phone: "+12025550101", Custom: map[string]string{"room": "7"}}}
+
+	keys := make(map[string]bool)
+	for _, c := range want {
+		CustomKeys(keys, c)
+	}
+	if !reflect.DeepEqual(keys, map[string]bool{"department": true, "room": true}) {
+		t.Fatalf("custom keys %v", keys)
+	}
+	var custom []string
+	for k := range keys {
+		custom = append(custom, k)
+	}
+
+	var b strings.Builder
+	e, err := NewCSVEncoder(&b, custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range want {
+		err = e.Encode(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := strings.SplitN(b.String(), "\n", 2)[0]
+	if !strings.HasSuffix(header, ",custom.department,custom.room") {
+		t.Fatalf("header %q, want sorted custom columns", header)
+	}
+
+	got := decodeCSVContacts(t, b.String(), nil, nil)
+	if len(got) != len(want) {
+		t.Fatalf("decoded %v contacts, want %v", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(exchanged(got[i]), exchanged(want[i])) {
+			t.Errorf("round trip gave\n%+v\nwant\n%+v", exchanged(got[i]), exchanged(want[i]))
+		}
+	}
+}
+
+func TestDecodeCSVGooglePreset(t *testing.T) {
+	data := strings.Join([]string{
+		"Name,Given Name,Family Name,Gender,Group Membership,E-mail 1 - Type,E-mail 1 - Value," +
+			"Phone 1 - Type,Phone 1 - Value,Phone 2 - Label,Phone 2 - Value,Address 1 - Type,Address 1 - Street,Address 1 - City,Address 1 - Country,Notes",
+		",Ann,Lee,Female,* myContacts ::: Friends,* Home ::: Work,ann@example.com ::: ann@work.example," +
+			"Mobile,+7 916 123-45-67,Work,+1 202 555 0100,Home,1 Main St,Springfield,US,not imported",
+		"Bob Ray,Bob,Ray,Unspecified,,,,,,,,,,,,",
+	}, "\n")
+	preset := CSVPresets["google"]
+
+	got := decodeCSVContacts(t, data, preset.Columns, preset.Labels)
+	want := []Contact{
+		{
+			Name: "Ann Lee", Gender: "female", Tags: []string{"Friends"},
+			Phones:    []PhoneNumber{{Number: "+7 916 123-45-67", Label: LabelMobile}, {Number: "+1 202 555 0100", Label: "work"}},
+			Emails:    []Email{{Address: "ann@example.com", Label: "home", Primary: true}, {Address: "ann@work.example", Label: "work"}},
+			Addresses: []Address{{Street: "1 Main St", City: "Springfield", Country: "US", Label: "home"}},
+		},
+		{Name: "Bob Ray"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDecodeCSVOutlookPreset(t *testing.T) {
+	data := "\ufeffFirst Name,Middle Name,Last Name,E-mail Address,Mobile Phone,Business Phone,Home Street,Home City,Business City,Categories\n" +
+		"Ann,Marie,Lee,ann@example.com,+7 916 123-45-67,+1 202 555 0100,1 Main St,Springfield,Boston,Friends;Work\n"
+	preset := CSVPresets["outlook"]
+
+	got := decodeCSVContacts(t, data, preset.Columns, preset.Labels)
+	want := []Contact{{
+		Name:      "Ann Marie Lee",
+		Phones:    []PhoneNumber{{Number: "+7 916 123-45-67", Label: LabelMobile}, {Number: "+1 202 555 0100", Label: "work"}},
+		Emails:    []Email{{Address: "ann@example.com"}},
+		Addresses: []Address{{Street: "1 Main St", City: "Springfield", Label: "home"}, {City: "Boston", Label: "work"}},
+		Tags:      []string{"Friends", "Work"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDecodeCSVReportsBadRows(t *testing.T) {
+	rows, err := DecodeCSV(strings.NewReader("name,favorite,custom.room\nAnn Lee,yes,1\nBob Ray,true,2\n"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Err == nil || rows[1].Err != nil {
+		t.Fatalf("rows %+v, want the first one rejected", rows)
+	}
+	if !rows[1].Contact.Favorite || rows[1].Contact.Custom["room"] != "2" {
+		t.Fatalf("decoded %+v", rows[1].Contact)
+	}
+}
+
+func TestParseCSVMapping(t *testing.T) {
+	mapping, err := ParseCSVMapping(`{"Mobile": "phone", "Work": "phones[1].number", "Desk": "custom.desk"}`)
+	if err != nil || len(mapping) != 3 {
+		t.Fatalf("ParseCSVMapping = %v, %v", mapping, err)
+	}
+
+	for _, value := range []string{`{"Fax": "phones.fax"}`, `{"Desk": "custom."}`, `{"Pager": "pager"}`, `["name"]`} {
+		if _, err := ParseCSVMapping(value); err == nil {
+			t.Errorf("ParseCSVMapping(%v) accepted", value)
+		}
+	}
+}
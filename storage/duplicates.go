@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Reasons contacts are grouped as duplicates
+const (
+	DuplicateByPhone = "phone"
+	DuplicateByName  = "name"
+)
+
+// DefaultNameSimilarity is the similarity two names need to be considered
+// the same person
+const DefaultNameSimilarity = 0.85
+
+// DuplicateGroup lists contacts likely to be the same person. Key is the
+// shared phone number or the name of the first contact.
+type DuplicateGroup struct {
+	Reason   string    `json:"reason"`
+	Key      string    `json:"key"`
+	Contacts []Contact `json:"contacts"`
+}
+
+// DuplicatesByPhone groups contacts sharing a phone number
+func DuplicatesByPhone(contacts []Contact) []DuplicateGroup {
+	byNumber := make(map[string][]Contact)
+	var numbers []string
+
+	for _, c := range contacts {
+		seen := make(map[string]bool)
+		for _, v := range c.PhoneNumbers() {
+			number := phoneDigits(v)
+			if number == "" || seen[number] {
+				continue
+			}
+			seen[number] = true
+			if _, ok := byNumber[number]; !ok {
+				numbers = append(numbers, number)
+			}
+			byNumber[number] = append(byNumber[number], c)
+		}
+	}
+	sort.Strings(numbers)
+
+	var res []DuplicateGroup
+	for _, v := range numbers {
+		if len(byNumber[v]) > 1 {
+			res = append(res, DuplicateGroup{Reason: DuplicateByPhone, Key: "+" + v, Contacts: byNumber[v]})
+		}
+	}
+
+	return res
+}
+
+// DuplicatesByName groups contacts whose names are at least threshold
+// similar, transitively. It compares every pair, which is fine for address
+// books but not for millions of contacts.
+func DuplicatesByName(contacts []Contact, threshold float64) []DuplicateGroup {
+	names := make([]string, len(contacts))
+	for i, c := range contacts {
+		names[i] = comparableName(c.Name)
+	}
+
+	// Union-find over contact indexes
+	parent := make([]int, len(contacts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range contacts {
+		for j := i + 1; j < len(contacts); j++ {
+			if names[i] == "" || names[j] == "" {
+				continue
+			}
+			if NameSimilarity(names[i], names[j]) >= threshold {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]Contact)
+	var roots []int
+	for i, c := range contacts {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], c)
+	}
+
+	var res []DuplicateGroup
+	for _, v := range roots {
+		if len(groups[v]) > 1 {
+			res = append(res, DuplicateGroup{Reason: DuplicateByName, Key: groups[v][0].Name, Contacts: groups[v]})
+		}
+	}
+
+	return res
+}
+
+// comparableName lower-cases a name, drops punctuation and sorts its words so
+// that "Smith, John" and "john smith" compare equal
+func comparableName(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+
+	return strings.Join(words, " ")
+}
+
+// NameSimilarity is 1 minus the Levenshtein distance of two names relative
+// to the longer one: 1 for equal names, 0 for entirely different ones
+func NameSimilarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func levenshtein(a []rune, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"", "", 1},
+		{"ann lee", "ann lee", 1},
+		{"ann lee", "", 0},
+		{"abc", "xyz", 0},
+		{"jon smith", "john smith", 0.9},
+		{"kitten", "sitting", 1 - 3.0/7},
+		{"алла", "алла", 1},
+		{"алла", "алло", 0.75},
+	}
+	for _, tt := range tests {
+		got := NameSimilarity(tt.a, tt.b)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("NameSimilarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+		if back := NameSimilarity(tt.b, tt.a); back != got {
+			t.Errorf("NameSimilarity(%q, %q) = %v, but %v the other way round", tt.a, tt.b, got, back)
+		}
+	}
+}
+
+func TestDuplicatesByPhone(t *testing.T) {
+	contacts := []Contact{
+		{ID: "ann", Phone: "+7 916 123-45-67"},
+		{ID: "bob", Phones: []PhoneNumber{{Number: "+12025550100"}, {Number: "+79161234567"}}},
+		{ID: "eve", Phone: "+1 (202) 555-0100"},
+		{ID: "joe", Phone: "+442079460958"},
+		// The same number twice on one contact is no duplicate
+		{ID: "max", Phones: []PhoneNumber{{Number: "+33612345678"}, {Number: "+33 6 12 34 56 78"}}},
+		{ID: "nil"},
+	}
+
+	groups := DuplicatesByPhone(contacts)
+	want := []struct {
+		key string
+		ids []string
+	}{
+		{"+12025550100", []string{"bob", "eve"}},
+		{"+79161234567", []string{"ann", "bob"}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("%v groups %+v, want %v", len(groups), groups, len(want))
+	}
+	for i, g := range groups {
+		if g.Reason != DuplicateByPhone || g.Key != want[i].key || len(g.Contacts) != len(want[i].ids) {
+			t.Fatalf("group %v is %+v, want %v of %v", i, g, want[i].key, want[i].ids)
+		}
+		for j, c := range g.Contacts {
+			if c.ID != want[i].ids[j] {
+				t.Errorf("group %v holds %v, want %v", want[i].key, c.ID, want[i].ids[j])
+			}
+		}
+	}
+}
+
+func TestDuplicatesByName(t *testing.T) {
+	contacts := []Contact{
+		{ID: "1", Name: "John Smith"},
+		{ID: "2", Name: "Ann Lee"},
+		{ID: "3", Name: "Smith, John"},
+		{ID: "4", Name: "Jon Smith"},
+		{ID: "5", Name: "Bob Ray"},
+		{ID: "6", Name: ""},
+		{ID: "7", Name: "!!"},
+	}
+
+	groups := DuplicatesByName(contacts, DefaultNameSimilarity)
+	if len(groups) != 1 {
+		t.Fatalf("%v groups %+v, want 1", len(groups), groups)
+	}
+	g := groups[0]
+	if g.Reason != DuplicateByName || g.Key != "John Smith" || len(g.Contacts) != 3 {
+		t.Fatalf("group %+v, want the three John Smiths", g)
+	}
+}
@@ -1,74 +1,380 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/sgnl-05/contactService/utils"
+	"log"
+	"net/http"
 	"strings"
+	"time"
 )
 
-type eContactSource struct {
+type eGetResponse struct {
+	Found  bool    `json:"found"`
 	Source Contact `json:"_source"`
 }
 
-type eContactHitsLow struct {
-	Hits []eContactSource `json:"hits"`
-}
+// contactMapping declares the list fields as nested so that a query matches
+// label and value of the same entry
+const contactMapping = `{
+	"properties": {
+		"tags": {"type": "keyword"},
+		"aliases": {"type": "keyword"},
+		"custom": {"type": "object", "enabled": false},
+		"created_at": {"type": "date"},
+		"updated_at": {"type": "date"},
+		"created_by": {"type": "keyword"},
+		"deleted_at": {"type": "date"},
+		"phones": {
+			"type": "nested",
+			"properties": {
+				"number": {"type": "keyword"},
+				"label": {"type": "keyword"},
+				"primary": {"type": "boolean"}
+			}
+		},
+		"emails": {
+			"type": "nested",
+			"properties": {
+				"address": {"type": "keyword"},
+				"label": {"type": "keyword"},
+				"primary": {"type": "boolean"}
+			}
+		},
+		"addresses": {
+			"type": "nested",
+			"properties": {
+				"street": {"type": "text"},
+				"city": {"type": "text"},
+				"region": {"type": "text"},
+				"postal_code": {"type": "keyword"},
+				"country": {"type": "keyword"},
+				"label": {"type": "keyword"},
+				"primary": {"type": "boolean"}
+			}
+		}
+	}
+}`
 
-type eContactHitsUp struct {
-	Hits eContactHitsLow `json:"hits"`
-}
+// phoneClaimMapping is the claim of a phone number by a contact
+const phoneClaimMapping = `{
+	"properties": {
+		"contact_id": {"type": "keyword"}
+	}
+}`
 
-func (s ElasticStorage) updateElasticDoc(body Contact) error {
-	contactString, err := json.Marshal(body)
+// historyMapping keeps the contact snapshots of revisions out of the index,
+// they are only ever read back whole
+const historyMapping = `{
+	"properties": {
+		"contact_id": {"type": "keyword"},
+		"version": {"type": "integer"},
+		"action": {"type": "keyword"},
+		"timestamp": {"type": "date"},
+		"actor": {"type": "keyword"},
+		"before": {"type": "object", "enabled": false},
+		"after": {"type": "object", "enabled": false},
+		"diff": {"type": "object", "enabled": false}
+	}
+}`
+
+// changeMapping keeps the contact snapshots of changes out of the index, like
+// historyMapping
+const changeMapping = `{
+	"properties": {
+		"seq": {"type": "long"},
+		"type": {"type": "keyword"},
+		"contact_id": {"type": "keyword"},
+		"timestamp": {"type": "date"},
+		"actor": {"type": "keyword"},
+		"contact": {"type": "object", "enabled": false}
+	}
+}`
+
+// webhookMapping keeps webhooks out of searches, they are only listed
+const webhookMapping = `{
+	"properties": {
+		"id": {"type": "keyword"},
+		"url": {"type": "keyword", "index": false},
+		"events": {"type": "keyword"},
+		"secret": {"type": "keyword", "index": false},
+		"created_at": {"type": "date"},
+		"updated_at": {"type": "date"}
+	}
+}`
+
+// deadLetterMapping keeps the failed changes out of the index, like
+// changeMapping
+const deadLetterMapping = `{
+	"properties": {
+		"id": {"type": "keyword"},
+		"webhook_id": {"type": "keyword"},
+		"change": {"type": "object", "enabled": false},
+		"attempts": {"type": "integer"},
+		"last_status": {"type": "integer"},
+		"last_error": {"type": "text", "index": false},
+		"failed_at": {"type": "date"}
+	}
+}`
+
+// apiKeyMapping stores API keys, only listed or fetched by ID
+const apiKeyMapping = `{
+	"properties": {
+		"id": {"type": "keyword"},
+		"name": {"type": "keyword"},
+		"scopes": {"type": "keyword"},
+		"hash": {"type": "keyword", "index": false},
+		"prefix": {"type": "keyword", "index": false},
+		"created_at": {"type": "date"},
+		"rotated_at": {"type": "date"},
+		"revoked_at": {"type": "date"}
+	}
+}`
+
+// addressBookMapping stores shared address books, found by ID or member
+const addressBookMapping = `{
+	"properties": {
+		"id": {"type": "keyword"},
+		"name": {"type": "keyword"},
+		"owner": {"type": "keyword"},
+		"members": {"properties": {"user": {"type": "keyword"}, "role": {"type": "keyword"}}},
+		"created_at": {"type": "date"},
+		"updated_at": {"type": "date"}
+	}
+}`
+
+// idempotencyMapping stores replayed responses without indexing them
+const idempotencyMapping = `{
+	"properties": {
+		"key": {"type": "keyword"},
+		"fingerprint": {"type": "keyword"},
+		"status": {"type": "integer"},
+		"body": {"type": "binary"},
+		"created_at": {"type": "date"}
+	}
+}`
+
+// ensureIndex creates the contacts index with its mapping, or adds the
+// mapping of the list fields to an index created before they existed
+func (s ElasticStorage) ensureIndex(index string, mapping string) error {
+	response, err := s.client.Indices.Exists([]string{index})
 	if err != nil {
 		return err
 	}
+	response.Body.Close()
 
-	_, err = s.client.Index(IndexName, strings.NewReader(string(contactString)), s.client.Index.WithDocumentID(body.ID))
+	if response.StatusCode == http.StatusNotFound {
+		response, err = s.client.Indices.Create(index, s.client.Indices.Create.WithBody(strings.NewReader(`{"mappings": `+mapping+`}`)))
+	} else {
+		response, err = s.client.Indices.PutMapping([]string{index}, strings.NewReader(mapping))
+	}
 	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return fmt.Errorf("elastic index %v: %v", index, response.String())
+	}
 
 	return nil
 }
 
-func (s ElasticStorage) List() ([]Contact, error) {
+// search runs a query against index and decodes all of its hits
+func (s ElasticStorage) search(index string, query interface{}) ([]Contact, error) {
 	var list []Contact
 
-	response, err := s.client.Search(
-		s.client.Search.WithIndex(IndexName),
-		s.client.Search.WithBody(strings.NewReader(`{
-	  "query": {
-	    "match_all": {}
-	  }
-	}`)),
-	)
+	err := s.scroll(index, map[string]interface{}{"query": query, "sort": []string{"_doc"}}, func(source json.RawMessage) error {
+		var c Contact
+		err := json.Unmarshal(source, &c)
+		list = append(list, c)
+		return err
+	})
 	if err != nil {
-		return list, err
+		return nil, err
 	}
 
-	var responseBody eContactHitsUp
-	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	return normalizeContacts(list), nil
+}
+
+// regexpEscape quotes the characters of the Lucene regular expression syntax
+func regexpEscape(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune(`.?+*|{}[]()"\#@&<>~`, r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// wildcard builds a case-insensitive substring query on a single field
+func wildcard(field string, value string) interface{} {
+	escaped := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(value)
+
+	return map[string]interface{}{"wildcard": map[string]interface{}{
+		field: map[string]interface{}{"value": "*" + escaped + "*", "case_insensitive": true},
+	}}
+}
+
+type ePhoneClaim struct {
+	Found       bool `json:"found"`
+	SeqNo       int  `json:"_seq_no"`
+	PrimaryTerm int  `json:"_primary_term"`
+	Source      struct {
+		ContactID string `json:"contact_id"`
+	} `json:"_source"`
+}
+
+// claimPhones claims the numbers of c in PhoneIndexName. Creating a document
+// with the number as its ID is atomic across service instances. Claims of
+// contacts which changed their number or were deleted are taken over. With
+// strict set a number held by another contact is an error, otherwise the
+// claim is left to that contact.
+func (s ElasticStorage) claimPhones(c Contact, strict bool) error {
+	if !s.UniquePhone || c.IsDeleted() {
+		return nil
+	}
+
+	for _, number := range c.PhoneNumbers() {
+		owner, err := s.claimPhone(phoneDigits(number), c.ID)
+		if err != nil {
+			return err
+		}
+		if owner != "" && strict {
+			return utils.DuplicateError{Field: "phone", Value: number, ExistingID: owner}
+		}
+	}
+
+	return nil
+}
+
+// claimPhone returns the ID of another active contact owning the number, or
+// an empty string once the claim is id's
+func (s ElasticStorage) claimPhone(digits string, id string) (string, error) {
+	body := fmt.Sprintf(`{"contact_id": %q}`, id)
+
+	// A concurrent takeover makes the conditional write below fail, the
+	// claim is then read again
+	for attempt := 0; attempt < 3; attempt++ {
+		request := esapi.IndexRequest{Index: s.index(PhoneIndexName), DocumentID: digits, OpType: "create", Body: strings.NewReader(body), Refresh: "true"}
+		response, err := request.Do(context.Background(), s.client)
+		if err != nil {
+			return "", err
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusConflict {
+			if response.IsError() {
+				return "", fmt.Errorf("elastic index %v: %v", s.index(PhoneIndexName), response.String())
+			}
+			return "", nil
+		}
+
+		response, err = s.client.Get(s.index(PhoneIndexName), digits)
+		if err != nil {
+			return "", err
+		}
+		var claim ePhoneClaim
+		err = json.NewDecoder(response.Body).Decode(&claim)
+		response.Body.Close()
+		if err != nil {
+			return "", err
+		}
+		if !claim.Found {
+			continue
+		}
+		if claim.Source.ContactID == id {
+			return "", nil
+		}
+
+		owner, err := s.Get(claim.Source.ContactID)
+		if err == nil && owner.hasPhone(digits) {
+			return owner.ID, nil
+		}
+		if err != nil && !errors.Is(err, utils.ErrContactNotFound) {
+			return "", err
+		}
+
+		// The claim is stale, but another contact may have been given the
+		// number by an edit
+		others, err := s.Filter("phone", "+"+digits)
+		if err != nil {
+			return "", err
+		}
+		for _, other := range others {
+			if other.ID != id && other.hasPhone(digits) {
+				return other.ID, nil
+			}
+		}
+
+		seqNo, primaryTerm := claim.SeqNo, claim.PrimaryTerm
+		request = esapi.IndexRequest{Index: s.index(PhoneIndexName), DocumentID: digits, Body: strings.NewReader(body), IfSeqNo: &seqNo, IfPrimaryTerm: &primaryTerm, Refresh: "true"}
+		response, err = request.Do(context.Background(), s.client)
+		if err != nil {
+			return "", err
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusConflict {
+			if response.IsError() {
+				return "", fmt.Errorf("elastic index %v: %v", s.index(PhoneIndexName), response.String())
+			}
+			return "", nil
+		}
+	}
+
+	return "", fmt.Errorf("elastic index %v: claim of %v kept changing", s.index(PhoneIndexName), digits)
+}
+
+func (s ElasticStorage) updateElasticDoc(body Contact) error {
+	body.normalizeLists()
+	err := s.claimPhones(body, false)
+	if err != nil {
+		return err
+	}
+	contactString, err := json.Marshal(body)
 	if err != nil {
-		return list, err
+		return err
 	}
 
-	for i := range responseBody.Hits.Hits {
-		list = append(list, responseBody.Hits.Hits[i].Source)
+	_, err = s.client.Index(s.index(IndexName), strings.NewReader(string(contactString)), s.client.Index.WithDocumentID(body.ID))
+	if err != nil {
+		return err
 	}
 
-	return list, nil
+	return nil
+}
+
+// notTrashed narrows a query down to contacts outside the trash
+func notTrashed(query interface{}) interface{} {
+	return map[string]interface{}{"bool": map[string]interface{}{
+		"must":     query,
+		"must_not": map[string]interface{}{"exists": map[string]interface{}{"field": "deleted_at"}},
+	}}
+}
+
+func (s ElasticStorage) List() ([]Contact, error) {
+	return s.search(s.index(IndexName), notTrashed(map[string]interface{}{"match_all": map[string]interface{}{}}))
 }
 
 func (s ElasticStorage) Add(c Contact) error {
+	c.normalizeLists()
+	err := s.claimPhones(c, true)
+	if err != nil {
+		return err
+	}
+	c.touchCreated()
 	contactString, err := json.Marshal(c)
 	if err != nil {
 		return err
 	}
 
-	request := esapi.IndexRequest{Index: IndexName, DocumentID: c.ID, OpType: "create", Body: strings.NewReader(string(contactString))}
+	request := esapi.IndexRequest{Index: s.index(IndexName), DocumentID: c.ID, OpType: "create", Body: strings.NewReader(string(contactString))}
 	_, err = request.Do(context.Background(), s.client)
 	if err != nil {
 		return err
@@ -78,43 +384,23 @@ func (s ElasticStorage) Add(c Contact) error {
 }
 
 func (s ElasticStorage) Delete(id string) error {
-	_, err := s.client.Delete(IndexName, id)
-
+	c, err := s.Get(id)
 	if err != nil {
 		return err
 	}
+	c.trash()
 
-	return nil
+	return s.updateElasticDoc(c)
 }
 
 func (s ElasticStorage) Edit(e EditContact) (Contact, error) {
-	var res Contact
-	var responseBody eContactSource
-
-	response, err := s.client.Get(IndexName, e.ID)
-	if err != nil {
-		return res, err
-	}
-
-	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	res, err := s.Get(e.ID)
 	if err != nil {
 		return res, err
 	}
 
-	res = responseBody.Source
-
-	if e.Name != "" {
-		res.Name = e.Name
-	}
-	if e.Country != "" {
-		res.Country = e.Country
-	}
-	if e.Phone != "" {
-		res.Phone = e.Phone
-	}
-	if e.Gender != "" {
-		res.Gender = e.Gender
-	}
+	res.applyEdit(e)
+	res.UpdatedAt = now()
 
 	err = s.updateElasticDoc(res)
 	if err != nil {
@@ -126,121 +412,1039 @@ func (s ElasticStorage) Edit(e EditContact) (Contact, error) {
 
 func (s ElasticStorage) Filter(field string, value string) ([]Contact, error) {
 	var res []Contact
-	var searchCond string
+	var query interface{}
+
+	contains := ".*" + regexpEscape(strings.ToLower(value)) + ".*"
+	nested := func(path string, query interface{}) interface{} {
+		return map[string]interface{}{"nested": map[string]interface{}{"path": path, "query": query}}
+	}
 
 	switch field {
 	case "name":
-		searchCond = `{
-	"query": {
-		"regexp": {
-			"name": ".*` + value + `.*"
-			}
-		}
-	}`
+		query = map[string]interface{}{"regexp": map[string]interface{}{"name": contains}}
 	case "phone":
-		searchCond = `{
-	"query": {
-		"regexp": {
-			"phone": ".*` + value + `.*"
-			}
+		digits := phoneFilterDigits(value)
+		if digits == "" {
+			return res, nil
 		}
-	}`
+		// Contacts stored before the phones list existed only have "phone"
+		query = map[string]interface{}{"bool": map[string]interface{}{"should": []interface{}{
+			nested("phones", map[string]interface{}{"regexp": map[string]interface{}{"phones.number": ".*" + digits + ".*"}}),
+			map[string]interface{}{"regexp": map[string]interface{}{"phone": ".*" + digits + ".*"}},
+		}}}
+	case "email":
+		query = nested("emails", wildcard("emails.address", value))
+	case "tag":
+		query = map[string]interface{}{"term": map[string]interface{}{"tags": strings.ToLower(strings.TrimSpace(value))}}
+	case "alias":
+		query = map[string]interface{}{"term": map[string]interface{}{"aliases": value}}
+	case "address":
+		var should []interface{}
+		for _, v := range []string{"street", "city", "region", "postal_code", "country"} {
+			should = append(should, wildcard("addresses."+v, value))
+		}
+		query = nested("addresses", map[string]interface{}{"bool": map[string]interface{}{"should": should}})
 	default:
 		return res, utils.ErrFilterWrongFormat
 	}
 
-	response, err := s.client.Search(
-		s.client.Search.WithIndex(IndexName),
-		s.client.Search.WithBody(strings.NewReader(searchCond)),
-	)
+	return s.search(s.index(IndexName), notTrashed(query))
+}
+
+func (s ElasticStorage) ListFavs() ([]Contact, error) {
+	return s.search(s.index(IndexName), notTrashed(map[string]interface{}{"match": map[string]interface{}{"favorite": true}}))
+}
+
+func (s ElasticStorage) ChangeFavs(id string, action string) error {
+	res, err := s.Get(id)
 	if err != nil {
-		return res, err
+		return err
+	}
+
+	switch action {
+	case "add":
+		if res.Favorite == true {
+			return utils.ErrAlreadyFav
+		}
+		res.Favorite = true
+	case "remove":
+		if res.Favorite == false {
+			return utils.ErrAlreadyNotFav
+		}
+		res.Favorite = false
+	default:
+		return utils.ErrFavWrongFormat
+	}
+	res.UpdatedAt = now()
+
+	return s.updateElasticDoc(res)
+}
+
+// getStored fetches a document whether or not it is in the trash
+func (s ElasticStorage) GetWithTrash(id string) (Contact, error) {
+	response, err := s.client.Get(s.index(IndexName), id)
+	if err != nil {
+		return Contact{}, err
 	}
+	defer response.Body.Close()
 
-	var responseBody eContactHitsUp
+	var responseBody eGetResponse
 	err = json.NewDecoder(response.Body).Decode(&responseBody)
 	if err != nil {
-		return res, err
+		return Contact{}, err
 	}
+	if !responseBody.Found {
+		return Contact{}, utils.ErrContactNotFound
+	}
+	responseBody.Source.normalizeLists()
+
+	return responseBody.Source, nil
+}
 
-	for i := range responseBody.Hits.Hits {
-		res = append(res, responseBody.Hits.Hits[i].Source)
+func (s ElasticStorage) Get(id string) (Contact, error) {
+	c, err := s.GetWithTrash(id)
+	if err != nil {
+		return c, err
+	}
+	if c.IsDeleted() {
+		return Contact{}, utils.ErrContactNotFound
 	}
 
-	return res, nil
+	return c, nil
 }
 
-func (s ElasticStorage) ListFavs() ([]Contact, error) {
-	var list []Contact
+func (s ElasticStorage) Update(c Contact) error {
+	stored, err := s.Get(c.ID)
+	if err != nil {
+		return err
+	}
+	c.touchUpdated(stored)
+
+	return s.updateElasticDoc(c)
+}
+
+type eTagsResponse struct {
+	Aggregations struct {
+		Tags struct {
+			Buckets []struct {
+				Key      string `json:"key"`
+				DocCount int    `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"tags"`
+	} `json:"aggregations"`
+}
 
+func (s ElasticStorage) Tags() ([]TagCount, error) {
 	response, err := s.client.Search(
-		s.client.Search.WithIndex(IndexName),
+		s.client.Search.WithIndex(s.index(IndexName)),
 		s.client.Search.WithBody(strings.NewReader(`{
-	  "query": {
-	    "match": {
-			"favorite": true
+	"size": 0,
+	"query": {
+		"bool": {"must_not": {"exists": {"field": "deleted_at"}}}
+	},
+	"aggs": {
+		"tags": {
+			"terms": {"field": "tags", "size": 10000, "order": {"_key": "asc"}}
 		}
-	  }
-	}`)),
+	}
+}`)),
 	)
 	if err != nil {
-		return list, err
+		return nil, err
 	}
+	defer response.Body.Close()
 
-	var responseBody eContactHitsUp
+	var responseBody eTagsResponse
 	err = json.NewDecoder(response.Body).Decode(&responseBody)
 	if err != nil {
-		return list, err
+		return nil, err
 	}
 
-	for i := range responseBody.Hits.Hits {
-		list = append(list, responseBody.Hits.Hits[i].Source)
+	res := make([]TagCount, 0, len(responseBody.Aggregations.Tags.Buckets))
+	for _, v := range responseBody.Aggregations.Tags.Buckets {
+		res = append(res, TagCount{Tag: v.Key, Count: v.DocCount})
 	}
 
-	return list, nil
+	return res, nil
 }
 
-func (s ElasticStorage) ChangeFavs(id string, action string) error {
-	var res Contact
-	var responseBody eContactSource
+func (s ElasticStorage) ListTrash() ([]Contact, error) {
+	return s.search(s.index(IndexName), map[string]interface{}{"exists": map[string]interface{}{"field": "deleted_at"}})
+}
+
+func (s ElasticStorage) Restore(id string) (Contact, error) {
+	c, err := s.GetWithTrash(id)
+	if err != nil || !c.IsDeleted() {
+		return Contact{}, utils.ErrNotInTrash
+	}
+	c.restore()
+	err = s.claimPhones(c, true)
+	if err != nil {
+		return Contact{}, err
+	}
 
-	response, err := s.client.Get(IndexName, id)
+	return c, s.updateElasticDoc(c)
+}
+
+func (s ElasticStorage) Purge(id string) error {
+	c, err := s.GetWithTrash(id)
+	if err != nil || !c.IsDeleted() {
+		return utils.ErrNotInTrash
+	}
+
+	response, err := s.client.Delete(s.index(IndexName), id)
 	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
+
+	return nil
+}
+
+type eDeleteByQueryResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+func (s ElasticStorage) PurgeDeleted(before time.Time) (int, error) {
+	queryBytes, err := json.Marshal(map[string]interface{}{"query": map[string]interface{}{
+		"range": map[string]interface{}{"deleted_at": map[string]interface{}{"lt": before.Format(time.RFC3339Nano)}},
+	}})
+	if err != nil {
+		return 0, err
+	}
 
+	response, err := s.client.DeleteByQuery([]string{s.index(IndexName)}, bytes.NewReader(queryBytes))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var responseBody eDeleteByQueryResponse
 	err = json.NewDecoder(response.Body).Decode(&responseBody)
 	if err != nil {
+		return 0, err
+	}
+
+	return responseBody.Deleted, nil
+}
+
+// searchHistory returns the revisions of a contact sorted by version and
+// their number
+func (s ElasticStorage) searchHistory(id string) ([]Revision, int, error) {
+	var revisions []Revision
+
+	err := s.scroll(s.index(HistoryIndexName), map[string]interface{}{
+		"query": map[string]interface{}{"term": map[string]interface{}{"contact_id": id}},
+		"sort":  []interface{}{map[string]interface{}{"version": "asc"}},
+	}, func(source json.RawMessage) error {
+		var r Revision
+		err := json.Unmarshal(source, &r)
+		revisions = append(revisions, r)
 		return err
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
-	res = responseBody.Source
-	if res.ID == id {
-		switch action {
-		case "add":
-			if res.Favorite == true {
-				return utils.ErrAlreadyFav
-			}
-			res.Favorite = true
-			err = s.updateElasticDoc(res)
+	return revisions, len(revisions), nil
+}
+
+func (s ElasticStorage) AddRevisions(revisions []Revision) error {
+	versions := make(map[string]int)
+	for _, r := range revisions {
+		version, ok := versions[r.ContactID]
+		if !ok {
+			var err error
+			_, version, err = s.searchHistory(r.ContactID)
 			if err != nil {
 				return err
-			} // Internal
-			return nil
-		case "remove":
-			if res.Favorite == false {
-				return utils.ErrAlreadyNotFav
 			}
-			res.Favorite = false
-			err = s.updateElasticDoc(res)
-			if err != nil {
-				return err
-			} // Internal
-			return nil
-		default:
-			return utils.ErrFavWrongFormat
+		}
+		r.Version = version + 1
+		versions[r.ContactID] = r.Version
+
+		// The document ID makes a concurrent second write of a version fail
+		err := s.createDoc(s.index(HistoryIndexName), fmt.Sprintf("%v-%v", r.ContactID, r.Version), r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createDoc indexes a document that must not exist yet
+func (s ElasticStorage) createDoc(index string, id string, doc interface{}) error {
+	docBytes, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	request := esapi.IndexRequest{
+		Index:      index,
+		DocumentID: id,
+		OpType:     "create",
+		Body:       bytes.NewReader(docBytes),
+		Refresh:    "true",
+	}
+	response, err := request.Do(context.Background(), s.client)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return fmt.Errorf("elastic index %v: %v", index, response.String())
+	}
+
+	return nil
+}
+
+func (s ElasticStorage) History(id string) ([]Revision, error) {
+	revisions, _, err := s.searchHistory(id)
+
+	return revisions, err
+}
+
+type eIdempotencyResponse struct {
+	Found  bool              `json:"found"`
+	Source IdempotencyRecord `json:"_source"`
+}
+
+func (s ElasticStorage) GetIdempotent(key string) (IdempotencyRecord, bool, error) {
+	response, err := s.client.Get(s.index(IdempotencyIndexName), idempotencyDocID(key))
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	defer response.Body.Close()
+
+	var responseBody eIdempotencyResponse
+	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	return responseBody.Source, responseBody.Found, nil
+}
+
+func (s ElasticStorage) SaveIdempotent(record IdempotencyRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	request := esapi.IndexRequest{
+		Index:      s.index(IdempotencyIndexName),
+		DocumentID: idempotencyDocID(record.Key),
+		Body:       bytes.NewReader(recordBytes),
+		Refresh:    "true",
+	}
+	response, err := request.Do(context.Background(), s.client)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return fmt.Errorf("elastic index %v: %v", s.index(IdempotencyIndexName), response.String())
+	}
+
+	return nil
+}
+
+func (s ElasticStorage) ExpireIdempotent(before time.Time) (int, error) {
+	queryBytes, err := json.Marshal(map[string]interface{}{"query": map[string]interface{}{
+		"range": map[string]interface{}{"created_at": map[string]interface{}{"lt": before.Format(time.RFC3339Nano)}},
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := s.client.DeleteByQuery([]string{s.index(IdempotencyIndexName)}, bytes.NewReader(queryBytes))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var responseBody eDeleteByQueryResponse
+	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	if err != nil {
+		return 0, err
+	}
+
+	return responseBody.Deleted, nil
+}
+
+type eMgetResponse struct {
+	Docs []eGetResponse `json:"docs"`
+}
+
+// getMany fetches the contacts outside the trash among ids in one request
+func (s ElasticStorage) getMany(ids []string) (map[string]Contact, error) {
+	res := make(map[string]Contact)
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	queryBytes, err := json.Marshal(map[string]interface{}{"ids": ids})
+	if err != nil {
+		return res, err
+	}
+
+	response, err := s.client.Mget(bytes.NewReader(queryBytes), s.client.Mget.WithIndex(s.index(IndexName)))
+	if err != nil {
+		return res, err
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return res, fmt.Errorf("elastic mget %v: %v", s.index(IndexName), response.String())
+	}
+
+	var responseBody eMgetResponse
+	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	if err != nil {
+		return res, err
+	}
+	for _, v := range responseBody.Docs {
+		if v.Found && !v.Source.IsDeleted() {
+			v.Source.normalizeLists()
+			res[v.Source.ID] = v.Source
+		}
+	}
+
+	return res, nil
+}
+
+type eBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulkIndex writes contacts with the _bulk API, deleting those listed in
+// remove, and returns the error of each failed document by ID
+func (s ElasticStorage) bulkIndex(contacts []Contact, remove []string) (map[string]error, error) {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, c := range contacts {
+		err := encoder.Encode(map[string]interface{}{"index": map[string]interface{}{"_index": s.index(IndexName), "_id": c.ID}})
+		if err != nil {
+			return nil, err
+		}
+		err = encoder.Encode(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range remove {
+		err := encoder.Encode(map[string]interface{}{"delete": map[string]interface{}{"_index": s.index(IndexName), "_id": id}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := s.client.Bulk(&body, s.client.Bulk.WithRefresh("true"))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return nil, fmt.Errorf("elastic bulk %v: %v", s.index(IndexName), response.String())
+	}
+
+	var responseBody eBulkResponse
+	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make(map[string]error)
+	for _, item := range responseBody.Items {
+		for _, v := range item {
+			if v.Status >= http.StatusMultipleChoices {
+				failed[v.ID] = fmt.Errorf("elastic bulk %v: %s", s.index(IndexName), v.Error)
+			}
+		}
+	}
+
+	return failed, nil
+}
+
+// Bulk applies operations with a single _bulk request. Elastic has no
+// transactions: with atomic set nothing is sent unless all operations are
+// valid, and documents written before another one failed are put back.
+func (s ElasticStorage) Bulk(ops []BulkOperation, atomic bool) ([]BulkResult, error) {
+	var ids []string
+	for _, op := range ops {
+		switch op.Op {
+		case BulkUpdate:
+			ids = append(ids, op.Edit.ID)
+		case BulkDelete:
+			ids = append(ids, op.ID)
+		}
+	}
+	stored, err := s.getMany(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(id string) (Contact, bool) {
+		c, ok := stored[id]
+		return c, ok
+	}
+	var checkPhones func(Contact) error
+	if s.UniquePhone {
+		checkPhones = func(c Contact) error {
+			return s.claimPhones(c, true)
+		}
+	}
+
+	results := planBulk(ops, get, checkPhones)
+	writes := bulkWrites(results, atomic)
+	if atomic && BulkFailed(results) {
+		skipBulk(results)
+	}
+	if len(writes) == 0 {
+		return results, nil
+	}
+	for _, c := range writes {
+		if _, ok := stored[c.ID]; ok {
+			err = s.claimPhones(c, false)
+			if err != nil {
+				return results, err
+			}
+		}
+	}
+
+	failed, err := s.bulkIndex(writes, nil)
+	if err != nil {
+		return results, err
+	}
+	if len(failed) == 0 {
+		return results, nil
+	}
+
+	for i := range results {
+		if err, ok := failed[results[i].ID]; ok && results[i].Err == nil {
+			results[i].Err = err
+			results[i].Before, results[i].After = nil, nil
+		}
+	}
+	if !atomic {
+		return results, nil
+	}
+
+	// Put back the documents that were written
+	var previous []Contact
+	var created []string
+	for _, c := range writes {
+		if _, ok := failed[c.ID]; ok {
+			continue
+		}
+		if v, ok := stored[c.ID]; ok {
+			previous = append(previous, v)
+		} else {
+			created = append(created, c.ID)
+		}
+	}
+	_, err = s.bulkIndex(previous, created)
+	skipBulk(results)
+
+	return results, err
+}
+
+// scrollPageSize is the number of documents fetched per scroll request
+const scrollPageSize = 500
+
+type eScrollResponse struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []struct {
+			Source json.RawMessage `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// scroll calls fn for the source of every document a search body finds, in
+// the order of its sort, until fn fails. The documents are fetched page by
+// page with the scroll API, so that no search is cut off at the size limit of
+// Elastic.
+func (s ElasticStorage) scroll(index string, body map[string]interface{}, fn func(json.RawMessage) error) error {
+	body["size"] = scrollPageSize
+	queryBytes, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.client.Search(
+		s.client.Search.WithIndex(index),
+		s.client.Search.WithBody(bytes.NewReader(queryBytes)),
+		s.client.Search.WithScroll(time.Minute),
+	)
+	scrollID := ""
+	defer func() {
+		if scrollID == "" {
+			return
+		}
+		response, err := s.client.ClearScroll(s.client.ClearScroll.WithScrollID(scrollID))
+		if err == nil {
+			response.Body.Close()
+		}
+	}()
+
+	for {
+		if err != nil {
+			return err
+		}
+		if response.IsError() {
+			response.Body.Close()
+			return fmt.Errorf("elastic search %v: %v", index, response.String())
+		}
+
+		var page eScrollResponse
+		err = json.NewDecoder(response.Body).Decode(&page)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		if page.ScrollID != "" {
+			scrollID = page.ScrollID
+		}
+		if len(page.Hits.Hits) == 0 {
+			return nil
+		}
+
+		for _, v := range page.Hits.Hits {
+			err = fn(v.Source)
+			if err != nil {
+				return err
+			}
+		}
+
+		response, err = s.client.Scroll(s.client.Scroll.WithScrollID(scrollID), s.client.Scroll.WithScroll(time.Minute))
+	}
+}
+
+// Walk fetches the contacts outside the trash page by page as the walk runs.
+// Elastic keeps them consistent by itself, the walk needs no lock.
+func (s ElasticStorage) Walk() (WalkFunc, error) {
+	query := map[string]interface{}{
+		"query": notTrashed(map[string]interface{}{"match_all": map[string]interface{}{}}),
+		"sort":  []string{"_doc"},
+	}
+
+	return func(fn func(Contact) error) error {
+		return s.scroll(s.index(IndexName), query, func(source json.RawMessage) error {
+			var c Contact
+			err := json.Unmarshal(source, &c)
+			if err != nil {
+				return err
+			}
+			c.normalizeLists()
+			return fn(c)
+		})
+	}, nil
+}
+
+type eChangeHits struct {
+	Hits struct {
+		Hits []struct {
+			Source Change `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+type eChangeBounds struct {
+	Aggregations struct {
+		Oldest struct {
+			Value *float64 `json:"value"`
+		} `json:"oldest"`
+		Latest struct {
+			Value *float64 `json:"value"`
+		} `json:"latest"`
+	} `json:"aggregations"`
+}
+
+func (s ElasticStorage) AppendChanges(changes []Change) ([]Change, error) {
+	_, latest, err := s.ChangeBounds()
+	if err != nil {
+		return nil, err
+	}
+
+	appended := make([]Change, 0, len(changes))
+	for _, c := range changes {
+		latest++
+		c.Seq = latest
+
+		// The document ID makes a concurrent second write of a sequence number fail
+		err = s.createDoc(s.index(ChangesIndexName), fmt.Sprint(c.Seq), c)
+		if err != nil {
+			return appended, err
+		}
+		appended = append(appended, c)
+	}
+
+	return appended, nil
+}
+
+func (s ElasticStorage) Changes(since int64, limit int) ([]Change, error) {
+	var changes []Change
+
+	queryBytes, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"range": map[string]interface{}{"seq": map[string]interface{}{"gt": since}}},
+		"sort":  []interface{}{map[string]interface{}{"seq": "asc"}},
+		"size":  limit,
+	})
+	if err != nil {
+		return changes, err
+	}
+
+	response, err := s.client.Search(
+		s.client.Search.WithIndex(s.index(ChangesIndexName)),
+		s.client.Search.WithBody(bytes.NewReader(queryBytes)),
+	)
+	if err != nil {
+		return changes, err
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return changes, fmt.Errorf("elastic search %v: %v", s.index(ChangesIndexName), response.String())
+	}
+
+	var responseBody eChangeHits
+	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	if err != nil {
+		return changes, err
+	}
+	for _, v := range responseBody.Hits.Hits {
+		changes = append(changes, v.Source)
+	}
+
+	return changes, nil
+}
+
+func (s ElasticStorage) ChangeBounds() (int64, int64, error) {
+	queryBytes, err := json.Marshal(map[string]interface{}{
+		"size": 0,
+		"aggs": map[string]interface{}{
+			"oldest": map[string]interface{}{"min": map[string]interface{}{"field": "seq"}},
+			"latest": map[string]interface{}{"max": map[string]interface{}{"field": "seq"}},
+		},
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	response, err := s.client.Search(
+		s.client.Search.WithIndex(s.index(ChangesIndexName)),
+		s.client.Search.WithBody(bytes.NewReader(queryBytes)),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return 0, 0, fmt.Errorf("elastic search %v: %v", s.index(ChangesIndexName), response.String())
+	}
+
+	var responseBody eChangeBounds
+	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	if err != nil || responseBody.Aggregations.Latest.Value == nil {
+		return 0, 0, err
+	}
+
+	return int64(*responseBody.Aggregations.Oldest.Value), int64(*responseBody.Aggregations.Latest.Value), nil
+}
+
+func (s ElasticStorage) ExpireChanges(before time.Time) (int, error) {
+	_, latest, err := s.ChangeBounds()
+	if err != nil {
+		return 0, err
+	}
+
+	// The latest change is kept so that the sequence carries on from it
+	queryBytes, err := json.Marshal(map[string]interface{}{"query": map[string]interface{}{
+		"bool": map[string]interface{}{"filter": []interface{}{
+			map[string]interface{}{"range": map[string]interface{}{"timestamp": map[string]interface{}{"lt": before.Format(time.RFC3339Nano)}}},
+			map[string]interface{}{"range": map[string]interface{}{"seq": map[string]interface{}{"lt": latest}}},
+		}},
+	}})
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := s.client.DeleteByQuery([]string{s.index(ChangesIndexName)}, bytes.NewReader(queryBytes))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	var responseBody eDeleteByQueryResponse
+	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	if err != nil {
+		return 0, err
+	}
+
+	return responseBody.Deleted, nil
+}
+
+// indexDoc writes a document under an ID, replacing the previous one
+func (s ElasticStorage) indexDoc(index string, id string, doc interface{}) error {
+	docBytes, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	request := esapi.IndexRequest{
+		Index:      index,
+		DocumentID: id,
+		Body:       bytes.NewReader(docBytes),
+		Refresh:    "true",
+	}
+	response, err := request.Do(context.Background(), s.client)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return fmt.Errorf("elastic index %v: %v", index, response.String())
+	}
+
+	return nil
+}
+
+// deleteDoc removes a document, failing with notFound when there is none
+func (s ElasticStorage) deleteDoc(index string, id string, notFound error) error {
+	response, err := s.client.Delete(index, id, s.client.Delete.WithRefresh("true"))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return notFound
+	}
+	if response.IsError() {
+		return fmt.Errorf("elastic delete %v: %v", index, response.String())
+	}
+
+	return nil
+}
+
+// searchSorted decodes the sources of the documents of an index, sorted by a
+// field, into the slice pointed to by out
+func (s ElasticStorage) searchSorted(index string, field string, out interface{}) error {
+	var sources []json.RawMessage
+	err := s.scroll(index, map[string]interface{}{"sort": []interface{}{map[string]interface{}{field: "asc"}}}, func(source json.RawMessage) error {
+		sources = append(sources, source)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if sources == nil {
+		sources = []json.RawMessage{}
+	}
+	sourceBytes, err := json.Marshal(sources)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(sourceBytes, out)
+}
+
+func (s ElasticStorage) ListWebhooks() ([]Webhook, error) {
+	var webhooks []Webhook
+	err := s.searchSorted(s.index(WebhookIndexName), "created_at", &webhooks)
+
+	return webhooks, err
+}
+
+func (s ElasticStorage) SaveWebhook(w Webhook) error {
+	return s.indexDoc(s.index(WebhookIndexName), w.ID, w)
+}
+
+func (s ElasticStorage) DeleteWebhook(id string) error {
+	return s.deleteDoc(s.index(WebhookIndexName), id, utils.ErrWebhookNotFound)
+}
+
+func (s ElasticStorage) ListDeadLetters() ([]DeadLetter, error) {
+	var deadLetters []DeadLetter
+	err := s.searchSorted(s.index(DeadLetterIndexName), "failed_at", &deadLetters)
+
+	return deadLetters, err
+}
+
+func (s ElasticStorage) SaveDeadLetter(d DeadLetter) error {
+	return s.indexDoc(s.index(DeadLetterIndexName), d.ID, d)
+}
+
+func (s ElasticStorage) DeleteDeadLetter(id string) error {
+	return s.deleteDoc(s.index(DeadLetterIndexName), id, utils.ErrDeadLetterNotFound)
+}
+
+func (s ElasticStorage) ListAPIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := s.searchSorted(APIKeyIndexName, "created_at", &keys)
+
+	return keys, err
+}
+
+type eAPIKeyResponse struct {
+	Found  bool   `json:"found"`
+	Source APIKey `json:"_source"`
+}
+
+func (s ElasticStorage) GetAPIKey(id string) (APIKey, error) {
+	response, err := s.client.Get(APIKeyIndexName, id)
+	if err != nil {
+		return APIKey{}, err
+	}
+	defer response.Body.Close()
+
+	var responseBody eAPIKeyResponse
+	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if !responseBody.Found {
+		return APIKey{}, utils.ErrAPIKeyNotFound
+	}
+
+	return responseBody.Source, nil
+}
+
+func (s ElasticStorage) SaveAPIKey(k APIKey) error {
+	return s.indexDoc(APIKeyIndexName, k.ID, k)
+}
+
+func (s ElasticStorage) ListAddressBooks() ([]AddressBook, error) {
+	var books []AddressBook
+	err := s.searchSorted(AddressBookIndexName, "created_at", &books)
+
+	return books, err
+}
+
+type eAddressBookResponse struct {
+	Found  bool        `json:"found"`
+	Source AddressBook `json:"_source"`
+}
+
+func (s ElasticStorage) GetAddressBook(id string) (AddressBook, error) {
+	response, err := s.client.Get(AddressBookIndexName, id)
+	if err != nil {
+		return AddressBook{}, err
+	}
+	defer response.Body.Close()
+
+	var responseBody eAddressBookResponse
+	err = json.NewDecoder(response.Body).Decode(&responseBody)
+	if err != nil {
+		return AddressBook{}, err
+	}
+	if !responseBody.Found {
+		return AddressBook{}, utils.ErrBookNotFound
+	}
+
+	return responseBody.Source, nil
+}
+
+func (s ElasticStorage) SaveAddressBook(b AddressBook) error {
+	return s.indexDoc(AddressBookIndexName, b.ID, b)
+}
+
+// index returns the name of an index of the tenant's book. Other tenants than
+// the default one have indices named after them, such as contacts-acme and
+// contacts-acme_history.
+func (s ElasticStorage) index(name string) string {
+	if s.tenant == DefaultTenant {
+		return name
+	}
+
+	return IndexName + "-" + s.tenant + strings.TrimPrefix(name, IndexName)
+}
+
+// prepare creates the indices of the tenant's book
+func (s ElasticStorage) prepare() {
+	err := s.ensureIndex(s.index(IndexName), contactMapping)
+	if err != nil {
+		log.Printf("Error preparing index %v: %s", s.index(IndexName), err)
+	}
+	err = s.ensureIndex(s.index(HistoryIndexName), historyMapping)
+	if err != nil {
+		log.Printf("Error preparing index %v: %s", s.index(HistoryIndexName), err)
+	}
+	err = s.ensureIndex(s.index(IdempotencyIndexName), idempotencyMapping)
+	if err != nil {
+		log.Printf("Error preparing index %v: %s", s.index(IdempotencyIndexName), err)
+	}
+	err = s.ensureIndex(s.index(PhoneIndexName), phoneClaimMapping)
+	if err != nil {
+		log.Printf("Error preparing index %v: %s", s.index(PhoneIndexName), err)
+	}
+	err = s.ensureIndex(s.index(ChangesIndexName), changeMapping)
+	if err != nil {
+		log.Printf("Error preparing index %v: %s", s.index(ChangesIndexName), err)
+	}
+	err = s.ensureIndex(s.index(WebhookIndexName), webhookMapping)
+	if err != nil {
+		log.Printf("Error preparing index %v: %s", s.index(WebhookIndexName), err)
+	}
+	err = s.ensureIndex(s.index(DeadLetterIndexName), deadLetterMapping)
+	if err != nil {
+		log.Printf("Error preparing index %v: %s", s.index(DeadLetterIndexName), err)
+	}
+
+}
+
+// Tenant returns the book of a tenant, in its own indices created on first
+// use
+func (s ElasticStorage) Tenant(tenant string) StorageInterface {
+	book := ElasticStorage{client: s.client, UniquePhone: s.UniquePhone, tenant: tenant, prepared: s.prepared}
+	if tenant == DefaultTenant {
+		return book
+	}
+	if s.prepared == nil {
+		book.prepare()
+	} else if _, done := s.prepared.LoadOrStore(tenant, true); !done {
+		book.prepare()
+	}
+
+	return book
+}
+
+// Tenants lists the tenants with a book besides the default one, from the
+// names of their contact indices
+func (s ElasticStorage) Tenants() ([]string, error) {
+	var tenants []string
+
+	response, err := s.client.Cat.Indices(
+		s.client.Cat.Indices.WithIndex(IndexName+"-*"),
+		s.client.Cat.Indices.WithFormat("json"),
+		s.client.Cat.Indices.WithH("index"),
+	)
+	if err != nil {
+		return tenants, err
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return tenants, fmt.Errorf("elastic cat indices: %v", response.String())
+	}
+
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&indices)
+	if err != nil {
+		return tenants, err
+	}
+	for _, v := range indices {
+		// Tenant IDs have no underscores, the other indices of a book do
+		tenant := strings.TrimPrefix(v.Index, IndexName+"-")
+		if !strings.Contains(tenant, "_") {
+			tenants = append(tenants, tenant)
 		}
 	}
 
-	return utils.ErrContactNotFound
+	return tenants, nil
 }
@@ -1,18 +1,71 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"github.com/sgnl-05/contactService/utils"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
-func readFileContents() ([]Contact, error) {
-	var contacts []Contact
-	filePath := os.Getenv("LOCAL_FILENAME")
+// tenantsDir holds the files of the tenants other than the default one
+func tenantsDir() string {
+	return os.Getenv("LOCAL_FILENAME") + ".tenants"
+}
+
+// path returns where the file storage keeps a file of its tenant: the
+// contacts for an empty suffix, or a side file. The default tenant uses
+// LOCAL_FILENAME, or the variable env if set, and other tenants a file in
+// tenantsDir.
+func (s FileStorage) path(env string, suffix string) string {
+	if s.tenant != DefaultTenant {
+		return filepath.Join(tenantsDir(), s.tenant+".json"+suffix)
+	}
+	if filePath := os.Getenv(env); env != "" && filePath != "" {
+		return filePath
+	}
+
+	return os.Getenv("LOCAL_FILENAME") + suffix
+}
 
-	bytes, err := ioutil.ReadFile(filePath)
+// Tenant returns the book of a tenant, kept in its own files
+func (s FileStorage) Tenant(tenant string) StorageInterface {
+	if tenant != DefaultTenant {
+		// Failures show up when the book is written
+		_ = os.MkdirAll(tenantsDir(), 0755)
+	}
+
+	return FileStorage{UniquePhone: s.UniquePhone, tenant: tenant}
+}
+
+// Tenants lists the tenants with a book besides the default one
+func (s FileStorage) Tenants() ([]string, error) {
+	var tenants []string
+
+	entries, err := ioutil.ReadDir(tenantsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return tenants, nil
+	}
+	for _, v := range entries {
+		if tenant := strings.TrimSuffix(v.Name(), ".json"); tenant != v.Name() {
+			tenants = append(tenants, tenant)
+		}
+	}
+
+	return tenants, err
+}
+
+func (s FileStorage) readFileContents() ([]Contact, error) {
+	var contacts []Contact
+	bytes, err := ioutil.ReadFile(s.path("", ""))
+	if errors.Is(err, os.ErrNotExist) && s.tenant != DefaultTenant {
+		return contacts, nil
+	}
 	if err != nil {
 		return contacts, err
 	}
@@ -22,39 +75,115 @@ func readFileContents() ([]Contact, error) {
 	}
 
 	err = json.Unmarshal(bytes, &contacts)
-	return contacts, err
+	return normalizeContacts(contacts), err
 }
 
-func writeFileContents(contacts []Contact) error {
+func (s FileStorage) writeFileContents(contacts []Contact) error {
 	dataBytes, err := json.Marshal(contacts)
 	if err != nil {
 		return err
 	}
 
-	filePath := os.Getenv("LOCAL_FILENAME")
-	err = ioutil.WriteFile(filePath, dataBytes, 0644)
+	return replaceFile(s.path("", ""), dataBytes, 0644)
+}
+
+// replaceFile writes data to a new file renamed over path, so that readers
+// having path open keep its old contents
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
 	if err != nil {
 		return err
 	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Chmod(perm)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
 
-	return nil
+	return err
 }
 
 func (s FileStorage) List() ([]Contact, error) {
-	contactList, err := readFileContents()
+	contactList, err := s.readFileContents()
+
+	return activeContacts(contactList), err
+}
+
+// Walk opens the file, which is decoded one contact at a time instead of being
+// read whole. Writes replace the file, so the walk keeps reading the contacts
+// as they were when it was opened.
+func (s FileStorage) Walk() (WalkFunc, error) {
+	file, err := os.Open(s.path("", ""))
+	if errors.Is(err, os.ErrNotExist) && s.tenant != DefaultTenant {
+		return func(func(Contact) error) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func(fn func(Contact) error) error {
+		defer file.Close()
+		return walkFile(file, fn)
+	}, nil
+}
+
+// walkFile calls fn for every contact outside the trash of a contacts file
+// until it fails
+func walkFile(file io.Reader, fn func(Contact) error) error {
+	decoder := json.NewDecoder(file)
+	_, err := decoder.Token()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		var c Contact
+		err = decoder.Decode(&c)
+		if err != nil {
+			return err
+		}
+		if c.IsDeleted() {
+			continue
+		}
+		c.normalizeLists()
+		err = fn(c)
+		if err != nil {
+			return err
+		}
+	}
 
-	return contactList, err
+	return nil
 }
 
 func (s FileStorage) Add(c Contact) error {
-	contactList, err := readFileContents()
+	contactList, err := s.readFileContents()
 	if err != nil {
 		return err
 	}
 
+	c.normalizeLists()
+	if s.UniquePhone {
+		// The file is read and written whole, so the check is atomic
+		err = sharedPhone(c, contactList)
+		if err != nil {
+			return err
+		}
+	}
+	c.touchCreated()
 	contactList = append(contactList, c)
 
-	err = writeFileContents(contactList)
+	err = s.writeFileContents(contactList)
 	if err != nil {
 		return err
 	}
@@ -62,16 +191,62 @@ func (s FileStorage) Add(c Contact) error {
 	return nil
 }
 
+// Bulk applies operations with a single rewrite of the file. With atomic set
+// nothing is written unless all of them succeed.
+func (s FileStorage) Bulk(ops []BulkOperation, atomic bool) ([]BulkResult, error) {
+	contactList, err := s.readFileContents()
+	if err != nil {
+		return nil, err
+	}
+
+	position := make(map[string]int, len(contactList))
+	for i, c := range contactList {
+		position[c.ID] = i
+	}
+	get := func(id string) (Contact, bool) {
+		i, ok := position[id]
+		if !ok || contactList[i].IsDeleted() {
+			return Contact{}, false
+		}
+		return contactList[i], true
+	}
+	var checkPhones func(Contact) error
+	if s.UniquePhone {
+		checkPhones = func(c Contact) error {
+			return sharedPhone(c, contactList)
+		}
+	}
+
+	results := planBulk(ops, get, checkPhones)
+	writes := bulkWrites(results, atomic)
+	if atomic && BulkFailed(results) {
+		skipBulk(results)
+	}
+	if len(writes) == 0 {
+		return results, nil
+	}
+
+	for _, c := range writes {
+		if i, ok := position[c.ID]; ok {
+			contactList[i] = c
+		} else {
+			contactList = append(contactList, c)
+		}
+	}
+
+	return results, s.writeFileContents(contactList)
+}
+
 func (s FileStorage) Delete(id string) error {
-	contactList, err := readFileContents()
+	contactList, err := s.readFileContents()
 	if err != nil {
 		return err
 	} // Internal
 
 	for i := range contactList {
-		if contactList[i].ID == id {
-			contactList = append(contactList[:i], contactList[i+1:]...)
-			err = writeFileContents(contactList)
+		if contactList[i].ID == id && !contactList[i].IsDeleted() {
+			contactList[i].trash()
+			err = s.writeFileContents(contactList)
 			if err != nil {
 				return err
 			} // Internal
@@ -85,25 +260,15 @@ func (s FileStorage) Delete(id string) error {
 func (s FileStorage) Edit(e EditContact) (Contact, error) {
 	var res Contact
 
-	contactList, err := readFileContents()
+	contactList, err := s.readFileContents()
 	if err != nil {
 		return res, err
 	} // Internal
 
 	for i := range contactList {
-		if e.ID == contactList[i].ID {
-			if e.Name != "" {
-				contactList[i].Name = e.Name
-			}
-			if e.Phone != "" {
-				contactList[i].Phone = e.Phone
-			}
-			if e.Gender != "" {
-				contactList[i].Gender = e.Gender
-			}
-			if e.Country != "" {
-				contactList[i].Country = e.Country
-			}
+		if e.ID == contactList[i].ID && !contactList[i].IsDeleted() {
+			contactList[i].applyEdit(e)
+			contactList[i].UpdatedAt = now()
 			res = contactList[i]
 			break
 		}
@@ -113,7 +278,7 @@ func (s FileStorage) Edit(e EditContact) (Contact, error) {
 		return res, utils.ErrContactNotFound // Bad request
 	}
 
-	err = writeFileContents(contactList)
+	err = s.writeFileContents(contactList)
 	if err != nil {
 		return res, err
 	} // Internal
@@ -123,45 +288,32 @@ func (s FileStorage) Edit(e EditContact) (Contact, error) {
 
 func (s FileStorage) Filter(field string, value string) ([]Contact, error) {
 	var resultData []Contact
-	fullList, err := readFileContents()
+	fullList, err := s.readFileContents()
 	if err != nil {
 		return resultData, err
 	}
 
-	switch field {
-	case "name":
-		for _, v := range fullList {
-			if strings.Contains(
-				strings.ToLower(v.Name),
-				strings.ToLower(value),
-			) {
-				resultData = append(resultData, v)
-			}
+	for _, v := range activeContacts(fullList) {
+		ok, err := matchesFilter(v, field, value)
+		if err != nil {
+			return resultData, err
 		}
-		return resultData, nil
-	case "phone":
-		for _, v := range fullList {
-			if strings.Contains(
-				strings.ToLower(v.Phone),
-				strings.ToLower(value),
-			) {
-				resultData = append(resultData, v)
-			}
+		if ok {
+			resultData = append(resultData, v)
 		}
-		return resultData, nil
-	default:
-		return resultData, utils.ErrFilterWrongFormat
 	}
+
+	return resultData, nil
 }
 
 func (s FileStorage) ListFavs() ([]Contact, error) {
 	var resultData []Contact
-	contactList, err := readFileContents()
+	contactList, err := s.readFileContents()
 	if err != nil {
 		return resultData, err
 	}
 
-	for _, v := range contactList {
+	for _, v := range activeContacts(contactList) {
 		if v.Favorite == true {
 			resultData = append(resultData, v)
 		}
@@ -171,20 +323,21 @@ func (s FileStorage) ListFavs() ([]Contact, error) {
 }
 
 func (s FileStorage) ChangeFavs(id string, action string) error {
-	contactList, err := readFileContents()
+	contactList, err := s.readFileContents()
 	if err != nil {
 		return err
 	} // Internal
 
 	for i := range contactList {
-		if contactList[i].ID == id {
+		if contactList[i].ID == id && !contactList[i].IsDeleted() {
 			switch action {
 			case "add":
 				if contactList[i].Favorite == true {
 					return utils.ErrAlreadyFav
 				}
 				contactList[i].Favorite = true
-				err = writeFileContents(contactList)
+				contactList[i].UpdatedAt = now()
+				err = s.writeFileContents(contactList)
 				if err != nil {
 					return err
 				} // Internal
@@ -194,7 +347,8 @@ func (s FileStorage) ChangeFavs(id string, action string) error {
 					return utils.ErrAlreadyNotFav
 				}
 				contactList[i].Favorite = false
-				err = writeFileContents(contactList)
+				contactList[i].UpdatedAt = now()
+				err = s.writeFileContents(contactList)
 				if err != nil {
 					return err
 				} // Internal
@@ -207,3 +361,633 @@ func (s FileStorage) ChangeFavs(id string, action string) error {
 
 	return utils.ErrContactNotFound
 }
+
+func (s FileStorage) Get(id string) (Contact, error) {
+	contactList, err := s.readFileContents()
+	if err != nil {
+		return Contact{}, err
+	}
+
+	for _, v := range contactList {
+		if v.ID == id && !v.IsDeleted() {
+			return v, nil
+		}
+	}
+
+	return Contact{}, utils.ErrContactNotFound
+}
+
+func (s FileStorage) Update(c Contact) error {
+	contactList, err := s.readFileContents()
+	if err != nil {
+		return err
+	}
+
+	for i := range contactList {
+		if contactList[i].ID == c.ID && !contactList[i].IsDeleted() {
+			c.normalizeLists()
+			c.touchUpdated(contactList[i])
+			contactList[i] = c
+			return s.writeFileContents(contactList)
+		}
+	}
+
+	return utils.ErrContactNotFound
+}
+
+func (s FileStorage) Tags() ([]TagCount, error) {
+	contactList, err := s.readFileContents()
+	if err != nil {
+		return nil, err
+	}
+
+	return countTags(activeContacts(contactList)), nil
+}
+
+func (s FileStorage) ListTrash() ([]Contact, error) {
+	contactList, err := s.readFileContents()
+
+	return trashedContacts(contactList), err
+}
+
+func (s FileStorage) GetWithTrash(id string) (Contact, error) {
+	contactList, err := s.readFileContents()
+	if err != nil {
+		return Contact{}, err
+	}
+
+	for _, v := range contactList {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+
+	return Contact{}, utils.ErrContactNotFound
+}
+
+func (s FileStorage) Restore(id string) (Contact, error) {
+	contactList, err := s.readFileContents()
+	if err != nil {
+		return Contact{}, err
+	}
+
+	for i := range contactList {
+		if contactList[i].ID == id && contactList[i].IsDeleted() {
+			if s.UniquePhone {
+				err = sharedPhone(contactList[i], contactList)
+				if err != nil {
+					return Contact{}, err
+				}
+			}
+			contactList[i].restore()
+			return contactList[i], s.writeFileContents(contactList)
+		}
+	}
+
+	return Contact{}, utils.ErrNotInTrash
+}
+
+func (s FileStorage) Purge(id string) error {
+	contactList, err := s.readFileContents()
+	if err != nil {
+		return err
+	}
+
+	for i := range contactList {
+		if contactList[i].ID == id && contactList[i].IsDeleted() {
+			contactList = append(contactList[:i], contactList[i+1:]...)
+			return s.writeFileContents(contactList)
+		}
+	}
+
+	return utils.ErrNotInTrash
+}
+
+func (s FileStorage) PurgeDeleted(before time.Time) (int, error) {
+	contactList, err := s.readFileContents()
+	if err != nil {
+		return 0, err
+	}
+
+	kept := contactList[:0]
+	for _, v := range contactList {
+		if v.IsDeleted() && v.DeletedAt.Before(before) {
+			continue
+		}
+		kept = append(kept, v)
+	}
+
+	purged := len(contactList) - len(kept)
+	if purged == 0 {
+		return 0, nil
+	}
+
+	return purged, s.writeFileContents(kept)
+}
+
+// historyFilePath returns where the file storage keeps revisions, next to
+// the contacts unless HISTORY_FILENAME is set
+func (s FileStorage) historyFilePath() string {
+	return s.path("HISTORY_FILENAME", ".history")
+}
+
+// readHistory returns the revisions of a contact. The history file holds one
+// JSON revision per line so that recording a change only appends to it.
+func (s FileStorage) readHistory(id string) ([]Revision, error) {
+	var revisions []Revision
+
+	file, err := os.Open(s.historyFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return revisions, nil
+	}
+	if err != nil {
+		return revisions, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var r Revision
+		err = decoder.Decode(&r)
+		if err == io.EOF {
+			return revisions, nil
+		}
+		if err != nil {
+			return revisions, err
+		}
+		if r.ContactID == id {
+			revisions = append(revisions, r)
+		}
+	}
+}
+
+// historyVersions returns the number of revisions of every contact in the
+// history file
+func (s FileStorage) historyVersions() (map[string]int, error) {
+	versions := make(map[string]int)
+
+	file, err := os.Open(s.historyFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return versions, nil
+	}
+	if err != nil {
+		return versions, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var r struct {
+			ContactID string `json:"contact_id"`
+		}
+		err = decoder.Decode(&r)
+		if err == io.EOF {
+			return versions, nil
+		}
+		if err != nil {
+			return versions, err
+		}
+		versions[r.ContactID]++
+	}
+}
+
+// AddRevisions reads the history file once, however many revisions a bulk
+// request records, and appends them with a single write
+func (s FileStorage) AddRevisions(revisions []Revision) error {
+	versions, err := s.historyVersions()
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	for _, r := range revisions {
+		versions[r.ContactID]++
+		r.Version = versions[r.ContactID]
+		err = encoder.Encode(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return appendFile(s.historyFilePath(), b.Bytes())
+}
+
+// appendFile adds data to the end of a file, creating it if needed
+func appendFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func (s FileStorage) History(id string) ([]Revision, error) {
+	return s.readHistory(id)
+}
+
+func (s FileStorage) idempotencyFilePath() string {
+	return s.path("IDEMPOTENCY_FILENAME", ".idempotency")
+}
+
+// readIdempotency returns the stored responses by key, an absent file holds
+// none
+func (s FileStorage) readIdempotency() (map[string]IdempotencyRecord, error) {
+	records := make(map[string]IdempotencyRecord)
+
+	bytes, err := ioutil.ReadFile(s.idempotencyFilePath())
+	if errors.Is(err, os.ErrNotExist) || len(bytes) == 0 {
+		return records, nil
+	}
+	if err != nil {
+		return records, err
+	}
+
+	err = json.Unmarshal(bytes, &records)
+
+	return records, err
+}
+
+func (s FileStorage) writeIdempotency(records map[string]IdempotencyRecord) error {
+	dataBytes, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.idempotencyFilePath(), dataBytes, 0644)
+}
+
+func (s FileStorage) GetIdempotent(key string) (IdempotencyRecord, bool, error) {
+	records, err := s.readIdempotency()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	record, ok := records[key]
+
+	return record, ok, nil
+}
+
+func (s FileStorage) SaveIdempotent(record IdempotencyRecord) error {
+	records, err := s.readIdempotency()
+	if err != nil {
+		return err
+	}
+	records[record.Key] = record
+
+	return s.writeIdempotency(records)
+}
+
+func (s FileStorage) ExpireIdempotent(before time.Time) (int, error) {
+	records, err := s.readIdempotency()
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for key, record := range records {
+		if record.CreatedAt.Before(before) {
+			delete(records, key)
+			expired++
+		}
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+
+	return expired, s.writeIdempotency(records)
+}
+
+// changesFilePath returns where the file storage keeps the change log, next
+// to the contacts unless CHANGES_FILENAME is set
+func (s FileStorage) changesFilePath() string {
+	return s.path("CHANGES_FILENAME", ".changes")
+}
+
+// readChanges returns the change log. Like the history file, it holds one
+// JSON change per line.
+func (s FileStorage) readChanges() ([]Change, error) {
+	var changes []Change
+
+	file, err := os.Open(s.changesFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return changes, nil
+	}
+	if err != nil {
+		return changes, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		var c Change
+		err = decoder.Decode(&c)
+		if err == io.EOF {
+			return changes, nil
+		}
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, c)
+	}
+}
+
+func (s FileStorage) AppendChanges(changes []Change) ([]Change, error) {
+	_, latest, err := s.ChangeBounds()
+	if err != nil {
+		return nil, err
+	}
+
+	appended := make([]Change, 0, len(changes))
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	for _, c := range changes {
+		latest++
+		c.Seq = latest
+		err = encoder.Encode(c)
+		if err != nil {
+			return nil, err
+		}
+		appended = append(appended, c)
+	}
+
+	err = appendFile(s.changesFilePath(), b.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return appended, nil
+}
+
+func (s FileStorage) Changes(since int64, limit int) ([]Change, error) {
+	changes, err := s.readChanges()
+
+	return changesAfter(changes, since, limit), err
+}
+
+func (s FileStorage) ChangeBounds() (int64, int64, error) {
+	changes, err := s.readChanges()
+	oldest, latest := changeBounds(changes)
+
+	return oldest, latest, err
+}
+
+func (s FileStorage) ExpireChanges(before time.Time) (int, error) {
+	changes, err := s.readChanges()
+	if err != nil {
+		return 0, err
+	}
+
+	kept, expired := expireChanges(changes, before)
+	if expired == 0 {
+		return 0, nil
+	}
+
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	for _, v := range kept {
+		err = encoder.Encode(v)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return expired, ioutil.WriteFile(s.changesFilePath(), []byte(b.String()), 0644)
+}
+
+// webhooksFilePath returns where the file storage keeps webhooks and their
+// dead letters, next to the contacts unless WEBHOOKS_FILENAME is set
+func (s FileStorage) webhooksFilePath() string {
+	return s.path("WEBHOOKS_FILENAME", ".webhooks")
+}
+
+type webhooksFile struct {
+	Webhooks    map[string]Webhook    `json:"webhooks"`
+	DeadLetters map[string]DeadLetter `json:"dead_letters"`
+}
+
+// readWebhooks returns the webhooks file, an absent file holds none
+func (s FileStorage) readWebhooks() (webhooksFile, error) {
+	contents := webhooksFile{
+		Webhooks:    make(map[string]Webhook),
+		DeadLetters: make(map[string]DeadLetter),
+	}
+
+	bytes, err := ioutil.ReadFile(s.webhooksFilePath())
+	if errors.Is(err, os.ErrNotExist) || len(bytes) == 0 {
+		return contents, nil
+	}
+	if err != nil {
+		return contents, err
+	}
+
+	err = json.Unmarshal(bytes, &contents)
+	if contents.Webhooks == nil {
+		contents.Webhooks = make(map[string]Webhook)
+	}
+	if contents.DeadLetters == nil {
+		contents.DeadLetters = make(map[string]DeadLetter)
+	}
+
+	return contents, err
+}
+
+func (s FileStorage) writeWebhooks(contents webhooksFile) error {
+	dataBytes, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.webhooksFilePath(), dataBytes, 0600)
+}
+
+func (s FileStorage) ListWebhooks() ([]Webhook, error) {
+	contents, err := s.readWebhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	return MemoryStorage{Webhooks: contents.Webhooks}.ListWebhooks()
+}
+
+func (s FileStorage) SaveWebhook(w Webhook) error {
+	contents, err := s.readWebhooks()
+	if err != nil {
+		return err
+	}
+	contents.Webhooks[w.ID] = w
+
+	return s.writeWebhooks(contents)
+}
+
+func (s FileStorage) DeleteWebhook(id string) error {
+	contents, err := s.readWebhooks()
+	if err != nil {
+		return err
+	}
+	if _, ok := contents.Webhooks[id]; !ok {
+		return utils.ErrWebhookNotFound
+	}
+	delete(contents.Webhooks, id)
+
+	return s.writeWebhooks(contents)
+}
+
+func (s FileStorage) ListDeadLetters() ([]DeadLetter, error) {
+	contents, err := s.readWebhooks()
+	if err != nil {
+		return nil, err
+	}
+
+	return MemoryStorage{DeadLetters: contents.DeadLetters}.ListDeadLetters()
+}
+
+func (s FileStorage) SaveDeadLetter(d DeadLetter) error {
+	contents, err := s.readWebhooks()
+	if err != nil {
+		return err
+	}
+	contents.DeadLetters[d.ID] = d
+
+	return s.writeWebhooks(contents)
+}
+
+func (s FileStorage) DeleteDeadLetter(id string) error {
+	contents, err := s.readWebhooks()
+	if err != nil {
+		return err
+	}
+	if _, ok := contents.DeadLetters[id]; !ok {
+		return utils.ErrDeadLetterNotFound
+	}
+	delete(contents.DeadLetters, id)
+
+	return s.writeWebhooks(contents)
+}
+
+// apiKeysFilePath returns where the file storage keeps API keys, next to the
+// contacts unless API_KEYS_FILENAME is set
+func apiKeysFilePath() string {
+	if filePath := os.Getenv("API_KEYS_FILENAME"); filePath != "" {
+		return filePath
+	}
+
+	return os.Getenv("LOCAL_FILENAME") + ".keys"
+}
+
+// readAPIKeys returns the API keys by ID, an absent file holds none
+func readAPIKeys() (map[string]APIKey, error) {
+	keys := make(map[string]APIKey)
+
+	bytes, err := ioutil.ReadFile(apiKeysFilePath())
+	if errors.Is(err, os.ErrNotExist) || len(bytes) == 0 {
+		return keys, nil
+	}
+	if err != nil {
+		return keys, err
+	}
+
+	err = json.Unmarshal(bytes, &keys)
+
+	return keys, err
+}
+
+func (s FileStorage) ListAPIKeys() ([]APIKey, error) {
+	keys, err := readAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	return MemoryStorage{APIKeys: keys}.ListAPIKeys()
+}
+
+func (s FileStorage) GetAPIKey(id string) (APIKey, error) {
+	keys, err := readAPIKeys()
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	return MemoryStorage{APIKeys: keys}.GetAPIKey(id)
+}
+
+func (s FileStorage) SaveAPIKey(k APIKey) error {
+	keys, err := readAPIKeys()
+	if err != nil {
+		return err
+	}
+	keys[k.ID] = k
+
+	dataBytes, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(apiKeysFilePath(), dataBytes, 0600)
+}
+
+// addressBooksFilePath returns where the file storage keeps the shared
+// address books, next to the contacts unless ADDRESS_BOOKS_FILENAME is set
+func addressBooksFilePath() string {
+	if filePath := os.Getenv("ADDRESS_BOOKS_FILENAME"); filePath != "" {
+		return filePath
+	}
+
+	return os.Getenv("LOCAL_FILENAME") + ".books"
+}
+
+// readAddressBooks returns the shared address books by ID, an absent file
+// holds none
+func readAddressBooks() (map[string]AddressBook, error) {
+	books := make(map[string]AddressBook)
+
+	bytes, err := ioutil.ReadFile(addressBooksFilePath())
+	if errors.Is(err, os.ErrNotExist) || len(bytes) == 0 {
+		return books, nil
+	}
+	if err != nil {
+		return books, err
+	}
+
+	err = json.Unmarshal(bytes, &books)
+
+	return books, err
+}
+
+func (s FileStorage) ListAddressBooks() ([]AddressBook, error) {
+	books, err := readAddressBooks()
+	if err != nil {
+		return nil, err
+	}
+
+	return MemoryStorage{AddressBooks: books}.ListAddressBooks()
+}
+
+func (s FileStorage) GetAddressBook(id string) (AddressBook, error) {
+	books, err := readAddressBooks()
+	if err != nil {
+		return AddressBook{}, err
+	}
+
+	return MemoryStorage{AddressBooks: books}.GetAddressBook(id)
+}
+
+func (s FileStorage) SaveAddressBook(b AddressBook) error {
+	books, err := readAddressBooks()
+	if err != nil {
+		return err
+	}
+	books[b.ID] = b
+
+	dataBytes, err := json.Marshal(books)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(addressBooksFilePath(), dataBytes, 0644)
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestFileStorage(t *testing.T) FileStorage {
+	t.Setenv("LOCAL_FILENAME", filepath.Join(t.TempDir(), "contacts.json"))
+	for _, env := range []string{"HISTORY_FILENAME", "CHANGES_FILENAME", "IDEMPOTENCY_FILENAME"} {
+		t.Setenv(env, "")
+	}
+
+	return FileStorage{}
+}
+
+func TestFileAddRevisionsNumbersEachContact(t *testing.T) {
+	s := newTestFileStorage(t)
+	ann := &Contact{ID: "ann", Name: "Ann"}
+	bob := &Contact{ID: "bob", Name: "Bob"}
+
+	batches := [][]Revision{
+		{NewRevision(ActionCreate, "test", nil, ann)},
+		{NewRevision(ActionUpdate, "test", ann, ann), NewRevision(ActionCreate, "test", nil, bob), NewRevision(ActionDelete, "test", ann, ann)},
+	}
+	for _, batch := range batches {
+		err := s.AddRevisions(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, want := range map[string][]string{"ann": {ActionCreate, ActionUpdate, ActionDelete}, "bob": {ActionCreate}} {
+		revisions, err := s.History(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != len(want) {
+			t.Fatalf("%v has %v revisions, want %v", id, len(revisions), len(want))
+		}
+		for i, r := range revisions {
+			if r.Version != i+1 || r.Action != want[i] {
+				t.Errorf("%v revision %v is version %v %v, want version %v %v", id, i, r.Version, r.Action, i+1, want[i])
+			}
+		}
+	}
+}
+
+func TestFileAppendChangesContinuesSequence(t *testing.T) {
+	s := newTestFileStorage(t)
+	ann := &Contact{ID: "ann", Name: "Ann"}
+	change := NewChange(NewRevision(ActionCreate, "test", nil, ann))
+
+	var seqs []int64
+	for _, batch := range [][]Change{{change}, {change, change}, nil} {
+		appended, err := s.AppendChanges(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range appended {
+			seqs = append(seqs, c.Seq)
+		}
+	}
+
+	changes, err := s.Changes(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 3 || len(changes) != 3 {
+		t.Fatalf("appended %v, stored %v changes", seqs, len(changes))
+	}
+	for i := range changes {
+		if seqs[i] != int64(i+1) || changes[i].Seq != seqs[i] {
+			t.Errorf("change %v: appended as %v, stored as %v", i, seqs[i], changes[i].Seq)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Revision actions
+const (
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionFavorite = "favorite"
+	ActionDelete   = "delete"
+	ActionRestore  = "restore"
+	ActionPurge    = "purge"
+	ActionRevert   = "revert"
+	ActionMerge    = "merge"
+)
+
+// Revision records a single change of a contact. Before is empty for
+// created contacts, After for purged ones. Version counts the revisions of
+// a contact starting at 1 and is assigned by the storage.
+type Revision struct {
+	ContactID string        `json:"contact_id"`
+	Version   int           `json:"version"`
+	Action    string        `json:"action"`
+	Timestamp time.Time     `json:"timestamp"`
+	Actor     string        `json:"actor"`
+	Before    *Contact      `json:"before,omitempty"`
+	After     *Contact      `json:"after,omitempty"`
+	Diff      []FieldChange `json:"diff,omitempty"`
+}
+
+// FieldChange is a single field differing between two versions of a contact
+type FieldChange struct {
+	Field  string          `json:"field"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// NewRevision describes the change from before to after. Either may be nil.
+func NewRevision(action string, actor string, before *Contact, after *Contact) Revision {
+	rev := Revision{
+		Action:    action,
+		Timestamp: now(),
+		Actor:     actor,
+		Before:    before,
+		After:     after,
+		Diff:      diffContacts(before, after),
+	}
+	if after != nil {
+		rev.ContactID = after.ID
+	} else if before != nil {
+		rev.ContactID = before.ID
+	}
+
+	return rev
+}
+
+// diffContacts compares the JSON form of two contacts field by field,
+// leaving out updated_at which changes on every write
+func diffContacts(before *Contact, after *Contact) []FieldChange {
+	fieldsBefore := contactFields(before)
+	fieldsAfter := contactFields(after)
+
+	var names []string
+	for k := range fieldsBefore {
+		names = append(names, k)
+	}
+	for k := range fieldsAfter {
+		if _, ok := fieldsBefore[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var res []FieldChange
+	for _, v := range names {
+		if v == "updated_at" || bytes.Equal(fieldsBefore[v], fieldsAfter[v]) {
+			continue
+		}
+		res = append(res, FieldChange{Field: v, Before: fieldsBefore[v], After: fieldsAfter[v]})
+	}
+
+	return res
+}
+
+func contactFields(c *Contact) map[string]json.RawMessage {
+	res := make(map[string]json.RawMessage)
+	if c == nil {
+		return res
+	}
+
+	contactBytes, err := json.Marshal(c)
+	if err != nil {
+		return res
+	}
+	_ = json.Unmarshal(contactBytes, &res)
+
+	return res
+}
+
+// cloneContact deep-copies a contact snapshot
+func cloneContact(c *Contact) *Contact {
+	if c == nil {
+		return nil
+	}
+
+	contactBytes, err := json.Marshal(c)
+	if err != nil {
+		return c
+	}
+	var res Contact
+	if json.Unmarshal(contactBytes, &res) != nil {
+		return c
+	}
+
+	return &res
+}
+
+// StateAt returns the contact as it was at t according to its revisions,
+// which must be sorted by version. It is nil when the contact did not exist
+// or was in the trash at that time.
+func StateAt(revisions []Revision, t time.Time) *Contact {
+	var state *Contact
+	for _, v := range revisions {
+		if v.Timestamp.After(t) {
+			break
+		}
+		state = v.After
+	}
+	if state != nil && state.IsDeleted() {
+		return nil
+	}
+
+	return state
+}
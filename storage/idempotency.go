@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// IdempotencyRecord is the response to a request carrying an Idempotency-Key,
+// replayed when the request is retried. Fingerprint identifies the request so
+// that a key reused for a different one is detected.
+type IdempotencyRecord struct {
+	Key         string    `json:"key"`
+	Fingerprint string    `json:"fingerprint"`
+	Status      int       `json:"status"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RequestFingerprint hashes the parts of a request that make it the same
+// request
+func RequestFingerprint(method string, uri string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + uri + "\n"))
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// idempotencyDocID turns a key of any length and alphabet into a document ID
+func idempotencyDocID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// now is the clock for server-side timestamps
+var now = func() time.Time {
+	return time.Now().UTC()
+}
+
+// touchCreated stamps a contact about to be added
+func (c *Contact) touchCreated() {
+	c.CreatedAt = now()
+	c.UpdatedAt = c.CreatedAt
+}
+
+// touchUpdated stamps a changed contact, keeping the creation data of the
+// stored version
+func (c *Contact) touchUpdated(stored Contact) {
+	c.CreatedAt = stored.CreatedAt
+	c.CreatedBy = stored.CreatedBy
+	c.DeletedAt = stored.DeletedAt
+	c.UpdatedAt = now()
+}
+
+// IsDeleted reports whether the contact is in the trash
+func (c Contact) IsDeleted() bool {
+	return c.DeletedAt != nil
+}
+
+// trash moves a contact into the trash
+func (c *Contact) trash() {
+	t := now()
+	c.DeletedAt = &t
+}
+
+// restore takes a contact out of the trash
+func (c *Contact) restore() {
+	c.DeletedAt = nil
+	c.UpdatedAt = now()
+}
+
+// activeContacts drops trashed contacts from a list
+func activeContacts(contacts []Contact) []Contact {
+	res := contacts[:0:0]
+	for _, c := range contacts {
+		if !c.IsDeleted() {
+			res = append(res, c)
+		}
+	}
+
+	return res
+}
+
+// trashedContacts keeps only the trashed contacts of a list
+func trashedContacts(contacts []Contact) []Contact {
+	res := contacts[:0:0]
+	for _, c := range contacts {
+		if c.IsDeleted() {
+			res = append(res, c)
+		}
+	}
+
+	return res
+}
+
+// IsEmpty reports whether the options leave a list unchanged
+func (o ListOptions) IsEmpty() bool {
+	return o.Sort == "" && o.Order == "" && o.UpdatedSince.IsZero() && o.CreatedSince.IsZero()
+}
+
+// Validate checks the sort field and order
+func (o ListOptions) Validate() error {
+	switch o.Sort {
+	case "", "name", "created_at", "updated_at":
+	default:
+		return utils.ErrListOptionsWrongFormat
+	}
+
+	switch o.Order {
+	case "", "asc", "desc":
+	default:
+		return utils.ErrListOptionsWrongFormat
+	}
+
+	return nil
+}
+
+// Apply drops the contacts outside the time bounds and sorts the rest. Lists
+// without a sort field keep their order.
+func (o ListOptions) Apply(contacts []Contact) []Contact {
+	res := contacts[:0:0]
+	for _, c := range contacts {
+		if !o.UpdatedSince.IsZero() && c.UpdatedAt.Before(o.UpdatedSince) {
+			continue
+		}
+		if !o.CreatedSince.IsZero() && c.CreatedAt.Before(o.CreatedSince) {
+			continue
+		}
+		res = append(res, c)
+	}
+
+	var less func(a, b Contact) bool
+	switch o.Sort {
+	case "name":
+		less = func(a, b Contact) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) }
+	case "created_at":
+		less = func(a, b Contact) bool { return a.CreatedAt.Before(b.CreatedAt) }
+	case "updated_at":
+		less = func(a, b Contact) bool { return a.UpdatedAt.Before(b.UpdatedAt) }
+	default:
+		return res
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if o.Order == "desc" {
+			return less(res[j], res[i])
+		}
+		return less(res[i], res[j])
+	})
+
+	return res
+}
@@ -2,95 +2,161 @@ package storage
 
 import (
 	"github.com/sgnl-05/contactService/utils"
-	"strings"
+	"sort"
+	"time"
 )
 
+// active returns a stored contact unless it is unknown or in the trash
+func (s MemoryStorage) active(id string) (*Contact, bool) {
+	c, ok := s.ContactBook[id]
+	if !ok || c.IsDeleted() {
+		return nil, false
+	}
+
+	return c, true
+}
+
+// checkPhones fails with a DuplicateError when another active contact has one
+// of c's numbers. Index entries left by changed or deleted contacts are
+// repaired on the way.
+func (s MemoryStorage) checkPhones(c Contact) error {
+	for _, number := range c.PhoneNumbers() {
+		digits := phoneDigits(number)
+		id, ok := s.PhoneIndex[digits]
+		if !ok || id == c.ID {
+			continue
+		}
+		if other, ok := s.active(id); ok && other.hasPhone(number) {
+			return utils.DuplicateError{Field: "phone", Value: number, ExistingID: id}
+		}
+
+		delete(s.PhoneIndex, digits)
+		for _, other := range s.ContactBook {
+			if other.ID != c.ID && !other.IsDeleted() && other.hasPhone(number) {
+				s.PhoneIndex[digits] = other.ID
+				return utils.DuplicateError{Field: "phone", Value: number, ExistingID: other.ID}
+			}
+		}
+	}
+
+	return nil
+}
+
+// indexPhones points index entries of c's numbers to c unless another active
+// contact already has them
+func (s MemoryStorage) indexPhones(c Contact) {
+	if !s.UniquePhone {
+		return
+	}
+	for _, number := range c.PhoneNumbers() {
+		digits := phoneDigits(number)
+		if id, ok := s.PhoneIndex[digits]; ok && id != c.ID {
+			if other, ok := s.active(id); ok && other.hasPhone(number) {
+				continue
+			}
+		}
+		s.PhoneIndex[digits] = c.ID
+	}
+}
+
 func (s MemoryStorage) List() ([]Contact, error) {
 	var jsonContacts []Contact
 
 	for _, v := range s.ContactBook {
-		jsonContacts = append(jsonContacts, *v)
+		if !v.IsDeleted() {
+			jsonContacts = append(jsonContacts, *v)
+		}
 	}
 
 	return jsonContacts, nil
 }
 
+// Walk calls fn for every contact outside the trash until it fails
+// Walk copies the contacts outside the trash, the map cannot be read once the
+// lock is released
+func (s MemoryStorage) Walk() (WalkFunc, error) {
+	contacts := make([]Contact, 0, len(s.ContactBook))
+	for _, v := range s.ContactBook {
+		if !v.IsDeleted() {
+			contacts = append(contacts, *cloneContact(v))
+		}
+	}
+
+	return func(fn func(Contact) error) error {
+		for _, c := range contacts {
+			err := fn(c)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
 func (s MemoryStorage) Add(c Contact) error {
+	c.normalizeLists()
+	if s.UniquePhone {
+		err := s.checkPhones(c)
+		if err != nil {
+			return err
+		}
+	}
+	c.touchCreated()
 	s.ContactBook[c.ID] = &c
+	s.indexPhones(c)
 
 	return nil
 }
 
 func (s MemoryStorage) Delete(id string) error {
-	for k := range s.ContactBook {
-		if k == id {
-			delete(s.ContactBook, id)
-			return nil
-		}
+	c, ok := s.active(id)
+	if !ok {
+		return utils.ErrContactNotFound
 	}
+	c.trash()
 
-	return utils.ErrContactNotFound
+	return nil
 }
 
 func (s MemoryStorage) Edit(e EditContact) (Contact, error) {
 	var res Contact
 
-	for k, v := range s.ContactBook {
-		if k == e.ID {
-			if e.Name != "" {
-				v.Name = e.Name
-			}
-			if e.Phone != "" {
-				v.Phone = e.Phone
-			}
-			if e.Gender != "" {
-				v.Gender = e.Gender
-			}
-			if e.Country != "" {
-				v.Country = e.Country
-			}
-			res = *v
-			return res, nil
-		}
+	v, ok := s.active(e.ID)
+	if !ok {
+		return res, utils.ErrContactNotFound
 	}
+	v.applyEdit(e)
+	v.UpdatedAt = now()
+	res = *v
+	s.indexPhones(res)
 
-	return res, utils.ErrContactNotFound
+	return res, nil
 }
 
 func (s MemoryStorage) Filter(field string, value string) ([]Contact, error) {
 	var resultData []Contact
 
-	switch field {
-	case "name":
-		for _, v := range s.ContactBook {
-			if strings.Contains(
-				strings.ToLower(v.Name),
-				strings.ToLower(value),
-			) {
-				resultData = append(resultData, *v)
-			}
+	for _, v := range s.ContactBook {
+		if v.IsDeleted() {
+			continue
 		}
-		return resultData, nil
-	case "phone":
-		for _, v := range s.ContactBook {
-			if strings.Contains(
-				strings.ToLower(v.Phone),
-				strings.ToLower(value),
-			) {
-				resultData = append(resultData, *v)
-			}
+		ok, err := matchesFilter(*v, field, value)
+		if err != nil {
+			return resultData, err
+		}
+		if ok {
+			resultData = append(resultData, *v)
 		}
-		return resultData, nil
-	default:
-		return resultData, utils.ErrFilterWrongFormat
 	}
+
+	return resultData, nil
 }
 
 func (s MemoryStorage) ListFavs() ([]Contact, error) {
 	var resultData []Contact
 
 	for _, v := range s.ContactBook {
-		if v.Favorite == true {
+		if v.Favorite == true && !v.IsDeleted() {
 			resultData = append(resultData, *v)
 		}
 	}
@@ -99,24 +165,316 @@ func (s MemoryStorage) ListFavs() ([]Contact, error) {
 }
 
 func (s MemoryStorage) ChangeFavs(id string, action string) error {
-	if _, ok := s.ContactBook[id]; !ok {
+	c, ok := s.active(id)
+	if !ok {
 		return utils.ErrContactNotFound
 	}
 
 	switch action {
 	case "add":
-		if s.ContactBook[id].Favorite == true {
+		if c.Favorite == true {
 			return utils.ErrAlreadyFav
 		}
-		s.ContactBook[id].Favorite = true
+		c.Favorite = true
+		c.UpdatedAt = now()
 	case "remove":
-		if s.ContactBook[id].Favorite == false {
+		if c.Favorite == false {
 			return utils.ErrAlreadyNotFav
 		}
-		s.ContactBook[id].Favorite = false
+		c.Favorite = false
+		c.UpdatedAt = now()
 	default:
 		return utils.ErrFavWrongFormat
 	}
 
 	return nil
 }
+
+func (s MemoryStorage) Get(id string) (Contact, error) {
+	c, ok := s.active(id)
+	if !ok {
+		return Contact{}, utils.ErrContactNotFound
+	}
+
+	return *c, nil
+}
+
+func (s MemoryStorage) Update(c Contact) error {
+	stored, ok := s.active(c.ID)
+	if !ok {
+		return utils.ErrContactNotFound
+	}
+	c.normalizeLists()
+	c.touchUpdated(*stored)
+	s.ContactBook[c.ID] = &c
+	s.indexPhones(c)
+
+	return nil
+}
+
+// Bulk applies operations in one go. With atomic set nothing is stored unless
+// all of them succeed.
+func (s MemoryStorage) Bulk(ops []BulkOperation, atomic bool) ([]BulkResult, error) {
+	get := func(id string) (Contact, bool) {
+		c, ok := s.active(id)
+		if !ok {
+			return Contact{}, false
+		}
+		return *c, true
+	}
+	var checkPhones func(Contact) error
+	if s.UniquePhone {
+		checkPhones = s.checkPhones
+	}
+
+	results := planBulk(ops, get, checkPhones)
+	for _, c := range bulkWrites(results, atomic) {
+		c := c
+		s.ContactBook[c.ID] = &c
+		s.indexPhones(c)
+	}
+	if atomic && BulkFailed(results) {
+		skipBulk(results)
+	}
+
+	return results, nil
+}
+
+func (s MemoryStorage) Tags() ([]TagCount, error) {
+	contacts, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	return countTags(contacts), nil
+}
+
+func (s MemoryStorage) ListTrash() ([]Contact, error) {
+	var resultData []Contact
+
+	for _, v := range s.ContactBook {
+		if v.IsDeleted() {
+			resultData = append(resultData, *v)
+		}
+	}
+
+	return resultData, nil
+}
+
+func (s MemoryStorage) GetWithTrash(id string) (Contact, error) {
+	c, ok := s.ContactBook[id]
+	if !ok {
+		return Contact{}, utils.ErrContactNotFound
+	}
+
+	return *c, nil
+}
+
+func (s MemoryStorage) Restore(id string) (Contact, error) {
+	c, ok := s.ContactBook[id]
+	if !ok || !c.IsDeleted() {
+		return Contact{}, utils.ErrNotInTrash
+	}
+	if s.UniquePhone {
+		err := s.checkPhones(*c)
+		if err != nil {
+			return Contact{}, err
+		}
+	}
+	c.restore()
+	s.indexPhones(*c)
+
+	return *c, nil
+}
+
+func (s MemoryStorage) Purge(id string) error {
+	c, ok := s.ContactBook[id]
+	if !ok || !c.IsDeleted() {
+		return utils.ErrNotInTrash
+	}
+	delete(s.ContactBook, id)
+
+	return nil
+}
+
+func (s MemoryStorage) PurgeDeleted(before time.Time) (int, error) {
+	purged := 0
+	for k, v := range s.ContactBook {
+		if v.IsDeleted() && v.DeletedAt.Before(before) {
+			delete(s.ContactBook, k)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (s MemoryStorage) AddRevisions(revisions []Revision) error {
+	for _, r := range revisions {
+		// Snapshots must not share lists with contacts changed later
+		r.Before = cloneContact(r.Before)
+		r.After = cloneContact(r.After)
+		r.Version = len(s.Revisions[r.ContactID]) + 1
+		s.Revisions[r.ContactID] = append(s.Revisions[r.ContactID], r)
+	}
+
+	return nil
+}
+
+func (s MemoryStorage) History(id string) ([]Revision, error) {
+	return append([]Revision(nil), s.Revisions[id]...), nil
+}
+
+func (s MemoryStorage) GetIdempotent(key string) (IdempotencyRecord, bool, error) {
+	record, ok := s.Idempotency[key]
+
+	return record, ok, nil
+}
+
+func (s MemoryStorage) SaveIdempotent(record IdempotencyRecord) error {
+	s.Idempotency[record.Key] = record
+
+	return nil
+}
+
+func (s MemoryStorage) ExpireIdempotent(before time.Time) (int, error) {
+	expired := 0
+	for key, record := range s.Idempotency {
+		if record.CreatedAt.Before(before) {
+			delete(s.Idempotency, key)
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+func (s MemoryStorage) AppendChanges(changes []Change) ([]Change, error) {
+	appended := make([]Change, 0, len(changes))
+	for _, c := range changes {
+		s.ChangeLog.Seq++
+		c.Seq = s.ChangeLog.Seq
+		c.Contact = cloneContact(c.Contact)
+		s.ChangeLog.Entries = append(s.ChangeLog.Entries, c)
+		appended = append(appended, c)
+	}
+
+	return appended, nil
+}
+
+func (s MemoryStorage) Changes(since int64, limit int) ([]Change, error) {
+	return changesAfter(s.ChangeLog.Entries, since, limit), nil
+}
+
+func (s MemoryStorage) ChangeBounds() (int64, int64, error) {
+	oldest, latest := changeBounds(s.ChangeLog.Entries)
+
+	return oldest, latest, nil
+}
+
+func (s MemoryStorage) ExpireChanges(before time.Time) (int, error) {
+	var expired int
+	s.ChangeLog.Entries, expired = expireChanges(s.ChangeLog.Entries, before)
+
+	return expired, nil
+}
+
+func (s MemoryStorage) ListWebhooks() ([]Webhook, error) {
+	var res []Webhook
+	for _, v := range s.Webhooks {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+
+	return res, nil
+}
+
+func (s MemoryStorage) SaveWebhook(w Webhook) error {
+	s.Webhooks[w.ID] = w
+
+	return nil
+}
+
+func (s MemoryStorage) DeleteWebhook(id string) error {
+	if _, ok := s.Webhooks[id]; !ok {
+		return utils.ErrWebhookNotFound
+	}
+	delete(s.Webhooks, id)
+
+	return nil
+}
+
+func (s MemoryStorage) ListDeadLetters() ([]DeadLetter, error) {
+	var res []DeadLetter
+	for _, v := range s.DeadLetters {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].FailedAt.Before(res[j].FailedAt) })
+
+	return res, nil
+}
+
+func (s MemoryStorage) SaveDeadLetter(d DeadLetter) error {
+	s.DeadLetters[d.ID] = d
+
+	return nil
+}
+
+func (s MemoryStorage) DeleteDeadLetter(id string) error {
+	if _, ok := s.DeadLetters[id]; !ok {
+		return utils.ErrDeadLetterNotFound
+	}
+	delete(s.DeadLetters, id)
+
+	return nil
+}
+
+func (s MemoryStorage) ListAPIKeys() ([]APIKey, error) {
+	var res []APIKey
+	for _, v := range s.APIKeys {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+
+	return res, nil
+}
+
+func (s MemoryStorage) GetAPIKey(id string) (APIKey, error) {
+	k, ok := s.APIKeys[id]
+	if !ok {
+		return k, utils.ErrAPIKeyNotFound
+	}
+
+	return k, nil
+}
+
+func (s MemoryStorage) SaveAPIKey(k APIKey) error {
+	s.APIKeys[k.ID] = k
+
+	return nil
+}
+
+func (s MemoryStorage) ListAddressBooks() ([]AddressBook, error) {
+	var res []AddressBook
+	for _, v := range s.AddressBooks {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+
+	return res, nil
+}
+
+func (s MemoryStorage) GetAddressBook(id string) (AddressBook, error) {
+	b, ok := s.AddressBooks[id]
+	if !ok {
+		return b, utils.ErrBookNotFound
+	}
+
+	return b, nil
+}
+
+func (s MemoryStorage) SaveAddressBook(b AddressBook) error {
+	s.AddressBooks[b.ID] = b
+
+	return nil
+}
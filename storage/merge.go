@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// MergeRequest combines contacts into the one with ID Primary. Resolve picks,
+// per field, the ID of the contact whose value wins. Fields not listed keep
+// the primary's value or, when it is empty, the first non-empty one in IDs
+// order.
+type MergeRequest struct {
+	IDs     []string          `json:"ids"`
+	Primary string            `json:"primary"`
+	Resolve map[string]string `json:"resolve"`
+}
+
+// MergeFields are the single-value fields a merge can resolve
+var MergeFields = []string{"name", "gender", "country", "favorite"}
+
+// Validate checks that the request names at least two distinct contacts and
+// only resolves known fields from contacts being merged
+func (m *MergeRequest) Validate() []utils.FieldError {
+	var fields []utils.FieldError
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, v := range m.IDs {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			ids = append(ids, v)
+		}
+	}
+	m.IDs = ids
+
+	if len(m.IDs) < 2 {
+		fields = append(fields, utils.FieldError{Field: "ids", Code: utils.CodeTooShort, Message: "at least two contacts are needed for a merge"})
+	}
+	if m.Primary == "" && len(m.IDs) > 0 {
+		m.Primary = m.IDs[0]
+	}
+	if !seen[m.Primary] {
+		fields = append(fields, utils.FieldError{Field: "primary", Code: utils.CodeInvalidValue, Message: "primary must be one of ids"})
+	}
+
+	for field, id := range m.Resolve {
+		known := false
+		for _, v := range MergeFields {
+			if v == field {
+				known = true
+			}
+		}
+		if !known {
+			fields = append(fields, utils.FieldError{Field: "resolve." + field, Code: utils.CodeInvalidValue, Message: "only name, gender, country and favorite can be resolved"})
+			continue
+		}
+		if !seen[id] {
+			fields = append(fields, utils.FieldError{Field: "resolve." + field, Code: utils.CodeInvalidValue, Message: "resolved values must come from one of ids"})
+		}
+	}
+
+	return fields
+}
+
+// Merge combines contacts, given in the order of m.IDs, into the primary one.
+// Lists are joined without duplicates and the IDs of the other contacts
+// become aliases of the result.
+func (m MergeRequest) Merge(contacts []Contact) Contact {
+	byID := make(map[string]Contact, len(contacts))
+	var res Contact
+	for _, c := range contacts {
+		byID[c.ID] = c
+		if c.ID == m.Primary {
+			res = c
+		}
+	}
+
+	// pick returns the contact whose value of field wins
+	pick := func(field string, empty func(c Contact) bool) Contact {
+		if id, ok := m.Resolve[field]; ok {
+			return byID[id]
+		}
+		if !empty(res) {
+			return res
+		}
+		for _, c := range contacts {
+			if !empty(c) {
+				return c
+			}
+		}
+		return res
+	}
+
+	res.Name = pick("name", func(c Contact) bool { return c.Name == "" }).Name
+	gender := pick("gender", func(c Contact) bool { return c.Gender == "" })
+	res.Gender = gender.Gender
+	res.setProvenance("gender", gender.Provenance["gender"])
+	country := pick("country", func(c Contact) bool { return c.Country == "" })
+	res.Country = country.Country
+	res.setProvenance("country", country.Provenance["country"])
+	if _, ok := m.Resolve["favorite"]; ok {
+		res.Favorite = byID[m.Resolve["favorite"]].Favorite
+	} else {
+		for _, c := range contacts {
+			res.Favorite = res.Favorite || c.Favorite
+		}
+	}
+
+	// The map is shared with the stored contact
+	custom := res.Custom
+	res.Custom = nil
+	for k, v := range custom {
+		if res.Custom == nil {
+			res.Custom = make(map[string]string)
+		}
+		res.Custom[k] = v
+	}
+
+	for _, c := range contacts {
+		if c.ID == res.ID {
+			continue
+		}
+		res.Phones = mergePhones(res.Phones, c.Phones)
+		res.Emails = mergeEmails(res.Emails, c.Emails)
+		res.Addresses = mergeAddresses(res.Addresses, c.Addresses)
+		res.AddTags(c.Tags)
+		for k, v := range c.Custom {
+			if _, ok := res.Custom[k]; !ok {
+				if res.Custom == nil {
+					res.Custom = make(map[string]string)
+				}
+				res.Custom[k] = v
+			}
+		}
+		res.Aliases = mergeStrings(res.Aliases, append([]string{c.ID}, c.Aliases...))
+	}
+	res.normalizeLists()
+
+	return res
+}
+
+func mergePhones(a []PhoneNumber, b []PhoneNumber) []PhoneNumber {
+	res := append([]PhoneNumber(nil), a...)
+	for _, v := range b {
+		found := false
+		for _, w := range res {
+			if phoneDigits(w.Number) == phoneDigits(v.Number) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.Primary = false
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+func mergeEmails(a []Email, b []Email) []Email {
+	res := append([]Email(nil), a...)
+	for _, v := range b {
+		found := false
+		for _, w := range res {
+			if w.Address == v.Address {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.Primary = false
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+func mergeAddresses(a []Address, b []Address) []Address {
+	res := append([]Address(nil), a...)
+	for _, v := range b {
+		found := false
+		for _, w := range res {
+			w.Primary, w.Label = v.Primary, v.Label
+			if w == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.Primary = false
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+func mergeStrings(a []string, b []string) []string {
+	res := append([]string(nil), a...)
+	for _, v := range b {
+		found := false
+		for _, w := range res {
+			if w == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func mergeFixture() []Contact {
+	return []Contact{
+		{
+			ID: "a", Name: "Ann", Phones: []PhoneNumber{{Number: "+79161234567", Primary: true}},
+			Tags: []string{"work"}, Custom: map[string]string{"department": "Sales"},
+		},
+		{
+			ID: "b", Name: "Ann Lee", Gender: "female", Country: "RU", Favorite: true,
+			Provenance: map[string]string{"gender": ProvenanceEnriched, "country": ProvenanceUser},
+			Phones:     []PhoneNumber{{Number: "+7 916 123-45-67", Primary: true}, {Number: "+12025550100"}},
+			Emails:     []Email{{Address: "ann@example.com", Primary: true}},
+			Tags:       []string{"work", "friends"}, Aliases: []string{"old"},
+			Custom: map[string]string{"department": "Support", "room": "7"},
+		},
+		{ID: "c", Name: "Anna Lee", Gender: "male", Country: "US", Provenance: map[string]string{"gender": ProvenanceUser}},
+	}
+}
+
+func TestMergeFillsEmptyFieldsInOrder(t *testing.T) {
+	contacts := mergeFixture()
+	m := MergeRequest{IDs: []string{"a", "b", "c"}, Primary: "a"}
+
+	got := m.Merge(contacts)
+	if got.ID != "a" || got.Name != "Ann" {
+		t.Fatalf("merged into %v %q, want the primary a and its name", got.ID, got.Name)
+	}
+	if got.Gender != "female" || got.Country != "RU" || !got.Favorite {
+		t.Errorf("gender %q, country %q, favorite %v, want those of b", got.Gender, got.Country, got.Favorite)
+	}
+	if got.Provenance["gender"] != ProvenanceEnriched || got.Provenance["country"] != ProvenanceUser {
+		t.Errorf("provenance %v, want that of b", got.Provenance)
+	}
+
+	var numbers []string
+	for _, v := range got.Phones {
+		numbers = append(numbers, v.Number)
+	}
+	if !reflect.DeepEqual(numbers, []string{"+79161234567", "+12025550100"}) || !got.Phones[0].Primary || got.Phones[1].Primary {
+		t.Errorf("phones %+v, want a's number as primary, then b's other one", got.Phones)
+	}
+	if got.Phone != "+79161234567" || len(got.Emails) != 1 || !got.Emails[0].Primary {
+		t.Errorf("phone %q, emails %+v", got.Phone, got.Emails)
+	}
+	if !reflect.DeepEqual(got.Tags, []string{"work", "friends"}) {
+		t.Errorf("tags %v", got.Tags)
+	}
+	if !reflect.DeepEqual(got.Custom, map[string]string{"department": "Sales", "room": "7"}) {
+		t.Errorf("custom fields %v, want a's department and b's room", got.Custom)
+	}
+	if !reflect.DeepEqual(got.Aliases, []string{"b", "old", "c"}) {
+		t.Errorf("aliases %v, want the merged IDs and their aliases", got.Aliases)
+	}
+
+	// The stored contacts are left alone
+	if contacts[0].Custom["room"] != "" || len(contacts[0].Phones) != 1 {
+		t.Errorf("merging changed the primary's stored contact: %+v", contacts[0])
+	}
+}
+
+func TestMergeResolvesFields(t *testing.T) {
+	m := MergeRequest{
+		IDs: []string{"a", "b", "c"}, Primary: "a",
+		Resolve: map[string]string{"name": "c", "gender": "c", "country": "c", "favorite": "c"},
+	}
+
+	got := m.Merge(mergeFixture())
+	if got.Name != "Anna Lee" || got.Gender != "male" || got.Country != "US" || got.Favorite {
+		t.Errorf("name %q, gender %q, country %q, favorite %v, want those of c", got.Name, got.Gender, got.Country, got.Favorite)
+	}
+	if got.Provenance["gender"] != ProvenanceUser || got.Provenance["country"] != "" {
+		t.Errorf("provenance %v, want that of c", got.Provenance)
+	}
+}
+
+func TestMergeRequestValidate(t *testing.T) {
+	m := MergeRequest{IDs: []string{"a", "", "b", "a"}}
+	if fields := m.Validate(); len(fields) != 0 {
+		t.Fatalf("Validate = %+v", fields)
+	}
+	if !reflect.DeepEqual(m.IDs, []string{"a", "b"}) || m.Primary != "a" {
+		t.Fatalf("ids %v, primary %q, want a and b with primary a", m.IDs, m.Primary)
+	}
+
+	m = MergeRequest{IDs: []string{"a", "a"}, Primary: "c", Resolve: map[string]string{"phone": "a", "name": "z"}}
+	got := make(map[string]bool)
+	for _, v := range m.Validate() {
+		got[v.Field] = true
+	}
+	for _, field := range []string{"ids", "primary", "resolve.phone", "resolve.name"} {
+		if !got[field] {
+			t.Errorf("Validate did not reject %v", field)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// DefaultPhoneRegion is used for numbers without a "+" prefix when the
+// contact has no country
+const DefaultPhoneRegion = "RU"
+
+type phoneRegion struct {
+	Code   string // country calling code
+	Trunk  string // national trunk prefix dropped in international format
+	MinLen int    // national significant number length
+	MaxLen int
+}
+
+// phoneRegions maps ISO 3166-1 alpha-2 codes to their numbering plans
+var phoneRegions = map[string]phoneRegion{
+	"RU": {"7", "8", 10, 10},
+	"KZ": {"7", "8", 10, 10},
+	"US": {"1", "1", 10, 10},
+	"CA": {"1", "1", 10, 10},
+	"GB": {"44", "0", 9, 10},
+	"DE": {"49", "0", 6, 13},
+	"FR": {"33", "0", 9, 9},
+	"IT": {"39", "", 6, 11},
+	"ES": {"34", "", 9, 9},
+	"PT": {"351", "", 9, 9},
+	"NL": {"31", "0", 9, 9},
+	"BE": {"32", "0", 8, 9},
+	"LU": {"352", "", 4, 11},
+	"CH": {"41", "0", 9, 9},
+	"AT": {"43", "0", 4, 13},
+	"PL": {"48", "", 9, 9},
+	"CZ": {"420", "", 9, 9},
+	"SK": {"421", "0", 9, 9},
+	"HU": {"36", "06", 8, 9},
+	"RO": {"40", "0", 9, 9},
+	"BG": {"359", "0", 8, 9},
+	"RS": {"381", "0", 8, 9},
+	"HR": {"385", "0", 8, 9},
+	"SI": {"386", "0", 8, 8},
+	"GR": {"30", "", 10, 10},
+	"CY": {"357", "", 8, 8},
+	"MT": {"356", "", 8, 8},
+	"TR": {"90", "0", 10, 10},
+	"UA": {"380", "0", 9, 9},
+	"BY": {"375", "80", 9, 9},
+	"MD": {"373", "0", 8, 8},
+	"LT": {"370", "8", 8, 8},
+	"LV": {"371", "", 8, 8},
+	"EE": {"372", "", 7, 8},
+	"FI": {"358", "0", 5, 12},
+	"SE": {"46", "0", 7, 9},
+	"NO": {"47", "", 8, 8},
+	"DK": {"45", "", 8, 8},
+	"IS": {"354", "", 7, 7},
+	"IE": {"353", "0", 7, 9},
+	"GE": {"995", "0", 9, 9},
+	"AM": {"374", "0", 8, 8},
+	"AZ": {"994", "0", 9, 9},
+	"UZ": {"998", "", 9, 9},
+	"KG": {"996", "0", 9, 9},
+	"TJ": {"992", "", 9, 9},
+	"IL": {"972", "0", 8, 9},
+	"AE": {"971", "0", 8, 9},
+	"SA": {"966", "0", 8, 9},
+	"EG": {"20", "0", 8, 10},
+	"MA": {"212", "0", 9, 9},
+	"NG": {"234", "0", 8, 10},
+	"KE": {"254", "0", 9, 9},
+	"ZA": {"27", "0", 9, 9},
+	"IN": {"91", "0", 10, 10},
+	"PK": {"92", "0", 9, 10},
+	"BD": {"880", "0", 10, 10},
+	"CN": {"86", "0", 10, 11},
+	"HK": {"852", "", 8, 8},
+	"TW": {"886", "0", 8, 9},
+	"JP": {"81", "0", 9, 10},
+	"KR": {"82", "0", 8, 10},
+	"SG": {"65", "", 8, 8},
+	"MY": {"60", "0", 8, 10},
+	"TH": {"66", "0", 8, 9},
+	"VN": {"84", "0", 9, 10},
+	"ID": {"62", "0", 8, 12},
+	"PH": {"63", "0", 8, 10},
+	"AU": {"61", "0", 9, 9},
+	"NZ": {"64", "0", 8, 10},
+	"BR": {"55", "0", 10, 11},
+	"AR": {"54", "0", 10, 11},
+	"MX": {"52", "", 10, 10},
+	"CL": {"56", "", 9, 9},
+	"CO": {"57", "", 10, 10},
+	"PE": {"51", "0", 8, 9},
+	"VE": {"58", "0", 10, 10},
+}
+
+// mainPhoneRegions names the main region of the calling codes several
+// regions share
+var mainPhoneRegions = map[string]string{
+	"1": "US",
+	"7": "RU",
+}
+
+// regionsByCode lists the numbering plans sharing a calling code, the main
+// one first and the others by region
+var regionsByCode = func() map[string][]phoneRegion {
+	names := make([]string, 0, len(phoneRegions))
+	for k := range phoneRegions {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	res := make(map[string][]phoneRegion)
+	for _, k := range names {
+		v := phoneRegions[k]
+		if mainPhoneRegions[v.Code] == k {
+			res[v.Code] = append([]phoneRegion{v}, res[v.Code]...)
+		} else {
+			res[v.Code] = append(res[v.Code], v)
+		}
+	}
+	return res
+}()
+
+func defaultPhoneRegion() string {
+	if region := os.Getenv("DEFAULT_PHONE_REGION"); region != "" {
+		return strings.ToUpper(region)
+	}
+	return DefaultPhoneRegion
+}
+
+// stripPhoneFormatting drops the separators people put into numbers. It keeps
+// a leading "+" and fails on anything else that is not a digit.
+func stripPhoneFormatting(phone string) (string, error) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '/':
+		default:
+			return "", utils.ErrPhoneWrongFormat
+		}
+	}
+
+	return b.String(), nil
+}
+
+// NormalizePhone converts a phone number in national or international format
+// into E.164. Numbers without an international prefix are read in the
+// numbering plan of country, or of the default region when country is empty.
+func NormalizePhone(phone string, country string) (string, error) {
+	digits, err := stripPhoneFormatting(phone)
+	if err != nil {
+		return "", err
+	}
+	if digits == "" || digits == "+" {
+		return "", utils.ErrPhoneWrongFormat
+	}
+
+	if country == "" {
+		country = defaultPhoneRegion()
+	}
+	local, known := phoneRegions[strings.ToUpper(country)]
+
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits = digits[1:]
+	case strings.HasPrefix(digits, "00"):
+		digits = digits[2:]
+	case known && local.Code == "1" && strings.HasPrefix(digits, "011"):
+		digits = digits[3:]
+	default:
+		if !known {
+			return "", utils.ErrPhoneNoRegion
+		}
+		if local.Trunk != "" && strings.HasPrefix(digits, local.Trunk) && len(digits)-len(local.Trunk) >= local.MinLen {
+			digits = digits[len(local.Trunk):]
+		}
+		digits = local.Code + digits
+	}
+
+	// Calling codes are prefix-free, so at most one length matches
+	for l := 1; l <= 3 && l < len(digits); l++ {
+		regions, ok := regionsByCode[digits[:l]]
+		if !ok {
+			continue
+		}
+		nsn := digits[l:]
+		for _, v := range regions {
+			if len(nsn) >= v.MinLen && len(nsn) <= v.MaxLen {
+				return "+" + digits, nil
+			}
+		}
+		return "", fmt.Errorf("%w for calling code +%v", utils.ErrPhoneWrongLength, digits[:l])
+	}
+
+	return "", utils.ErrPhoneUnknownCode
+}
+
+// phoneDigits keeps only the digits of a phone number so that numbers can be
+// compared regardless of formatting
+func phoneDigits(phone string) string {
+	var b strings.Builder
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// completePhone reports whether stripped digits are a whole number rather
+// than a part of one: written with an international prefix, or with the trunk
+// prefix of the default region and long enough for it
+func completePhone(digits string) bool {
+	if strings.HasPrefix(digits, "+") || strings.HasPrefix(digits, "00") {
+		return true
+	}
+
+	local, known := phoneRegions[defaultPhoneRegion()]
+	if !known {
+		return false
+	}
+	if local.Code == "1" && strings.HasPrefix(digits, "011") {
+		return true
+	}
+	nsn := len(digits) - len(local.Trunk)
+	return local.Trunk != "" && strings.HasPrefix(digits, local.Trunk) && nsn >= local.MinLen && nsn <= local.MaxLen
+}
+
+// phoneFilterDigits turns a filter value into the digits to search for. A
+// complete number in any format is matched by its E.164 form, anything else
+// by its digits as typed, so that "9161234567" still finds numbers outside
+// the default region.
+func phoneFilterDigits(value string) string {
+	digits, err := stripPhoneFormatting(value)
+	if err == nil && completePhone(digits) {
+		if normalized, err := NormalizePhone(value, ""); err == nil {
+			return phoneDigits(normalized)
+		}
+	}
+
+	return phoneDigits(value)
+}
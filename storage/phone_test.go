@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	t.Setenv("DEFAULT_PHONE_REGION", "")
+
+	tests := []struct {
+		phone   string
+		country string
+		want    string
+		err     error
+	}{
+		{"8 (916) 123-45-67", "", "+79161234567", nil},
+		{"9161234567", "ru", "+79161234567", nil},
+		{"+7 916 123 45 67", "US", "+79161234567", nil},
+		{"(202) 555-0100", "US", "+12025550100", nil},
+		{"1 202 555 0100", "CA", "+12025550100", nil},
+		{"011 44 20 7946 0958", "US", "+442079460958", nil},
+		{"020 7946 0958", "GB", "+442079460958", nil},
+		{"0044 20 7946 0958", "DE", "+442079460958", nil},
+		{"030 123456", "DE", "+4930123456", nil},
+		{"06 12 34 56 78", "FR", "+33612345678", nil},
+		{"06 30 123 4567", "HU", "+36301234567", nil},
+		{"8029 123 45 67", "BY", "+375291234567", nil},
+		{"090-1234-5678", "JP", "+819012345678", nil},
+		{"+61 4 1234 5678", "", "+61412345678", nil},
+		{"612 345 678", "ES", "+34612345678", nil},
+		{"+1 202 555 01", "", "", utils.ErrPhoneWrongLength},
+		{"+999 1234567", "", "", utils.ErrPhoneUnknownCode},
+		{"1234567", "ZZ", "", utils.ErrPhoneNoRegion},
+		{"+7 916 CALL ME", "", "", utils.ErrPhoneWrongFormat},
+		{"+", "", "", utils.ErrPhoneWrongFormat},
+	}
+	for _, tt := range tests {
+		got, err := NormalizePhone(tt.phone, tt.country)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("NormalizePhone(%q, %q) = %q, %v, want %q, %v", tt.phone, tt.country, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestNormalizePhoneDefaultRegion(t *testing.T) {
+	t.Setenv("DEFAULT_PHONE_REGION", "gb")
+
+	got, err := NormalizePhone("07700 900123", "")
+	if err != nil || got != "+447700900123" {
+		t.Fatalf("NormalizePhone in GB = %q, %v", got, err)
+	}
+}
+
+func TestPhoneFilterDigits(t *testing.T) {
+	t.Setenv("DEFAULT_PHONE_REGION", "")
+
+	tests := map[string]string{
+		"8 916 123-45-67":  "79161234567",
+		"+44 20 7946 0958": "442079460958",
+		"9161234567":       "9161234567",
+		"123-45":           "12345",
+	}
+	for value, want := range tests {
+		if got := phoneFilterDigits(value); got != want {
+			t.Errorf("phoneFilterDigits(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
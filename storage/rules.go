@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Rule profiles evaluated by the validation middlewares
+const (
+	ProfileCreate = "create"
+	ProfileEdit   = "edit"
+)
+
+// Formats are built-in checks that cannot be written as a regex
+const (
+	FormatPhone   = "phone"
+	FormatCountry = "country"
+	FormatEmail   = "email"
+)
+
+// FieldRule lists the constraints on a single contact field. Empty values are
+// only checked for Required; Min and Max count characters.
+type FieldRule struct {
+	Required bool     `json:"required"`
+	Min      int      `json:"min"`
+	Max      int      `json:"max"`
+	Regex    string   `json:"regex"`
+	Enum     []string `json:"enum"`
+	Format   string   `json:"format"`
+	Message  string   `json:"message"`
+
+	regex *regexp.Regexp
+}
+
+// RuleProfile maps contact field names to their rules
+type RuleProfile map[string]*FieldRule
+
+type RuleSet struct {
+	Create RuleProfile `json:"create"`
+	Edit   RuleProfile `json:"edit"`
+}
+
+// DefaultRules is used when no rules file is given
+func DefaultRules() *RuleSet {
+	rs := &RuleSet{
+		Create: RuleProfile{
+			"name":    {Required: true, Min: 4},
+			"phone":   {Required: true, Format: FormatPhone},
+			"gender":  {Enum: []string{"male", "female"}},
+			"country": {Format: FormatCountry},
+			"email":   {Format: FormatEmail},
+		},
+		Edit: RuleProfile{
+			"name":    {Min: 4},
+			"phone":   {Format: FormatPhone},
+			"gender":  {Enum: []string{"male", "female"}},
+			"country": {Format: FormatCountry},
+			"email":   {Format: FormatEmail},
+		},
+	}
+	err := rs.compile()
+	if err != nil {
+		panic(err)
+	}
+
+	return rs
+}
+
+// LoadRules reads a JSON rules file. A profile the file leaves out is taken
+// from DefaultRules.
+func LoadRules(path string) (*RuleSet, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs RuleSet
+	err = json.Unmarshal(bytes, &rs)
+	if err != nil {
+		return nil, fmt.Errorf("rules file %v: %w", path, err)
+	}
+
+	err = rs.compile()
+	if err != nil {
+		return nil, fmt.Errorf("rules file %v: %w", path, err)
+	}
+
+	defaults := DefaultRules()
+	if rs.Create == nil {
+		rs.Create = defaults.Create
+	}
+	if rs.Edit == nil {
+		rs.Edit = defaults.Edit
+	}
+
+	return &rs, nil
+}
+
+// Profile returns the rules of the named profile
+func (rs *RuleSet) Profile(profile string) RuleProfile {
+	if profile == ProfileEdit {
+		return rs.Edit
+	}
+
+	return rs.Create
+}
+
+// formats reports whether the profile checks field with a built-in format,
+// which is then also how the field is normalized
+func (p RuleProfile) formats(field string, format string) bool {
+	rule, ok := p[field]
+
+	return ok && rule.Format == format
+}
+
+func (rs *RuleSet) compile() error {
+	for profileName, profile := range map[string]RuleProfile{ProfileCreate: rs.Create, ProfileEdit: rs.Edit} {
+		for field, rule := range profile {
+			if !isRuleField(field) {
+				return fmt.Errorf("%v profile: unknown field %q", profileName, field)
+			}
+			if rule == nil {
+				return fmt.Errorf("%v profile: empty rule for %q", profileName, field)
+			}
+			switch rule.Format {
+			case "", FormatPhone, FormatCountry, FormatEmail:
+			default:
+				return fmt.Errorf("%v profile: unknown format %q for %q", profileName, rule.Format, field)
+			}
+			if rule.Regex != "" {
+				re, err := regexp.Compile(rule.Regex)
+				if err != nil {
+					return fmt.Errorf("%v profile: regex for %q: %w", profileName, field, err)
+				}
+				rule.regex = re
+			}
+		}
+	}
+
+	return nil
+}
+
+// ruleFields are the contact fields rules can be written for, in the order
+// they are checked
+var ruleFields = []string{"name", "phone", "email", "gender", "country"}
+
+func isRuleField(field string) bool {
+	for _, v := range ruleFields {
+		if v == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+type fieldValue struct {
+	Path  string
+	Value string
+}
+
+// contactFieldValues returns the values a rule for field applies to. A phone
+// rule covers every entry in Phones, an email rule every entry in Emails.
+func contactFieldValues(c Contact, field string) []fieldValue {
+	var res []fieldValue
+
+	switch field {
+	case "name":
+		res = append(res, fieldValue{"name", c.Name})
+	case "phone":
+		for i, v := range c.Phones {
+			res = append(res, fieldValue{fmt.Sprintf("phones[%v].number", i), v.Number})
+		}
+		if len(c.Phones) == 0 {
+			res = append(res, fieldValue{"phone", c.Phone})
+		}
+	case "email":
+		for i, v := range c.Emails {
+			res = append(res, fieldValue{fmt.Sprintf("emails[%v].address", i), v.Address})
+		}
+		if len(c.Emails) == 0 {
+			res = append(res, fieldValue{"email", ""})
+		}
+	case "gender":
+		res = append(res, fieldValue{"gender", c.Gender})
+	case "country":
+		res = append(res, fieldValue{"country", c.Country})
+	}
+
+	return res
+}
+
+// Validate checks c against the named profile and reports every rejected
+// field. Fields are checked in a fixed order so responses are stable.
+func (rs *RuleSet) Validate(profile string, c Contact) []utils.FieldError {
+	rules := rs.Profile(profile)
+
+	// Phone numbers are read in the numbering plan of the given country
+	country := c.Country
+	if alpha2, err := NormalizeCountry(country); err == nil {
+		country = alpha2
+	}
+
+	// Without a country in an edit, a national number can only be read
+	// once the stored contact is known, so the handler checks it
+	deferPhone := profile == ProfileEdit && c.Country == ""
+
+	var fields []utils.FieldError
+	for _, field := range ruleFields {
+		rule, ok := rules[field]
+		if !ok {
+			continue
+		}
+
+		for _, v := range contactFieldValues(c, field) {
+			if fieldErr, failed := rule.check(v.Path, v.Value, country, deferPhone); failed {
+				fields = append(fields, fieldErr)
+			}
+		}
+	}
+
+	return fields
+}
+
+func (rule *FieldRule) check(field string, value string, country string, deferPhone bool) (utils.FieldError, bool) {
+	fail := func(code string, message string) (utils.FieldError, bool) {
+		if rule.Message != "" {
+			message = rule.Message
+		}
+		return utils.FieldError{Field: field, Code: code, Message: message}, true
+	}
+
+	if value == "" {
+		if rule.Required {
+			return fail(utils.CodeRequired, fmt.Sprintf("%v is required", field))
+		}
+		return utils.FieldError{}, false
+	}
+
+	length := utf8.RuneCountInString(value)
+	if rule.Min > 0 && length < rule.Min {
+		return fail(utils.CodeTooShort, fmt.Sprintf("%v must have at least %v characters", field, rule.Min))
+	}
+	if rule.Max > 0 && length > rule.Max {
+		return fail(utils.CodeTooLong, fmt.Sprintf("%v must have at most %v characters", field, rule.Max))
+	}
+
+	if len(rule.Enum) > 0 {
+		allowed := false
+		for _, v := range rule.Enum {
+			if v == value {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fail(utils.CodeInvalidValue, fmt.Sprintf("%v must be one of \"%v\"", field, strings.Join(rule.Enum, "\", \"")))
+		}
+	}
+
+	if rule.regex != nil && !rule.regex.MatchString(value) {
+		return fail(utils.CodeInvalidFormat, fmt.Sprintf("%v must match %v", field, rule.Regex))
+	}
+
+	switch rule.Format {
+	case FormatPhone:
+		if deferPhone && !strings.HasPrefix(value, "+") {
+			break
+		}
+		_, err := NormalizePhone(value, country)
+		if err != nil {
+			return PhoneFieldError(field, err), true
+		}
+	case FormatCountry:
+		err := validateCountry(value)
+		if err != nil {
+			return fail(utils.CodeInvalidFormat, err.Error())
+		}
+	case FormatEmail:
+		_, err := normalizeEmail(value)
+		if err != nil {
+			return fail(utils.CodeInvalidFormat, err.Error())
+		}
+	}
+
+	return utils.FieldError{}, false
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+func writeRules(t *testing.T, rules string) string {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	err := ioutil.WriteFile(path, []byte(rules), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadRulesKeepsMissingProfiles(t *testing.T) {
+	tests := map[string]struct {
+		rules   string
+		minName map[string]int
+	}{
+		"no edit":    {`{"create": {"name": {"required": true, "min": 8}}}`, map[string]int{ProfileCreate: 8, ProfileEdit: 4}},
+		"no create":  {`{"edit": {"name": {"min": 2}}}`, map[string]int{ProfileCreate: 4, ProfileEdit: 2}},
+		"no profile": {`{}`, map[string]int{ProfileCreate: 4, ProfileEdit: 4}},
+	}
+	for name, tt := range tests {
+		rs, err := LoadRules(writeRules(t, tt.rules))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		for profile, min := range tt.minName {
+			rule, ok := rs.Profile(profile)["name"]
+			if !ok || rule.Min != min {
+				t.Errorf("%v: %v profile name rule %+v, want min %v", name, profile, rule, min)
+			}
+		}
+	}
+}
+
+func TestLoadRulesLeavesOutFieldsOfAGivenProfile(t *testing.T) {
+	rs, err := LoadRules(writeRules(t, `{"create": {"name": {"required": true}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A given profile is taken as written, so the phone is not required
+	fields := rs.Validate(ProfileCreate, Contact{Name: "Ann Lee"})
+	if len(fields) != 0 {
+		t.Fatalf("Validate = %+v, want no errors", fields)
+	}
+	if rs.Create.formats("phone", FormatPhone) {
+		t.Fatal("the create profile still formats phone numbers")
+	}
+}
+
+func TestLoadRulesRejectsBadRules(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":  `{"create": {"nickname": {"min": 2}}}`,
+		"empty rule":     `{"edit": {"name": null}}`,
+		"unknown format": `{"create": {"phone": {"format": "fax"}}}`,
+		"bad regex":      `{"create": {"name": {"regex": "("}}}`,
+		"malformed JSON": `{"create": `,
+	}
+	for name, rules := range tests {
+		path := writeRules(t, rules)
+		_, err := LoadRules(path)
+		if err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("%v: LoadRules = %v, want an error naming the file", name, err)
+		}
+	}
+}
+
+func TestExampleRulesFile(t *testing.T) {
+	rs, err := LoadRules(filepath.Join("..", "rules.example.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, profile := range []string{ProfileCreate, ProfileEdit} {
+		if !rs.Profile(profile).formats("email", FormatEmail) {
+			t.Errorf("the %v profile does not check e-mail addresses", profile)
+		}
+	}
+
+	c := Contact{Name: "Ann Lee", Phone: "+1 202 555 0100", Emails: []Email{{Address: "ann@"}}}
+	fields := rs.Validate(ProfileCreate, c)
+	if len(fields) != 1 || fields[0].Field != "emails[0].address" || fields[0].Code != utils.CodeInvalidFormat {
+		t.Fatalf("Validate = %+v, want a bad e-mail address", fields)
+	}
+}
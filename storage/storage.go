@@ -7,51 +7,225 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 )
 
+const (
+	ProvenanceUser     = "user"
+	ProvenanceEnriched = "enriched"
+)
+
 type Contact struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Phone    string `json:"phone"`
-	Gender   string `json:"gender"`
-	Country  string `json:"country"`
-	Favorite bool   `json:"favorite"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Phone mirrors the number of the primary entry in Phones for clients
+	// knowing a single number only
+	Phone     string        `json:"phone"`
+	Phones    []PhoneNumber `json:"phones,omitempty"`
+	Emails    []Email       `json:"emails,omitempty"`
+	Addresses []Address     `json:"addresses,omitempty"`
+	Gender    string        `json:"gender"`
+	Country   string        `json:"country"`
+	Favorite  bool          `json:"favorite"`
+	Tags      []string      `json:"tags,omitempty"`
+	// Aliases are the IDs of contacts merged into this one
+	Aliases []string `json:"aliases,omitempty"`
+	// Custom keeps extension properties of imported cards, such as X-SKYPE
+	Custom map[string]string `json:"custom,omitempty"`
+	// CreatedAt, UpdatedAt and CreatedBy are set by the server only
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	// DeletedAt is set while the contact is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Provenance records where gender and country came from: typed in by the
+	// user or guessed by the enrichment APIs
+	Provenance map[string]string `json:"provenance,omitempty"`
+}
+
+type PhoneNumber struct {
+	Number  string `json:"number"`
+	Label   string `json:"label,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type Email struct {
+	Address string `json:"address"`
+	Label   string `json:"label,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type Address struct {
+	Street     string `json:"street,omitempty"`
+	City       string `json:"city,omitempty"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty"`
+	Label      string `json:"label,omitempty"`
+	Primary    bool   `json:"primary,omitempty"`
 }
 
+// EditContact holds the fields to change. A non-empty Phone replaces the
+// primary number, non-nil lists replace the stored ones.
 type EditContact struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Phone   string `json:"phone"`
-	Gender  string `json:"gender"`
-	Country string `json:"country"`
+	ID        string        `json:"id"`
+	Name      string        `json:"name"`
+	Phone     string        `json:"phone"`
+	Phones    []PhoneNumber `json:"phones"`
+	Emails    []Email       `json:"emails"`
+	Addresses []Address     `json:"addresses"`
+	Gender    string        `json:"gender"`
+	Country   string        `json:"country"`
+	Tags      []string      `json:"tags"`
+}
+
+// TagCount is the number of contacts carrying a tag
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
 }
 
 type FilterRequest struct {
 	Field string `json:"field"`
 	Value string `json:"value"`
+	ListOptions
+}
+
+// ListOptions sorts and narrows down contact lists. Sort is one of
+// "name", "created_at" or "updated_at", Order is "asc" or "desc".
+type ListOptions struct {
+	Sort         string    `json:"sort"`
+	Order        string    `json:"order"`
+	UpdatedSince time.Time `json:"updated_since"`
+	CreatedSince time.Time `json:"created_since"`
 }
 
+// WalkFunc calls fn for every contact of a walk until fn fails. A walk is
+// started by StorageInterface.Walk with the lock held and run once after it is
+// released, seeing the book as it was when it started.
+type WalkFunc func(fn func(Contact) error) error
+
+// StorageInterface is the address book of a tenant, DefaultTenant for the
+// storages that main creates. Tenant returns the book of another tenant,
+// sharing nothing but the API keys and the list of shared address books.
 type StorageInterface interface {
+	Tenant(string) StorageInterface
+	Tenants() ([]string, error)
 	List() ([]Contact, error)
+	Walk() (WalkFunc, error)
 	Add(Contact) error
 	Delete(string) error
 	Edit(EditContact) (Contact, error)
 	Filter(string, string) ([]Contact, error)
 	ListFavs() ([]Contact, error)
 	ChangeFavs(string, string) error
+	Get(string) (Contact, error)
+	Update(Contact) error
+	Bulk([]BulkOperation, bool) ([]BulkResult, error)
+	Tags() ([]TagCount, error)
+	ListTrash() ([]Contact, error)
+	GetWithTrash(string) (Contact, error)
+	Restore(string) (Contact, error)
+	Purge(string) error
+	PurgeDeleted(time.Time) (int, error)
+	AddRevisions([]Revision) error
+	History(string) ([]Revision, error)
+	GetIdempotent(string) (IdempotencyRecord, bool, error)
+	SaveIdempotent(IdempotencyRecord) error
+	ExpireIdempotent(time.Time) (int, error)
+	AppendChanges([]Change) ([]Change, error)
+	Changes(int64, int) ([]Change, error)
+	ChangeBounds() (int64, int64, error)
+	ExpireChanges(time.Time) (int, error)
+	ListWebhooks() ([]Webhook, error)
+	SaveWebhook(Webhook) error
+	DeleteWebhook(string) error
+	ListDeadLetters() ([]DeadLetter, error)
+	SaveDeadLetter(DeadLetter) error
+	DeleteDeadLetter(string) error
+	ListAPIKeys() ([]APIKey, error)
+	GetAPIKey(string) (APIKey, error)
+	SaveAPIKey(APIKey) error
+	ListAddressBooks() ([]AddressBook, error)
+	GetAddressBook(string) (AddressBook, error)
+	SaveAddressBook(AddressBook) error
 }
 
 type MemoryStorage struct {
 	ContactBook map[string]*Contact
+	Revisions   map[string][]Revision
+	Idempotency map[string]IdempotencyRecord
+	ChangeLog   *ChangeLog
+	Webhooks    map[string]Webhook
+	DeadLetters map[string]DeadLetter
+	APIKeys     map[string]APIKey
+	// AddressBooks holds the shared books, whose contacts are kept in books
+	AddressBooks map[string]AddressBook
+	// PhoneIndex maps phone digits to the ID of a contact having the number
+	PhoneIndex map[string]string
+	// UniquePhone rejects new contacts with a number another contact has
+	UniquePhone bool
+	// books holds the books of other tenants, nil in those books
+	books *memoryBooks
 }
 
-type FileStorage struct{}
+func NewMemoryStorage() MemoryStorage {
+	return MemoryStorage{
+		ContactBook:  make(map[string]*Contact),
+		Revisions:    make(map[string][]Revision),
+		Idempotency:  make(map[string]IdempotencyRecord),
+		ChangeLog:    &ChangeLog{},
+		Webhooks:     make(map[string]Webhook),
+		DeadLetters:  make(map[string]DeadLetter),
+		APIKeys:      make(map[string]APIKey),
+		AddressBooks: make(map[string]AddressBook),
+		PhoneIndex:   make(map[string]string),
+		books:        &memoryBooks{books: make(map[string]MemoryStorage)},
+	}
+}
+
+type FileStorage struct {
+	// UniquePhone rejects new contacts with a number another contact has
+	UniquePhone bool
+	tenant      string
+}
 
 const IndexName = "contacts"
 
+// HistoryIndexName holds the revisions of the contacts in IndexName
+const HistoryIndexName = IndexName + "_history"
+
+// IdempotencyIndexName holds the responses replayed for Idempotency-Key
+const IdempotencyIndexName = IndexName + "_idempotency"
+
+// ChangesIndexName holds the change log, the document ID being the sequence
+// number
+const ChangesIndexName = IndexName + "_changes"
+
+// WebhookIndexName holds the webhook subscriptions
+const WebhookIndexName = IndexName + "_webhooks"
+
+// DeadLetterIndexName holds the deliveries that failed every attempt
+const DeadLetterIndexName = IndexName + "_dead_letters"
+
+// APIKeyIndexName holds the hashed API keys
+const APIKeyIndexName = IndexName + "_api_keys"
+
+// AddressBookIndexName holds the shared address books
+const AddressBookIndexName = IndexName + "_books"
+
+// PhoneIndexName holds a claim per phone number, the document ID being its
+// digits, for UniquePhone
+const PhoneIndexName = IndexName + "_phones"
+
 type ElasticStorage struct {
 	client *elasticsearch.Client
+	// UniquePhone rejects new contacts with a number another contact has
+	UniquePhone bool
+	tenant      string
+	// prepared holds the tenants whose indices exist
+	prepared *sync.Map
 }
 
 func NewElasticStorage() ElasticStorage {
@@ -80,5 +254,17 @@ func NewElasticStorage() ElasticStorage {
 	}
 
 	esObject.client = es
+
+	esObject.prepare()
+	err = esObject.ensureIndex(APIKeyIndexName, apiKeyMapping)
+	if err != nil {
+		log.Printf("Error preparing index %v: %s", APIKeyIndexName, err)
+	}
+	err = esObject.ensureIndex(AddressBookIndexName, addressBookMapping)
+	if err != nil {
+		log.Printf("Error preparing index %v: %s", AddressBookIndexName, err)
+	}
+	esObject.prepared = &sync.Map{}
+
 	return esObject
 }
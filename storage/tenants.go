@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// DefaultTenant is the book of requests made without authentication, the
+// one storages held before tenants existed
+const DefaultTenant = ""
+
+// tenantPattern is what tenant IDs look like, safe in file and index names
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// hashedTenantPattern is what the IDs of hashed names look like
+var hashedTenantPattern = regexp.MustCompile(`^t-[0-9a-f]{32}$`)
+
+// TenantID turns who a request is made by, such as the subject of a token,
+// into the ID of its book. Names are kept only when they already are safe in
+// file and index names, and are not the ID of a shared address book or of a
+// hashed name; everything else is hashed. Names are case-sensitive, so
+// "Alice" and "alice" get different books.
+func TenantID(name string) string {
+	_, err := uuid.Parse(name)
+	if tenantPattern.MatchString(name) && err != nil && !hashedTenantPattern.MatchString(name) {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+
+	return "t-" + hex.EncodeToString(sum[:16])
+}
+
+// memoryBooks holds the books of the tenants of a memory storage
+type memoryBooks struct {
+	mu    sync.Mutex
+	books map[string]MemoryStorage
+}
+
+// Tenant returns the book of a tenant, created empty on first use. The API
+// keys and address books are shared by every book.
+func (s MemoryStorage) Tenant(tenant string) StorageInterface {
+	if tenant == DefaultTenant || s.books == nil {
+		return s
+	}
+
+	s.books.mu.Lock()
+	defer s.books.mu.Unlock()
+
+	book, ok := s.books.books[tenant]
+	if !ok {
+		book = NewMemoryStorage()
+		book.UniquePhone = s.UniquePhone
+		book.APIKeys = s.APIKeys
+		book.AddressBooks = s.AddressBooks
+		book.books = nil
+		s.books.books[tenant] = book
+	}
+
+	return book
+}
+
+// Tenants lists the tenants with a book besides the default one
+func (s MemoryStorage) Tenants() ([]string, error) {
+	var tenants []string
+	if s.books == nil {
+		return tenants, nil
+	}
+
+	s.books.mu.Lock()
+	defer s.books.mu.Unlock()
+
+	for tenant := range s.books.books {
+		tenants = append(tenants, tenant)
+	}
+
+	return tenants, nil
+}
@@ -9,7 +9,6 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"regexp"
 )
 
 type Genderize struct {
@@ -37,6 +36,9 @@ func (c *Contact) FillMissingFields() error {
 		if err != nil {
 			return err
 		}
+		c.setProvenance("gender", ProvenanceEnriched)
+	} else {
+		c.setProvenance("gender", ProvenanceUser)
 	}
 
 	if c.Country == "" {
@@ -44,11 +46,51 @@ func (c *Contact) FillMissingFields() error {
 		if err != nil {
 			return err
 		}
+		c.setProvenance("country", ProvenanceEnriched)
+	} else {
+		c.setProvenance("country", ProvenanceUser)
 	}
 
 	return nil
 }
 
+// Enrich guesses gender and country again from the current name. Fields
+// entered by the user are never touched. With onlyEnriched set, fields
+// without recorded provenance (contacts created before it was tracked) are
+// skipped as well.
+func (c *Contact) Enrich(onlyEnriched bool) error {
+	var err error
+
+	if c.shouldEnrich("gender", c.Gender, onlyEnriched) {
+		err = c.genderize()
+		if err != nil {
+			return err
+		}
+		c.setProvenance("gender", ProvenanceEnriched)
+	}
+
+	if c.shouldEnrich("country", c.Country, onlyEnriched) {
+		err = c.nationalize()
+		if err != nil {
+			return err
+		}
+		c.setProvenance("country", ProvenanceEnriched)
+	}
+
+	return nil
+}
+
+func (c *Contact) shouldEnrich(field string, value string, onlyEnriched bool) bool {
+	switch c.Provenance[field] {
+	case ProvenanceUser:
+		return false
+	case ProvenanceEnriched:
+		return true
+	default:
+		return value == "" || !onlyEnriched
+	}
+}
+
 func (c *Contact) genderize() error {
 	nameUrl := fmt.Sprintf("https://api.genderize.io?name=%v", c.Name)
 	resp, err := http.Get(nameUrl)
@@ -115,150 +157,95 @@ func (c *Contact) nationalize() error {
 	return nil
 }
 
-func validateName(name string) error {
-	if name == "" || len(name) < 4 {
-		return errors.New("name must have more than 4 characters")
+// PhoneFieldError converts an error of NormalizePhone into a field error
+func PhoneFieldError(field string, err error) utils.FieldError {
+	code := utils.CodeInvalidFormat
+	switch {
+	case errors.Is(err, utils.ErrPhoneWrongLength):
+		code = utils.CodeInvalidLength
+	case errors.Is(err, utils.ErrPhoneUnknownCode):
+		code = utils.CodeUnknownCode
+	case errors.Is(err, utils.ErrPhoneNoRegion):
+		code = utils.CodeUnknownRegion
 	}
 
-	return nil
+	return utils.FieldError{Field: field, Code: code, Message: err.Error()}
 }
 
-func validatePhone(phone string) error {
-	phoneReg, err := regexp.MatchString(`^\+7\d{10}$`, phone)
-	if phoneReg == false || err != nil {
-		return errors.New("phone number must be in \"+7xxxxxxxxxx\" format")
-	}
+func validateCountry(country string) error {
+	_, err := NormalizeCountry(country)
 
-	return nil
+	return err
 }
 
-func validateGender(gender string) error {
-	if gender != "male" && gender != "female" && gender != "" {
-		return errors.New("gender must be either \"male\" or \"female\", liberal")
+// readContactBody reads the request body and leaves a fresh copy in place for
+// the next handler. A false result means an error response was already sent.
+func readContactBody(w http.ResponseWriter, r *http.Request, c *Contact) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
 	}
 
-	return nil
-}
-
-func validateCountry(country string) error {
-	if country == "" {
-		return nil
+	err = r.Body.Close()
+	if err != nil {
+		utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
 	}
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 
-	countryReg, err := regexp.MatchString(`^[A-Z]{2}`, country)
-	if countryReg == false || err != nil {
-		return errors.New("country code must consist of two uppercase letters")
+	err = json.Unmarshal(body, c)
+	if err != nil {
+		utils.SendCustomError(w, http.StatusBadRequest, fmt.Sprintf("malformed JSON: %v", err))
+		return nil, false
 	}
 
-	return nil
+	return body, true
 }
 
-func ValidateNewContact(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		var c Contact
-		err = json.Unmarshal(body, &c)
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		err = validateName(c.Name)
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
+// readOnlyFields are set by the server and must not come from clients
+var readOnlyFields = []string{"created_at", "updated_at", "created_by", "aliases"}
 
-		err = validatePhone(c.Phone)
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		err = validateGender(c.Gender)
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-
-		err = validateCountry(c.Country)
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
+// ReadOnlyFieldErrors reports server-set fields present in a JSON object
+func ReadOnlyFieldErrors(body []byte) []utils.FieldError {
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(body, &raw) != nil {
+		return nil
+	}
 
-		//err = r.Body.Close()
-		err = r.Body.Close()
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-			return
+	var fields []utils.FieldError
+	for _, v := range readOnlyFields {
+		if _, ok := raw[v]; ok {
+			fields = append(fields, utils.FieldError{Field: v, Code: utils.CodeReadOnly, Message: fmt.Sprintf("%v is set by the server", v)})
 		}
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	}
 
-		next.ServeHTTP(w, r)
-	})
+	return fields
 }
 
-func ValidateExistingContact(next http.Handler) http.Handler {
+// validateContact is the middleware evaluating a profile of the rules
+func (rs *RuleSet) validateContact(profile string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-
 		var c Contact
-		err = json.Unmarshal(body, &c)
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		body, ok := readContactBody(w, r, &c)
+		if !ok {
 			return
 		}
 
-		if c.Name != "" {
-			err = validateName(c.Name)
-			if err != nil {
-				utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
-		}
-
-		if c.Phone != "" {
-			err = validatePhone(c.Phone)
-			if err != nil {
-				utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
-		}
-
-		if c.Gender != "" {
-			err = validateGender(c.Gender)
-			if err != nil {
-				utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
-		}
-
-		if c.Country != "" {
-			err = validateCountry(c.Country)
-			if err != nil {
-				utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
-		}
-
-		//err = r.Body.Close()
-		err = r.Body.Close()
-		if err != nil {
-			utils.SendCustomError(w, http.StatusInternalServerError, err.Error())
+		fields := append(ReadOnlyFieldErrors(body), rs.Validate(profile, c)...)
+		if len(fields) > 0 {
+			utils.SendValidationError(w, http.StatusUnprocessableEntity, utils.ErrValidationFailed.Error(), fields)
 			return
 		}
-		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (rs *RuleSet) ValidateNewContact(next http.Handler) http.Handler {
+	return rs.validateContact(ProfileCreate, next)
+}
+
+func (rs *RuleSet) ValidateExistingContact(next http.Handler) http.Handler {
+	return rs.validateContact(ProfileEdit, next)
+}
@@ -0,0 +1,519 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VCardContentType is the media type of vCard (RFC 6350)
+const VCardContentType = "text/vcard"
+
+// vCardLineLength is the longest line written before folding, in octets
+const vCardLineLength = 75
+
+// Extension properties standing for contact fields vCard has no property for
+const (
+	vCardCountry  = "X-COUNTRY"
+	vCardFavorite = "X-FAVORITE"
+	vCardGender   = "X-GENDER"
+)
+
+var errVCardNoBegin = errors.New("card must start with BEGIN:VCARD")
+
+// vCardGenders maps the sex component of GENDER onto stored genders
+var vCardGenders = map[string]string{
+	"M": "male",
+	"F": "female",
+	"O": "other",
+	"N": "none",
+	"U": "unknown",
+}
+
+// vCardProperty is a content line: NAME;PARAM=a,b:value
+type vCardProperty struct {
+	Name   string
+	Params map[string][]string
+	Value  string
+}
+
+// hasType reports whether the property carries a TYPE parameter value,
+// written as TYPE=a,b or as several TYPE parameters
+func (p vCardProperty) hasType(value string) bool {
+	for _, v := range p.Params["TYPE"] {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// label returns the first TYPE which is not about preference or the kind of
+// number, the way labels are written by phones
+func (p vCardProperty) label() string {
+	for _, v := range p.Params["TYPE"] {
+		switch strings.ToLower(v) {
+		case "pref", "voice", "internet", "x400":
+			continue
+		case "cell":
+			return LabelMobile
+		default:
+			return strings.ToLower(v)
+		}
+	}
+
+	return ""
+}
+
+// preferred reports whether the property is marked as the preferred one, by
+// PREF=1 (vCard 4.0) or TYPE=pref (vCard 3.0)
+func (p vCardProperty) preferred() bool {
+	if pref := p.Params["PREF"]; len(pref) > 0 && pref[0] == "1" {
+		return true
+	}
+
+	return p.hasType("pref")
+}
+
+// VCardCard is a decoded card: the contact or the reason it could not be read.
+// Names holds the names of the properties the card has.
+type VCardCard struct {
+	Contact Contact
+	Names   map[string]bool
+	Err     error
+}
+
+// DecodeVCards reads every card of a vCard stream. A card that cannot be read
+// does not stop the others from being decoded.
+func DecodeVCards(r io.Reader) ([]VCardCard, error) {
+	lines, err := unfoldVCard(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var cards []VCardCard
+	var properties []vCardProperty
+	// skipping is set for the rest of a card with a malformed line
+	inCard, skipping := false, false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		p, err := parseVCardLine(line)
+		switch {
+		case err != nil && inCard:
+			cards = append(cards, VCardCard{Err: err})
+			inCard, skipping = false, true
+		case err != nil:
+		case skipping:
+			skipping = p.Name != "END"
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VCARD"):
+			inCard = true
+			properties = nil
+		case p.Name == "END" && strings.EqualFold(p.Value, "VCARD") && inCard:
+			names := make(map[string]bool)
+			for _, v := range properties {
+				names[v.Name] = true
+			}
+			cards = append(cards, VCardCard{Contact: contactFromVCard(properties), Names: names})
+			inCard = false
+		case inCard:
+			properties = append(properties, p)
+		default:
+			cards = append(cards, VCardCard{Err: errVCardNoBegin})
+		}
+	}
+	if inCard {
+		cards = append(cards, VCardCard{Err: errors.New("card is missing END:VCARD")})
+	}
+
+	return cards, nil
+}
+
+// Replace returns the stored contact replaced by the card. Fields vCard does
+// not carry keep their stored values, as do gender, country and favorite when
+// the card leaves them out. Gender and country changed by the card count as
+// entered by the user. Custom fields written as X- properties are read back
+// under their stored keys, those EncodeVCard cannot write are kept.
+func (v VCardCard) Replace(stored Contact) Contact {
+	c := v.Contact
+	c.ID = stored.ID
+	c.Aliases = stored.Aliases
+	for k, value := range stored.Custom {
+		if c.Custom == nil {
+			c.Custom = make(map[string]string)
+		}
+		name, ok := vCardCustomName(k)
+		if !ok {
+			c.Custom[k] = value
+			continue
+		}
+		if carried, ok := c.Custom[name]; ok && name != k {
+			delete(c.Custom, name)
+			c.Custom[k] = carried
+		}
+	}
+	c.Provenance = nil
+	for field, source := range stored.Provenance {
+		c.setProvenance(field, source)
+	}
+
+	if c.Gender == "" || c.Gender == stored.Gender {
+		c.Gender = stored.Gender
+	} else {
+		c.setProvenance("gender", ProvenanceUser)
+	}
+	if c.Country == "" || c.Country == stored.Country {
+		c.Country = stored.Country
+	} else {
+		c.setProvenance("country", ProvenanceUser)
+	}
+	if !v.Names[vCardFavorite] {
+		c.Favorite = stored.Favorite
+	}
+
+	return c
+}
+
+// unfoldVCard joins folded lines: a line starting with a space or tab
+// continues the previous one
+func unfoldVCard(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// parseVCardLine splits a content line into name, parameters and value.
+// Group prefixes ("item1.TEL") are dropped.
+func parseVCardLine(line string) (vCardProperty, error) {
+	p := vCardProperty{Params: make(map[string][]string)}
+
+	// The value starts at the first colon outside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return p, fmt.Errorf("malformed line %q", line)
+	}
+	p.Value = line[colon+1:]
+
+	parts := strings.Split(line[:colon], ";")
+	p.Name = strings.ToUpper(parts[0])
+	if dot := strings.LastIndex(p.Name, "."); dot >= 0 {
+		p.Name = p.Name[dot+1:]
+	}
+	for _, param := range parts[1:] {
+		name, value := param, ""
+		if eq := strings.Index(param, "="); eq >= 0 {
+			name, value = param[:eq], param[eq+1:]
+		} else {
+			// vCard 2.1 writes bare types: TEL;CELL:...
+			name, value = "TYPE", param
+		}
+		name = strings.ToUpper(name)
+		for _, v := range strings.Split(value, ",") {
+			p.Params[name] = append(p.Params[name], strings.Trim(v, `"`))
+		}
+	}
+
+	return p, nil
+}
+
+// splitVCardValue splits a structured value at unescaped separators and
+// unescapes the components
+func splitVCardValue(value string, sep rune) []string {
+	var res []string
+	var b strings.Builder
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped:
+			if r == 'n' || r == 'N' {
+				b.WriteRune('\n')
+			} else {
+				b.WriteRune(r)
+			}
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == sep:
+			res = append(res, b.String())
+			b.Reset()
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return append(res, b.String())
+}
+
+func unescapeVCard(value string) string {
+	return splitVCardValue(value, 0)[0]
+}
+
+func escapeVCard(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`).Replace(value)
+}
+
+// contactFromVCard maps the properties of a card onto a contact. Values are
+// taken as they are; the usual normalization and validation of new contacts
+// applies to them afterwards.
+func contactFromVCard(properties []vCardProperty) Contact {
+	var c Contact
+	var structuredName string
+
+	for _, p := range properties {
+		switch p.Name {
+		case "FN":
+			c.Name = strings.TrimSpace(unescapeVCard(p.Value))
+		case "N":
+			// Family;Given;Additional;Prefixes;Suffixes
+			parts := splitVCardValue(p.Value, ';')
+			var words []string
+			for _, i := range []int{3, 1, 2, 0, 4} {
+				if i < len(parts) && strings.TrimSpace(parts[i]) != "" {
+					words = append(words, strings.TrimSpace(parts[i]))
+				}
+			}
+			structuredName = strings.Join(words, " ")
+		case "TEL":
+			number := strings.TrimPrefix(unescapeVCard(p.Value), "tel:")
+			c.Phones = append(c.Phones, PhoneNumber{Number: number, Label: p.label(), Primary: p.preferred()})
+		case "EMAIL":
+			c.Emails = append(c.Emails, Email{Address: unescapeVCard(p.Value), Label: p.label(), Primary: p.preferred()})
+		case "ADR":
+			// PO box;Extended;Street;Locality;Region;Postal code;Country
+			parts := append(splitVCardValue(p.Value, ';'), make([]string, 7)...)
+			street := strings.TrimSpace(strings.Join(nonEmpty(parts[1], parts[2]), ", "))
+			c.Addresses = append(c.Addresses, Address{
+				Street:     street,
+				City:       parts[3],
+				Region:     parts[4],
+				PostalCode: parts[5],
+				Country:    parts[6],
+				Label:      p.label(),
+				Primary:    p.preferred(),
+			})
+		case "GENDER", vCardGender:
+			sex := strings.ToUpper(splitVCardValue(p.Value, ';')[0])
+			if gender, ok := vCardGenders[sex]; ok {
+				c.Gender = gender
+			} else if sex != "" {
+				c.Gender = strings.ToLower(sex)
+			}
+		case "CATEGORIES":
+			c.Tags = append(c.Tags, splitVCardValue(p.Value, ',')...)
+		case vCardCountry:
+			c.Country = unescapeVCard(p.Value)
+		case vCardFavorite:
+			c.Favorite, _ = strconv.ParseBool(p.Value)
+		default:
+			if strings.HasPrefix(p.Name, "X-") {
+				if c.Custom == nil {
+					c.Custom = make(map[string]string)
+				}
+				c.Custom[p.Name] = unescapeVCard(p.Value)
+			}
+		}
+	}
+	if c.Name == "" {
+		c.Name = structuredName
+	}
+
+	return c
+}
+
+// vCardCustomName returns the X- property a custom field is written as. Keys
+// which are not made of letters, digits and dashes, or which would be read
+// back as another field, cannot be written.
+func vCardCustomName(key string) (string, bool) {
+	name := strings.ToUpper(key)
+	if !strings.HasPrefix(name, "X-") {
+		name = "X-" + name
+	}
+	if name == "X-" {
+		return "", false
+	}
+	for _, r := range name {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return "", false
+		}
+	}
+	switch name {
+	case vCardCountry, vCardFavorite, vCardGender:
+		return "", false
+	}
+
+	return name, true
+}
+
+func nonEmpty(values ...string) []string {
+	var res []string
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			res = append(res, v)
+		}
+	}
+
+	return res
+}
+
+// EncodeVCard writes a contact as a vCard 4.0 card
+func EncodeVCard(w io.Writer, c Contact) error {
+	c.normalizeLists()
+
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	params := func(label string, primary bool) string {
+		res := ""
+		if label == LabelMobile {
+			label = "cell"
+		}
+		if label != "" {
+			res += ";TYPE=" + escapeVCard(label)
+		}
+		if primary {
+			res += ";PREF=1"
+		}
+		return res
+	}
+
+	add("BEGIN:VCARD")
+	add("VERSION:4.0")
+	add("UID:urn:uuid:%v", c.ID)
+	add("FN:%v", escapeVCard(c.Name))
+	// Names are stored whole, the last word is taken for the family name
+	given, family := c.Name, ""
+	if words := strings.Fields(c.Name); len(words) > 1 {
+		given, family = strings.Join(words[:len(words)-1], " "), words[len(words)-1]
+	}
+	add("N:%v;%v;;;", escapeVCard(family), escapeVCard(given))
+	for _, v := range c.Phones {
+		add("TEL;VALUE=uri%v:tel:%v", params(v.Label, v.Primary), v.Number)
+	}
+	for _, v := range c.Emails {
+		add("EMAIL%v:%v", params(v.Label, v.Primary), escapeVCard(v.Address))
+	}
+	for _, v := range c.Addresses {
+		add("ADR%v:;;%v;%v;%v;%v;%v", params(v.Label, v.Primary), escapeVCard(v.Street), escapeVCard(v.City),
+			escapeVCard(v.Region), escapeVCard(v.PostalCode), escapeVCard(v.Country))
+	}
+	if c.Gender != "" {
+		sex := ""
+		for k, v := range vCardGenders {
+			if v == c.Gender {
+				sex = k
+			}
+		}
+		if sex == "" {
+			add("GENDER:;%v", escapeVCard(c.Gender))
+		} else {
+			add("GENDER:%v", sex)
+		}
+	}
+	if len(c.Tags) > 0 {
+		tags := make([]string, 0, len(c.Tags))
+		for _, v := range c.Tags {
+			tags = append(tags, escapeVCard(v))
+		}
+		add("CATEGORIES:%v", strings.Join(tags, ","))
+	}
+	if c.Country != "" {
+		add("%v:%v", vCardCountry, c.Country)
+	}
+	if c.Favorite {
+		add("%v:true", vCardFavorite)
+	}
+	custom := make([]string, 0, len(c.Custom))
+	for k := range c.Custom {
+		custom = append(custom, k)
+	}
+	sort.Strings(custom)
+	for _, k := range custom {
+		if name, ok := vCardCustomName(k); ok {
+			add("%v:%v", name, escapeVCard(c.Custom[k]))
+		}
+	}
+	if !c.UpdatedAt.IsZero() {
+		add("REV:%v", c.UpdatedAt.UTC().Format("20060102T150405Z"))
+	}
+	add("END:VCARD")
+
+	for _, line := range lines {
+		_, err := io.WriteString(w, foldVCardLine(line)+"\r\n")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// foldVCardLine breaks a line into chunks of at most vCardLineLength octets
+// without splitting UTF-8 sequences
+func foldVCardLine(line string) string {
+	var b strings.Builder
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > vCardLineLength {
+			b.WriteString("\r\n ")
+			length = 1
+		}
+		b.WriteRune(r)
+		length += size
+	}
+
+	return b.String()
+}
+
+// VCardProperties returns the unescaped values of the properties a contact is
+// written with by EncodeVCard, by property name. Phone numbers come without
+// their "tel:" scheme.
+func VCardProperties(c Contact) map[string][]string {
+	var b strings.Builder
+	_ = EncodeVCard(&b, c)
+
+	lines, _ := unfoldVCard(strings.NewReader(b.String()))
+	res := make(map[string][]string)
+	for _, line := range lines {
+		p, err := parseVCardLine(line)
+		if err != nil || p.Name == "BEGIN" || p.Name == "END" || p.Name == "VERSION" {
+			continue
+		}
+		value := unescapeVCard(p.Value)
+		if p.Name == "TEL" {
+			value = strings.TrimPrefix(value, "tel:")
+		}
+		res[p.Name] = append(res[p.Name], value)
+	}
+
+	return res
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// exchangeFixture is a contact using every field vCard and CSV carry
+func exchangeFixture() Contact {
+	return Contact{
+		ID:   "0b6f3c3e-7f1c-4c36-9a57-0c3e0d2f6a11",
+		Name: "Zoë van der Berg, Jr.",
+		Phones: []PhoneNumber{
+			{Number: "+79161234567", Label: LabelMobile, Primary: true},
+			{Number: "+12025550100", Label: "work"},
+		},
+		Emails: []Email{{Address: "zoe@example.com", Label: "home", Primary: true}},
+		Addresses: []Address{
+			{Street: "1 Main St, Apt 2", City: "Springfield", Region: "IL", PostalCode: "62701", Country: "US", Label: "home", Primary: true},
+		},
+		Gender:   "female",
+		Country:  "US",
+		Favorite: true,
+		Tags:     []string{"friends", "book club"},
+		Custom:   map[string]string{"department": "Sales"},
+	}
+}
+
+// exchanged keeps the fields of a contact that vCard and CSV carry, with the
+// primary entries of its lists marked the way they are stored
+func exchanged(c Contact) Contact {
+	c.normalizeLists()
+
+	return Contact{
+		Name: c.Name, Phone: c.Phone, Phones: c.Phones, Emails: c.Emails, Addresses: c.Addresses,
+		Gender: c.Gender, Country: c.Country, Favorite: c.Favorite, Tags: c.Tags, Custom: c.Custom,
+	}
+}
+
+func decodeOneVCard(t *testing.T, card string) Contact {
+	cards, err := DecodeVCards(strings.NewReader(card))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 1 || cards[0].Err != nil {
+		t.Fatalf("decoded %+v, want one card", cards)
+	}
+
+	return cards[0].Contact
+}
+
+func TestVCardRoundTrip(t *testing.T) {
+	want := exchangeFixture()
+
+	var b strings.Builder
+	err := EncodeVCard(&b, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeOneVCard(t, b.String())
+
+	// Custom fields come back as X- properties until replaced into the
+	// stored contact
+	if got.Custom["X-DEPARTMENT"] != "Sales" {
+		t.Fatalf("custom fields %v, want X-DEPARTMENT", got.Custom)
+	}
+	got = VCardCard{Contact: got}.Replace(want)
+	if !reflect.DeepEqual(exchanged(got), exchanged(want)) {
+		t.Fatalf("round trip gave\n%+v\nwant\n%+v", exchanged(got), exchanged(want))
+	}
+}
+
+func TestVCardFoldsLongLines(t *testing.T) {
+	want := Contact{Name: strings.Repeat("é", 100)}
+
+	var b strings.Builder
+	err := EncodeVCard(&b, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(b.String(), "\r\n") {
+		if len(line) > vCardLineLength {
+			t.Fatalf("line of %v octets: %q", len(line), line)
+		}
+	}
+
+	got := decodeOneVCard(t, b.String())
+	if got.Name != want.Name {
+		t.Fatalf("name %q after folding", got.Name)
+	}
+}
+
+func TestVCardSkipsCustomKeysItCannotWrite(t *testing.T) {
+	c := Contact{Name: "Ann Lee", Custom: map[string]string{
+		"cost center": "42", "a;b": "1", "gender": "x", "X-ROOM": "7", "floor-2": "3",
+	}}
+
+	properties := VCardProperties(c)
+	for _, name := range []string{"X-ROOM", "X-FLOOR-2"} {
+		if len(properties[name]) != 1 {
+			t.Errorf("%v is not written: %v", name, properties)
+		}
+	}
+	for name := range properties {
+		if strings.Contains(name, " ") || strings.Contains(name, ";") {
+			t.Errorf("wrote property %q", name)
+		}
+	}
+	if _, ok := properties[vCardGender]; ok {
+		t.Errorf("the custom gender field is written as %v", vCardGender)
+	}
+}
+
+func TestDecodeVCardsFromPhones(t *testing.T) {
+	stream := strings.Join([]string{
+		"NOTE:stray line",
+		"BEGIN:VCARD",
+		"VERSION:3.0",
+		"N:Lee;Ann;;Dr.;",
+		"item1.TEL;TYPE=CELL,pref:+7 916 123-45-67",
+		"TEL;HOME:8 495 123 45 67",
+		"EMAIL;TYPE=INTERNET:ann@example.com",
+		"CATEGORIES:friends,work\\,old",
+		"X-GENDER:F",
+		"X-FAVORITE:true",
+		"X-ANNIVERSARY:2001-05-01",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"FN:Broken",
+		"no colon here",
+		"TEL:1",
+		"END:VCARD",
+		"BEGIN:VCARD",
+		"FN:Bob Ray",
+	}, "\r\n")
+
+	cards, err := DecodeVCards(strings.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 4 {
+		t.Fatalf("decoded %v cards, want 4: %+v", len(cards), cards)
+	}
+	if !errors.Is(cards[0].Err, errVCardNoBegin) || cards[2].Err == nil || cards[3].Err == nil {
+		t.Fatalf("errors %v, %v and %v", cards[0].Err, cards[2].Err, cards[3].Err)
+	}
+
+	got := cards[1].Contact
+	want := Contact{
+		Name: "Dr. Ann Lee",
+		Phones: []PhoneNumber{
+			{Number: "+7 916 123-45-67", Label: LabelMobile, Primary: true},
+			{Number: "8 495 123 45 67", Label: "home"},
+		},
+		Emails:   []Email{{Address: "ann@example.com"}},
+		Gender:   "female",
+		Favorite: true,
+		Tags:     []string{"friends", "work,old"},
+		Custom:   map[string]string{"X-ANNIVERSARY": "2001-05-01"},
+	}
+	if cards[1].Err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded\n%+v\nwant\n%+v", got, want)
+	}
+	if !cards[1].Names[vCardFavorite] || cards[1].Names["FN"] {
+		t.Errorf("property names %v", cards[1].Names)
+	}
+}
+
+func TestVCardReplaceKeepsWhatTheCardLeavesOut(t *testing.T) {
+	stored := Contact{
+		ID: "ann", Name: "Ann", Aliases: []string{"old"}, Gender: "male", Country: "RU", Favorite: true,
+		Provenance: map[string]string{"gender": ProvenanceEnriched, "country": ProvenanceEnriched},
+		Custom:     map[string]string{"department": "Sales", "cost center": "42"},
+	}
+	card := decodeOneVCard(t, "BEGIN:VCARD\r\nFN:Ann Lee\r\nGENDER:F\r\nX-DEPARTMENT:Support\r\nEND:VCARD\r\n")
+
+	got := VCardCard{Contact: card, Names: map[string]bool{"FN": true, "GENDER": true, "X-DEPARTMENT": true}}.Replace(stored)
+	if got.ID != "ann" || got.Name != "Ann Lee" || !reflect.DeepEqual(got.Aliases, stored.Aliases) {
+		t.Errorf("id %v, name %q, aliases %v", got.ID, got.Name, got.Aliases)
+	}
+	if got.Gender != "female" || got.Provenance["gender"] != ProvenanceUser {
+		t.Errorf("gender %q from %q, want female entered by the user", got.Gender, got.Provenance["gender"])
+	}
+	if got.Country != "RU" || got.Provenance["country"] != ProvenanceEnriched || !got.Favorite {
+		t.Errorf("country %q from %q, favorite %v, want the stored ones", got.Country, got.Provenance["country"], got.Favorite)
+	}
+	if !reflect.DeepEqual(got.Custom, map[string]string{"department": "Support", "cost center": "42"}) {
+		t.Errorf("custom fields %v", got.Custom)
+	}
+	if stored.Provenance["gender"] != ProvenanceEnriched {
+		t.Error("replacing changed the provenance of the stored contact")
+	}
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/sgnl-05/contactService/utils"
+)
+
+// Webhook is a subscription of a URL to changes. Events lists the change
+// types sent, all of them when empty. Secret signs the deliveries.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DeadLetter is a delivery of a change that failed every attempt, kept until
+// it is redelivered or dropped
+type DeadLetter struct {
+	ID         string    `json:"id"`
+	WebhookID  string    `json:"webhook_id"`
+	Change     Change    `json:"change"`
+	Attempts   int       `json:"attempts"`
+	LastStatus int       `json:"last_status,omitempty"`
+	LastError  string    `json:"last_error"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// Validate checks the URL and event types of a webhook
+func (w Webhook) Validate() []utils.FieldError {
+	var fields []utils.FieldError
+
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fields = append(fields, utils.FieldError{Field: "url", Code: utils.CodeInvalidFormat, Message: "url must be an absolute http or https URL"})
+	}
+	for _, v := range w.Events {
+		if !IsChangeType(v) {
+			fields = append(fields, utils.FieldError{Field: "events", Code: utils.CodeInvalidValue, Message: "events must be change types such as create, update or delete"})
+			break
+		}
+	}
+
+	return fields
+}
+
+// Wants reports whether the webhook subscribes to a change type
+func (w Webhook) Wants(changeType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, v := range w.Events {
+		if v == changeType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,28 +3,123 @@ package utils
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 )
 
-type errorData struct {
+// Validation error codes reported in FieldError.Code
+const (
+	CodeRequired      = "required"
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeInvalidFormat = "invalid_format"
+	CodeInvalidValue  = "invalid_value"
+	CodeInvalidLength = "invalid_length"
+	CodeUnknownCode   = "unknown_calling_code"
+	CodeUnknownRegion = "unknown_region"
+	CodeReadOnly      = "read_only"
+)
+
+// FieldError describes why a single request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
 	Message string `json:"message"`
 }
 
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// DuplicateError reports a value that must be unique and already belongs to
+// another contact
+type DuplicateError struct {
+	Field      string
+	Value      string
+	ExistingID string
+}
+
+func (e DuplicateError) Error() string {
+	return fmt.Sprintf("%v %v already belongs to contact \"%v\"", e.Field, e.Value, e.ExistingID)
+}
+
+type errorData struct {
+	Message    string       `json:"message"`
+	Fields     []FieldError `json:"fields,omitempty"`
+	ExistingID string       `json:"existing_id,omitempty"`
+}
+
 type errorResponse struct {
 	Error errorData `json:"error"`
 }
 
 var (
-	ErrAlreadyFav        = errors.New("contact already in favorites")
-	ErrAlreadyNotFav     = errors.New("contact not in favorites already")
-	ErrFavWrongFormat    = errors.New("wrong request format, please use id={id}&action=add|remove")
-	ErrFilterWrongFormat = errors.New("wrong request format, please use field=name|phone&value={string}")
-	ErrContactNotFound   = errors.New("contact not found")
+	ErrAlreadyFav             = errors.New("contact already in favorites")
+	ErrAlreadyNotFav          = errors.New("contact not in favorites already")
+	ErrFavWrongFormat         = errors.New("wrong request format, please use id={id}&action=add|remove")
+	ErrFilterWrongFormat      = errors.New("wrong request format, please use field=name|phone|email|address|tag|alias&value={string}")
+	ErrContactNotFound        = errors.New("contact not found")
+	ErrWebhookNotFound        = errors.New("webhook not found")
+	ErrDeadLetterNotFound     = errors.New("dead letter not found")
+	ErrAPIKeyNotFound         = errors.New("API key not found")
+	ErrBookNotFound           = errors.New("address book not found")
+	ErrBookForbidden          = errors.New("your role in the address book does not allow this request")
+	ErrUnauthenticated        = errors.New("missing or invalid credentials, please send an API key in the X-API-Key header or a bearer token")
+	ErrForeignTenant          = errors.New("API keys can only be issued for the book of the request")
+	ErrRateLimited            = errors.New("too many requests")
+	ErrBodyTooLarge           = errors.New("request body too large")
+	ErrForbidden              = errors.New("the credentials lack the scope this request needs")
+	ErrAtWrongFormat          = errors.New("wrong request format, please use at={RFC 3339 time}")
+	ErrDuplicatesWrongFormat  = errors.New("wrong request format, please use by=phone|name&threshold={number between 0 and 1}")
+	ErrIdempotencyKeyReused   = errors.New("idempotency key was already used for a different request")
+	ErrIdempotencyKeyInFlight = errors.New("a request with this idempotency key is still being processed")
+	ErrBulkWrongFormat        = errors.New("wrong operation, please use op=create|update|delete")
+	ErrBulkSkipped            = errors.New("not applied because another operation failed")
+	ErrBulkModeWrongFormat    = errors.New("wrong bulk mode, please use mode=atomic|best_effort")
+	ErrExportWrongFormat      = errors.New("wrong request format, please use format=vcard|csv with id={id} or field={field}&value={string} to narrow the export down")
+	ErrImportWrongFormat      = errors.New("wrong content type, please send text/vcard or text/csv")
+	ErrRowWrongFormat         = errors.New("row could not be read")
+	ErrCSVMappingWrongFormat  = errors.New("wrong mapping, please use mapping={\"header\": \"field\"} or preset=google|outlook")
+	ErrCardWrongFormat        = errors.New("card could not be read")
+	ErrRevertWrongFormat      = errors.New("wrong request format, please send either {\"version\": n} or {\"at\": \"{RFC 3339 time}\"}")
+	ErrNotInTrash             = errors.New("contact not in trash")
+	ErrChangesWrongFormat     = errors.New("wrong request format, please use since={cursor}|now&limit=1..1000&wait={duration up to 60s}")
+	ErrEventsWrongFormat      = errors.New("wrong request format, please use types=create,update,favorite,delete,restore,purge,revert,merge&tag={tag} with a numeric Last-Event-ID")
+	ErrResyncRequired         = errors.New("resync required: the cursor is not in the change log anymore, take a new one with since=now and read the contacts again")
+	ErrValidationFailed       = errors.New("request validation failed")
+	ErrCountryUnknown         = errors.New("country must be an ISO 3166-1 alpha-2 or alpha-3 code or an English country name")
+	ErrPhoneWrongFormat       = errors.New("phone number must contain only digits, spaces, dashes, dots, parentheses and a leading \"+\"")
+	ErrPhoneWrongLength       = errors.New("phone number has a wrong length")
+	ErrPhoneUnknownCode       = errors.New("phone number has an unknown country calling code")
+	ErrEmailWrongFormat       = errors.New("e-mail address must look like \"name@example.com\"")
+	ErrListOptionsWrongFormat = errors.New("wrong list options, please use sort=name|created_at|updated_at&order=asc|desc with RFC 3339 updated_since and created_since")
+	ErrTagWrongFormat         = errors.New("wrong request format, please use /api/tags/{tag}/members with a non-empty tag")
+	ErrTagTooLong             = errors.New("tags must have at most 64 characters")
+	ErrPhoneNoRegion          = errors.New("no numbering plan for the contact's country, use the international \"+\" format")
 )
 
 func SendCustomError(w http.ResponseWriter, status int, message string) {
+	SendValidationError(w, status, message, nil)
+}
+
+// SendValidationError reports every rejected field of a request at once
+func SendValidationError(w http.ResponseWriter, status int, message string, fields []FieldError) {
 	var errData errorData
 	errData.Message = message
+	errData.Fields = fields
+	sendErrorData(w, status, errData)
+}
+
+// SendDuplicateError answers with 409 and the ID of the contact holding the
+// value
+func SendDuplicateError(w http.ResponseWriter, e DuplicateError) {
+	var errData errorData
+	errData.Message = e.Error()
+	errData.ExistingID = e.ExistingID
+	sendErrorData(w, http.StatusConflict, errData)
+}
+
+func sendErrorData(w http.ResponseWriter, status int, errData errorData) {
 	var errResp errorResponse
 	errResp.Error = errData
 